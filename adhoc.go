@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adhocQueueLength is the number of ad-hoc jobs that can wait in the
+// priority lane
+const adhocQueueLength = 20
+
+// adhocHistory is the number of finished ad-hoc jobs kept for the status
+// page
+const adhocHistory = 20
+
+// States of an ad-hoc job
+const (
+	adhocQueued   = "queued"
+	adhocRunning  = "running"
+	adhocFinished = "finished"
+)
+
+// AdhocJob is a download of a run requested through the control API of
+// -watch
+type AdhocJob struct {
+	ID       int        `json:"id"`
+	Run      string     `json:"run"`              // Run as given with -run
+	Params   string     `json:"params,omitempty"` // -params, empty for those of the watcher
+	State    string     `json:"state"`            // queued, running or finished
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Status   int        `json:"status"` // Exit status of the download once finished
+}
+
+// adhocLane runs the ad-hoc jobs one after another. While jobs are waiting
+// or running, the runs of the watcher are paused and their download slots
+// are taken over by the jobs as they become free, so that an urgent request
+// is not stuck behind the downloads of a backlog.
+type adhocLane struct {
+	mu      sync.Mutex
+	jobs    []*AdhocJob // Jobs queued, running and recently finished, oldest first
+	nextID  int
+	queue   chan *AdhocJob
+	pause   string // Pause file of the runs of the watcher
+	running sync.WaitGroup
+	stopped bool
+}
+
+// adhocPauseFileName is the name of the file in the output directory that
+// pauses the runs of -watch while ad-hoc jobs are waiting or running
+func adhocPauseFileName(model string) string {
+	return ".watch-" + model + ".pause"
+}
+
+// adhocJobs is the priority lane of -watch, or nil without -control-addr
+var adhocJobs *adhocLane
+
+// validateControlAddr checks -control-addr: without -control-token, the
+// control API may only listen on a loopback address
+func validateControlAddr(addr, token string) error {
+	if addr == "" || token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%s is not a loopback address, set -control-token to listen on it", addr)
+	}
+	return nil
+}
+
+// startControlAPI serves the control API for ad-hoc jobs of -watch on
+// -control-addr. With a token, requests must carry it as a bearer token.
+func startControlAPI(addr, token string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// Web pages open in a browser on the same host must not queue jobs
+		if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+			http.Error(w, "cross-site request", http.StatusForbidden)
+			return
+		}
+		serveAdhocJobs(w, r)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Printf("Warning: control API stopped: %v", err)
+		}
+	}()
+	log.Printf("Serving the control API on http://%s/jobs", listener.Addr())
+	return nil
+}
+
+// serveAdhocJobs lists the ad-hoc jobs as JSON on GET, and queues one on
+// POST with the form values run and optionally params
+func serveAdhocJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(adhocJobs.list())
+	case http.MethodPost:
+		job, err := adhocJobs.submit(r.FormValue("run"), r.FormValue("params"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// startAdhocLane starts running the ad-hoc jobs submitted
+func startAdhocLane() {
+	adhocJobs = &adhocLane{
+		queue: make(chan *AdhocJob, adhocQueueLength),
+		pause: filepath.Join(*outputDir, adhocPauseFileName(selectedModel.Name)),
+	}
+	// A pause file left by a watcher that was killed would stop the runs
+	adhocJobs.setPaused(false)
+	go adhocJobs.process()
+}
+
+// runEnv returns the environment of the run processes of the watcher, which
+// pause for the ad-hoc jobs
+func (l *adhocLane) runEnv() []string {
+	if l == nil {
+		return nil
+	}
+	return []string{jobPauseEnv + "=" + l.pause}
+}
+
+// setPaused creates or removes the pause file of the runs of the watcher
+func (l *adhocLane) setPaused(paused bool) {
+	var err error
+	if paused {
+		err = os.WriteFile(l.pause, nil, 0644)
+	} else if err = os.Remove(l.pause); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		log.Printf("Warning: failed to update %s: %v", l.pause, err)
+	}
+}
+
+// submit queues an ad-hoc download of a run, given as with -run, with the
+// parameters of the watcher or the given -params
+func (l *adhocLane) submit(run, params string) (AdhocJob, error) {
+	selection, err := parseRunSelection(strings.TrimSpace(run))
+	if err != nil {
+		return AdhocJob{}, err
+	}
+	params = strings.TrimSpace(params)
+	if params != "" {
+		if _, _, err := parseParamList(params); err != nil {
+			return AdhocJob{}, fmt.Errorf("invalid params: %v", err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return AdhocJob{}, fmt.Errorf("stopping")
+	}
+	job := &AdhocJob{
+		ID:     l.nextID + 1,
+		Run:    formatRunSelection(selection),
+		Params: params,
+		State:  adhocQueued,
+		Queued: time.Now().UTC(),
+	}
+	select {
+	case l.queue <- job:
+	default:
+		return AdhocJob{}, fmt.Errorf("%d ad-hoc jobs are waiting already", adhocQueueLength)
+	}
+	l.nextID++
+	l.jobs = append(l.jobs, job)
+	l.setPaused(true)
+	log.Printf("Queued ad-hoc job %d for run %s", job.ID, job.Run)
+	return *job, nil
+}
+
+// process runs the queued jobs until the lane is stopped
+func (l *adhocLane) process() {
+	for job := range l.queue {
+		l.mu.Lock()
+		if l.stopped {
+			l.mu.Unlock()
+			return
+		}
+		l.running.Add(1)
+		started := time.Now().UTC()
+		job.State = adhocRunning
+		job.Started = &started
+		l.mu.Unlock()
+
+		status := runAdhocJob(job)
+
+		l.mu.Lock()
+		finished := time.Now().UTC()
+		job.State = adhocFinished
+		job.Finished = &finished
+		job.Status = status
+		l.prune()
+		if len(l.queue) == 0 {
+			l.setPaused(false)
+		}
+		l.mu.Unlock()
+		l.running.Done()
+	}
+}
+
+// prune forgets the oldest finished jobs beyond adhocHistory
+func (l *adhocLane) prune() {
+	finished := 0
+	for i := len(l.jobs) - 1; i >= 0; i-- {
+		if l.jobs[i].State != adhocFinished {
+			continue
+		}
+		if finished++; finished > adhocHistory {
+			l.jobs = slices.Delete(l.jobs, i, i+1)
+		}
+	}
+}
+
+// list returns copies of the jobs, newest first
+func (l *adhocLane) list() []AdhocJob {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	jobs := make([]AdhocJob, 0, len(l.jobs))
+	for i := len(l.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, *l.jobs[i])
+	}
+	return jobs
+}
+
+// stop waits for the running job and drops the queued ones
+func (l *adhocLane) stop() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.stopped = true
+	if queued := len(l.queue); queued > 0 {
+		log.Printf("Warning: dropping %d queued ad-hoc jobs", queued)
+	}
+	l.mu.Unlock()
+	l.running.Wait()
+	l.setPaused(false)
+}
+
+// runAdhocJob downloads the run of an ad-hoc job by a process of its own,
+// like the runs of the watcher, with the download slots they leave while
+// they are paused
+func runAdhocJob(job *AdhocJob) int {
+	log.Printf("Starting ad-hoc job %d for run %s ahead of the other downloads", job.ID, job.Run)
+	lane, err := newJobSlots(0)
+	if err != nil {
+		log.Printf("Cannot start ad-hoc job %d: %v", job.ID, err)
+		return 1
+	}
+	defer lane.r.Close()
+	transfer := transferSlots(sharedSlots, lane, *maxConcurrent)
+	defer transfer.stop()
+
+	child := childProcess{
+		name: fmt.Sprintf("ad-hoc-%d", job.ID),
+		args: []string{"-last-runs=0", "-latest=false", "-run", job.Run, "-watch=false", "-watch-schedule=", "-status-addr=", "-control-addr=", "-control-token="},
+	}
+	if job.Params != "" {
+		child.args = append(child.args, "-params", job.Params, "-params-file=")
+	}
+	return runChildrenSharing("job", []childProcess{child}, lane)
+}
+
+// slotTransfer moves download slots from one pool to another as they are
+// returned, so that the downloads sharing the other pool take over
+type slotTransfer struct {
+	mu       sync.Mutex
+	from, to *jobSlots
+	moved    int
+	stopped  bool
+}
+
+// transferSlots starts moving up to n slots between the pools
+func transferSlots(from, to *jobSlots, n int) *slotTransfer {
+	t := &slotTransfer{from: from, to: to}
+	go func() {
+		for i := 0; i < n; i++ {
+			from.acquire()
+			t.mu.Lock()
+			if t.stopped {
+				t.mu.Unlock()
+				from.release()
+				return
+			}
+			to.release()
+			t.moved++
+			t.mu.Unlock()
+		}
+	}()
+	return t
+}
+
+// stop returns the slots moved to their pool once the processes sharing
+// the other pool have finished
+func (t *slotTransfer) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	moved := t.moved
+	t.mu.Unlock()
+
+	// With the writing end closed, reading stops at the slots returned
+	// instead of waiting for one held by a process that died
+	t.to.w.Close()
+	slots, err := io.ReadAll(t.to.r)
+	if err != nil {
+		log.Printf("Warning: failed to return download slots: %v", err)
+	}
+	if len(slots) > 0 {
+		if _, err := t.from.w.Write(slots); err != nil {
+			log.Printf("Warning: failed to return download slots: %v", err)
+		}
+	}
+	if lost := moved - len(slots); lost > 0 {
+		log.Printf("Warning: %d download slots were not returned", lost)
+	}
+}
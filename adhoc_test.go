@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPausedRunsLeaveSlotsToAdhocJobs(t *testing.T) {
+	jobPausePoll = 10 * time.Millisecond
+	pool, err := newJobSlots(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.r.Close()
+	defer pool.w.Close()
+	pause := filepath.Join(t.TempDir(), "pause")
+	runs := &jobSlots{r: pool.r, w: pool.w, pause: pause}
+
+	// The runs of the watcher keep taking both slots for short downloads,
+	// with more downloads waiting for them
+	var downloads atomic.Int64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				runs.acquire()
+				downloads.Add(1)
+				time.Sleep(5 * time.Millisecond)
+				runs.release()
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if downloads.Load() == 0 {
+		t.Fatal("runs did not download before the pause")
+	}
+
+	// Once the downloads under way are done, the paused runs start no more,
+	// so the ad-hoc job does not compete with them for the slots
+	if err := os.WriteFile(pause, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	paused := downloads.Load()
+	lane, err := newJobSlots(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lane.r.Close()
+	transfer := transferSlots(pool, lane, 2)
+	for i := 0; i < 2; i++ {
+		acquired := make(chan struct{})
+		go func() {
+			lane.acquire()
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ad-hoc job got %d of 2 slots", i)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := downloads.Load(); n != paused {
+		t.Errorf("runs started %d downloads while paused", n-paused)
+	}
+
+	lane.release()
+	lane.release()
+	transfer.stop()
+	if err := os.Remove(pause); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for downloads.Load() == paused && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if downloads.Load() == paused {
+		t.Error("runs did not resume after the pause")
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestValidateControlAddr(t *testing.T) {
+	tests := []struct {
+		addr, token string
+		ok          bool
+	}{
+		{"", "", true},
+		{"127.0.0.1:8081", "", true},
+		{"[::1]:8081", "", true},
+		{"localhost:8081", "", true},
+		{":8081", "", false},
+		{"0.0.0.0:8081", "", false},
+		{"192.0.2.1:8081", "", false},
+		{":8081", "secret", true},
+		{"8081", "", false},
+	}
+	for _, tt := range tests {
+		err := validateControlAddr(tt.addr, tt.token)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateControlAddr(%q, %q) = %v, want ok %v", tt.addr, tt.token, err, tt.ok)
+		}
+	}
+}
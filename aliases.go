@@ -0,0 +1,72 @@
+package main
+
+import "strings"
+
+// paramAliases map the ecCodes short names and CF standard names of common
+// fields to the DWD parameter names. CF names without a height refer to the
+// fields on levels, e.g. air_temperature to t; the near-surface fields have
+// ecCodes names such as 2t. Names that DWD uses for another field, like the
+// ecCodes w, are left out.
+var paramAliases = map[string]string{
+	// ecCodes short names
+	"2t":   "t_2m",
+	"2d":   "td_2m",
+	"2r":   "relhum_2m",
+	"mx2t": "tmax_2m",
+	"mn2t": "tmin_2m",
+	"10u":  "u_10m",
+	"10v":  "v_10m",
+	"10fg": "vmax_10m",
+	"msl":  "pmsl",
+	"sp":   "ps",
+	"tp":   "tot_prec",
+	"tcc":  "clct",
+	"lcc":  "clcl",
+	"mcc":  "clcm",
+	"hcc":  "clch",
+	"tcwv": "tqv",
+	"sde":  "h_snow",
+	"skt":  "t_g",
+	"z":    "fi",
+	"r":    "relhum",
+	"q":    "qv",
+	"orog": "hsurf",
+	"lsm":  "fr_land",
+
+	// CF standard names
+	"air_temperature":                        "t",
+	"dew_point_temperature":                  "td_2m",
+	"relative_humidity":                      "relhum",
+	"specific_humidity":                      "qv",
+	"eastward_wind":                          "u",
+	"northward_wind":                         "v",
+	"upward_air_velocity":                    "w",
+	"wind_speed_of_gust":                     "vmax_10m",
+	"geopotential":                           "fi",
+	"air_pressure_at_mean_sea_level":         "pmsl",
+	"surface_air_pressure":                   "ps",
+	"precipitation_amount":                   "tot_prec",
+	"cloud_area_fraction":                    "clct",
+	"surface_temperature":                    "t_g",
+	"surface_snow_thickness":                 "h_snow",
+	"surface_altitude":                       "hsurf",
+	"land_area_fraction":                     "fr_land",
+	"atmosphere_mass_content_of_water_vapor": "tqv",
+}
+
+// userParamAliases are the aliases defined under "aliases" in the -config
+// file, taking precedence over built-in aliases of the same name
+var userParamAliases = make(map[string]string)
+
+// resolveParamAlias returns the DWD parameter name of an alias, or the name
+// itself if it is no alias. Aliases match regardless of case.
+func resolveParamAlias(name string) string {
+	key := strings.ToLower(name)
+	if target, ok := userParamAliases[key]; ok {
+		return target
+	}
+	if target, ok := paramAliases[key]; ok {
+		return target
+	}
+	return name
+}
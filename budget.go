@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// budgets holds the parsed -budgets flag, keyed by parameter name or level type
+var budgets map[string]int64
+
+// budgetUsage tracks the planned bytes per budget group across planning
+// rounds. A group is closed once a lead time did not fit, so that later
+// polls do not fill the gap with later lead times.
+var budgetUsage = struct {
+	used   map[string]int64
+	closed map[string]bool
+}{used: make(map[string]int64), closed: make(map[string]bool)}
+
+// parseBudgets parses a comma-separated list of key=size pairs, where the key
+// is a parameter name or a level type, e.g. "model=2G,t=500M". Level types
+// are stored under their full name, e.g. "model-level".
+func parseBudgets(spec string) (map[string]int64, error) {
+	result := make(map[string]int64)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid budget %q, expected e.g. model=2G", part)
+		}
+		size, err := parseByteSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid budget %q: %v", part, err)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if lt, ok := normalizeLevelType(key); ok {
+			key = lt
+		}
+		result[key] = size
+	}
+	return result, nil
+}
+
+// budgetFor returns the budget group and budget of a planned file. A budget
+// for the parameter name takes precedence over one for its level type.
+func budgetFor(f *PlannedFile) (string, int64, bool) {
+	if b, ok := budgets[strings.ToLower(f.Param)]; ok {
+		return f.Param, b, true
+	}
+	if b, ok := budgets[f.Info.LevelType]; ok && f.Info.LevelType != "" {
+		return f.Info.LevelType, b, true
+	}
+	return "", 0, false
+}
+
+// plannedSize returns the exact remote size of a file if it was prefetched,
+// otherwise the possibly rounded size from the listing, or -1 if unknown
+func plannedSize(f *PlannedFile) int64 {
+	if f.RemoteSize >= 0 {
+		return f.RemoteSize
+	}
+	return f.Info.Size
+}
+
+// applyBudgets trims the plan to the -budgets. Within each budget group the
+// files are taken in order of lead time, and all files of a lead time are
+// either kept or dropped together, so the latest lead times are trimmed
+// first. Sizes are taken from the listing or -prefetch; files of unknown size
+// count as zero bytes.
+func applyBudgets(plan []*PlannedFile) []*PlannedFile {
+	if len(budgets) == 0 {
+		return plan
+	}
+
+	// Collect the files of each group and lead time
+	type block struct {
+		leadtime time.Duration
+		files    []*PlannedFile
+		size     int64
+	}
+	groups := make(map[string]map[time.Duration]*block)
+	var kept []*PlannedFile
+	unknown := 0
+	for _, f := range plan {
+		group, _, ok := budgetFor(f)
+		if !ok {
+			kept = append(kept, f)
+			continue
+		}
+		if groups[group] == nil {
+			groups[group] = make(map[time.Duration]*block)
+		}
+		b := groups[group][f.Info.Leadtime]
+		if b == nil {
+			b = &block{leadtime: f.Info.Leadtime}
+			groups[group][f.Info.Leadtime] = b
+		}
+		b.files = append(b.files, f)
+		if size := plannedSize(f); size >= 0 {
+			b.size += size
+		} else {
+			unknown++
+		}
+	}
+
+	if unknown > 0 {
+		log.Printf("Warning: size of %d budgeted files is unknown, use -prefetch to include them in the budgets", unknown)
+	}
+
+	names := make([]string, 0, len(groups))
+	for group := range groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+
+	for _, group := range names {
+		blocks := groups[group]
+		var sorted []*block
+		for _, b := range blocks {
+			sorted = append(sorted, b)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].leadtime < sorted[j].leadtime })
+
+		_, budget, _ := budgetFor(sorted[0].files[0])
+		dropped, droppedBytes, firstDropped := 0, int64(0), time.Duration(-1)
+		for _, b := range sorted {
+			if !budgetUsage.closed[group] && budgetUsage.used[group]+b.size <= budget {
+				budgetUsage.used[group] += b.size
+				kept = append(kept, b.files...)
+				continue
+			}
+			budgetUsage.closed[group] = true
+			if firstDropped < 0 {
+				firstDropped = b.leadtime
+			}
+			dropped += len(b.files)
+			droppedBytes += b.size
+		}
+
+		if dropped > 0 {
+			log.Printf("Warning: budget of %s for %s exceeded, skipping %d files (%s) from lead time +%s on",
+				formatBytes(budget), group, dropped, formatBytes(droppedBytes), formatStep(firstDropped))
+		} else if *verbose {
+			log.Printf("Budget for %s: %s of %s planned", group, formatBytes(budgetUsage.used[group]), formatBytes(budget))
+		}
+	}
+	return kept
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// capDirName is the directory below the output directory holding one
+// directory per mirrored warning feed
+const capDirName = "cap"
+
+// capStateFileName records which archives and alerts of a feed were processed
+const capStateFileName = "state.json"
+
+// capAlert is the part of a CAP alert message needed to deduplicate it
+type capAlert struct {
+	XMLName    xml.Name `xml:"alert"`
+	Identifier string   `xml:"identifier"`
+	Sent       string   `xml:"sent"`
+	MsgType    string   `xml:"msgType"`
+}
+
+// capFeedState is the deduplication state of a mirrored feed
+type capFeedState struct {
+	Archives map[string]time.Time `json:"archives"` // Processed archive names, with the time they were processed
+	Alerts   map[string]string    `json:"alerts"`   // File names of the saved alerts by CAP identifier
+
+	path string
+}
+
+// validateCAPFeeds checks the names given with -cap-feeds
+func validateCAPFeeds(spec string) ([]string, error) {
+	var feeds []string
+	for _, feed := range strings.Split(spec, ",") {
+		feed = strings.TrimSpace(feed)
+		if feed == "" || strings.ContainsAny(feed, `/\`) || feed == "." || feed == ".." {
+			return nil, fmt.Errorf("invalid feed name %q", feed)
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, nil
+}
+
+// mirrorCAPFeeds mirrors each warning feed once, logging failures of single
+// feeds without giving up on the others. It returns the number of feeds
+// that failed.
+func mirrorCAPFeeds(baseURL string, feeds []string) int {
+	failed := 0
+	for _, feed := range feeds {
+		if _, err := mirrorCAPFeed(baseURL, feed); err != nil {
+			log.Printf("Warning: failed to mirror warning feed %s: %v", feed, err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// mirrorCAPFeedsEvery mirrors the feeds at the given interval until stop is
+// closed, or forever if stop is nil
+func mirrorCAPFeedsEvery(baseURL string, feeds []string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			mirrorCAPFeeds(baseURL, feeds)
+		}
+	}
+}
+
+// mirrorCAPFeed downloads the archives of a feed that were not processed
+// yet and saves the alerts they contain that were not seen before. Status
+// feeds (_STAT) publish complete snapshots, so only their newest archive is
+// read; difference feeds (_DIFF) are read archive by archive.
+func mirrorCAPFeed(baseURL, feed string) (int, error) {
+	entries, err := indexClient.List(baseURL + feed + "/")
+	if err != nil {
+		return 0, err
+	}
+
+	dir := filepath.Join(*outputDir, capDirName, feed)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	state, err := loadCAPFeedState(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	// The LATEST files are copies of the newest archive
+	var archives []string
+	urls := make(map[string]string)
+	for _, e := range entries {
+		if e.Dir || strings.Contains(e.Name, "_LATEST") ||
+			!(strings.HasSuffix(e.Name, ".zip") || strings.HasSuffix(e.Name, ".xml")) {
+			continue
+		}
+		archives = append(archives, e.Name)
+		urls[e.Name] = e.URL
+	}
+	sort.Strings(archives)
+
+	// Forget archives that have left the server
+	for name := range state.Archives {
+		if _, listed := urls[name]; !listed {
+			delete(state.Archives, name)
+		}
+	}
+
+	var pending []string
+	for _, name := range archives {
+		if _, done := state.Archives[name]; !done {
+			pending = append(pending, name)
+		}
+	}
+	if strings.HasSuffix(feed, "_STAT") && len(pending) > 1 {
+		for _, name := range pending[:len(pending)-1] {
+			state.Archives[name] = time.Now().UTC()
+		}
+		pending = pending[len(pending)-1:]
+	}
+
+	fetcher := newHTTPFetcher(indexClient)
+	saved := 0
+	for _, name := range pending {
+		n, err := state.processArchive(fetcher, dir, name, urls[name])
+		saved += n
+		if err != nil {
+			// Keep what was saved and try the archive again next time
+			if saveErr := state.save(); saveErr != nil {
+				log.Printf("Warning: failed to save state of warning feed %s: %v", feed, saveErr)
+			}
+			return saved, fmt.Errorf("%s: %v", name, err)
+		}
+		state.Archives[name] = time.Now().UTC()
+	}
+
+	if err := state.save(); err != nil {
+		return saved, err
+	}
+	if saved > 0 || *verbose {
+		log.Printf("Warning feed %s: %d new alerts from %d archives", feed, saved, len(pending))
+	}
+	return saved, nil
+}
+
+// processArchive downloads a zip archive of CAP messages, or a single CAP
+// message, and saves the alerts not seen before
+func (s *capFeedState) processArchive(fetcher httpFetcher, dir, name, url string) (int, error) {
+	body, err := fetcher.Fetch(url)
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	if strings.HasSuffix(name, ".xml") {
+		return s.saveAlert(dir, data)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, fmt.Errorf("not a zip archive: %v", err)
+	}
+	saved := 0
+	for _, member := range archive.File {
+		if !strings.HasSuffix(member.Name, ".xml") {
+			continue
+		}
+		r, err := member.Open()
+		if err != nil {
+			return saved, err
+		}
+		message, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return saved, fmt.Errorf("%s: %v", member.Name, err)
+		}
+		n, err := s.saveAlert(dir, message)
+		if err != nil {
+			return saved, fmt.Errorf("%s: %v", member.Name, err)
+		}
+		saved += n
+	}
+	return saved, nil
+}
+
+// saveAlert writes a CAP message named after its identifier unless an
+// alert with the same identifier was saved before. It returns 1 if the
+// alert was new. Invalid messages are skipped with a warning, so that they
+// do not hold up the rest of their archive.
+func (s *capFeedState) saveAlert(dir string, message []byte) (int, error) {
+	var alert capAlert
+	err := xml.Unmarshal(message, &alert)
+	if err == nil && alert.Identifier == "" {
+		err = fmt.Errorf("no identifier")
+	}
+	if err != nil {
+		log.Printf("Warning: skipping invalid CAP message in %s: %v", filepath.Base(dir), err)
+		return 0, nil
+	}
+	if _, seen := s.Alerts[alert.Identifier]; seen {
+		return 0, nil
+	}
+
+	name := sanitizeFilename(alert.Identifier, sanitizePortable) + ".xml"
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, message, 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	s.Alerts[alert.Identifier] = name
+	if *verbose {
+		log.Printf("Saved %s alert %s sent %s", alert.MsgType, alert.Identifier, alert.Sent)
+	}
+	return 1, nil
+}
+
+// loadCAPFeedState reads the state of a feed directory, returning an empty
+// state if none exists yet
+func loadCAPFeedState(dir string) (*capFeedState, error) {
+	s := &capFeedState{
+		Archives: make(map[string]time.Time),
+		Alerts:   make(map[string]string),
+		path:     filepath.Join(dir, capStateFileName),
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", s.path, err)
+	}
+	if s.Archives == nil {
+		s.Archives = make(map[string]time.Time)
+	}
+	if s.Alerts == nil {
+		s.Alerts = make(map[string]string)
+	}
+	return s, nil
+}
+
+// save writes the state atomically
+func (s *capFeedState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// catalogFileName returns the name of the snapshot of the last seen product
+// catalog of a model run hour, stored in the output directory. Runs at
+// different hours may have different forecast lengths, so each hour is
+// compared with its own predecessor.
+func catalogFileName(model, run string) string {
+	return "." + model + "-" + run + "-catalog.json"
+}
+
+// RunCatalog describes the parameters and forecast steps published for a model run
+type RunCatalog struct {
+	Model      string              `json:"model"`
+	Run        string              `json:"run"`
+	Timestamp  time.Time           `json:"timestamp"`
+	Reference  time.Time           `json:"reference_time,omitempty"`
+	Parameters []string            `json:"parameters"`
+	Steps      map[string]stepList `json:"steps"`
+
+	mu sync.Mutex
+}
+
+// catalog collects the listing of the run currently being downloaded
+var catalog *RunCatalog
+
+// newRunCatalog creates a catalog for a model run with the given parameters
+func newRunCatalog(run ModelRun, params []Parameter) *RunCatalog {
+	c := &RunCatalog{
+		Model:     selectedModel.Name,
+		Run:       run.Time,
+		Timestamp: run.Timestamp,
+		Reference: run.ReferenceTime,
+		Steps:     make(map[string]stepList),
+	}
+	for _, p := range params {
+		c.Parameters = append(c.Parameters, p.Name)
+	}
+	sort.Strings(c.Parameters)
+	return c
+}
+
+// recordFiles stores the forecast steps found in a parameter directory listing
+func (c *RunCatalog) recordFiles(param string, files []index.File) {
+	if c == nil {
+		return
+	}
+
+	seen := make(map[time.Duration]bool)
+	var steps stepList
+	for _, file := range files {
+		if !file.HasLeadtime() || seen[file.Leadtime] {
+			continue
+		}
+		seen[file.Leadtime] = true
+		steps = append(steps, file.Leadtime)
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i] < steps[j] })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Steps[param] = steps
+}
+
+// loadRunCatalog reads a previously saved catalog snapshot
+func loadRunCatalog(path string) (*RunCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c RunCatalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if c.Steps == nil {
+		c.Steps = make(map[string]stepList)
+	}
+	return &c, nil
+}
+
+// save writes the catalog snapshot atomically, through a temporary file of
+// its own so that processes saving at the same time do not mix their data
+func (c *RunCatalog) save(path string) error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// reportProductChanges compares the current catalog against the snapshot of
+// the previous run of the same hour, logs added, removed, renamed and
+// re-stepped parameters, and replaces the snapshot with the current catalog
+func reportProductChanges(current *RunCatalog) {
+	path := filepath.Join(*outputDir, catalogFileName(current.Model, current.Run))
+
+	previous, err := loadRunCatalog(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: could not read previous product catalog: %v", err)
+	}
+	if previous != nil {
+		logProductChanges(previous, current)
+		current.inheritSteps(previous)
+	}
+
+	if err := current.save(path); err != nil {
+		log.Printf("Warning: could not save product catalog: %v", err)
+	}
+}
+
+// inheritSteps keeps step tables of parameters that were not listed in this
+// invocation, so the snapshot does not shrink when only a few parameters are downloaded
+func (c *RunCatalog) inheritSteps(previous *RunCatalog) {
+	if previous.Run != c.Run {
+		return
+	}
+
+	published := make(map[string]bool)
+	for _, p := range c.Parameters {
+		published[p] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, steps := range previous.Steps {
+		current, listed := c.Steps[name]
+		if !listed && published[name] {
+			c.Steps[name] = steps
+		} else if listed && !stepTableChanged(steps, current) {
+			// Keep the complete table when this run was still being published
+			c.Steps[name] = steps
+		}
+	}
+}
+
+// logProductChanges logs the differences between two run catalogs
+func logProductChanges(previous, current *RunCatalog) {
+	prevParams := make(map[string]bool)
+	for _, p := range previous.Parameters {
+		prevParams[p] = true
+	}
+	currParams := make(map[string]bool)
+	for _, p := range current.Parameters {
+		currParams[p] = true
+	}
+
+	var added, removed []string
+	for _, p := range current.Parameters {
+		if !prevParams[p] {
+			added = append(added, p)
+		}
+	}
+	for _, p := range previous.Parameters {
+		if !currParams[p] {
+			removed = append(removed, p)
+		}
+	}
+
+	// A removed parameter whose step table reappears under a new name is most likely a rename
+	renamed := make(map[string]string)
+	for _, old := range removed {
+		oldSteps, ok := previous.Steps[old]
+		if !ok {
+			continue
+		}
+		for _, candidate := range added {
+			newSteps, ok := current.Steps[candidate]
+			if !ok || !sameSteps(oldSteps, newSteps) {
+				continue
+			}
+			if _, taken := renamed[candidate]; taken {
+				continue
+			}
+			renamed[candidate] = old
+			break
+		}
+	}
+
+	changes := 0
+	for _, p := range added {
+		if old, ok := renamed[p]; ok {
+			log.Printf("Warning: product change since run %s: parameter %s appears to be renamed to %s", previous.Run, old, p)
+		} else {
+			log.Printf("Warning: product change since run %s: parameter %s was added", previous.Run, p)
+		}
+		changes++
+	}
+	for _, p := range removed {
+		isRenamed := false
+		for _, old := range renamed {
+			if old == p {
+				isRenamed = true
+				break
+			}
+		}
+		if !isRenamed {
+			log.Printf("Warning: product change since run %s: parameter %s was removed", previous.Run, p)
+			changes++
+		}
+	}
+
+	// Compare step tables of parameters listed in both runs. Runs at
+	// different hours may legitimately have different forecast lengths, so
+	// only runs at the same hour are compared, which the snapshots per run
+	// hour ensure.
+	if previous.Run == current.Run {
+		names := make([]string, 0, len(current.Steps))
+		for name := range current.Steps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			oldSteps, ok := previous.Steps[name]
+			if !ok {
+				continue
+			}
+			newSteps := current.Steps[name]
+			if stepTableChanged(oldSteps, newSteps) {
+				log.Printf("Warning: product change since run %s: parameter %s step table changed from %s to %s",
+					previous.Run, name, formatSteps(oldSteps), formatSteps(newSteps))
+				changes++
+			}
+		}
+	}
+
+	if *verbose && changes == 0 {
+		log.Printf("No product changes detected since run %s (%s)", previous.Run, previous.Timestamp.Format("2006-01-02 15:04"))
+	}
+}
+
+// stepTableChanged reports whether a step table differs from the previous one
+// other than by missing trailing steps, which usually means the run is still
+// being published
+func stepTableChanged(previous, current stepList) bool {
+	if len(current) == 0 {
+		return false
+	}
+
+	known := make(map[time.Duration]bool, len(previous))
+	for _, step := range previous {
+		known[step] = true
+	}
+	for _, step := range current {
+		if !known[step] {
+			return true
+		}
+	}
+
+	last := current[len(current)-1]
+	for _, step := range previous {
+		if step > last {
+			break
+		}
+		if !containsStep(current, step) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsStep reports whether a sorted step list contains a step
+func containsStep(steps []time.Duration, step time.Duration) bool {
+	i := sort.Search(len(steps), func(i int) bool { return steps[i] >= step })
+	return i < len(steps) && steps[i] == step
+}
+
+// sameSteps reports whether two sorted step lists are identical
+func sameSteps(a, b stepList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// formatSteps returns a compact description of a step list, e.g. "0h-78h (79 steps)"
+func formatSteps(steps stepList) string {
+	if len(steps) == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%s-%s (%d steps)", formatStep(steps[0]), formatStep(steps[len(steps)-1]), len(steps))
+}
+
+// stepList is a sorted list of forecast steps. In the snapshot whole hours
+// are stored as numbers, as before sub-hourly steps were supported, and
+// other steps as duration strings such as "2h15m".
+type stepList []time.Duration
+
+func (l stepList) MarshalJSON() ([]byte, error) {
+	values := make([]interface{}, len(l))
+	for i, step := range l {
+		if step%time.Hour == 0 {
+			values[i] = int64(step / time.Hour)
+		} else {
+			values[i] = formatStep(step)
+		}
+	}
+	return json.Marshal(values)
+}
+
+func (l *stepList) UnmarshalJSON(data []byte) error {
+	var values []interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	steps := make(stepList, 0, len(values))
+	for _, v := range values {
+		switch v := v.(type) {
+		case float64:
+			steps = append(steps, time.Duration(v*float64(time.Hour)))
+		case string:
+			step, err := parseStep(v)
+			if err != nil {
+				return err
+			}
+			steps = append(steps, step)
+		default:
+			return fmt.Errorf("invalid step %v", v)
+		}
+	}
+	*l = steps
+	return nil
+}
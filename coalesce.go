@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sharedDownloadsEnv passes the directory of the downloads shared by the
+// jobs of runJobs to the job processes
+const sharedDownloadsEnv = "_ICOND_SHARED_DOWNLOADS"
+
+// sharedDownloadKeep is how long a file downloaded by one job is offered to
+// the others, long enough for all jobs to get to the same run
+const sharedDownloadKeep = 24 * time.Hour
+
+// sharedDownloadDir coalesces the downloads of the jobs run side by side:
+// a file requested by several jobs is downloaded by the first job to get to
+// it and linked or copied into the run directories of the others. The
+// directory holds a record per downloaded file, naming the file downloaded
+// and locked while the download is under way.
+type sharedDownloadDir struct {
+	dir string
+}
+
+// sharedDownloads is the directory of the downloads shared with the other
+// jobs, or nil if this process is not a job of a multi-job configuration
+var sharedDownloads *sharedDownloadDir
+
+// sharedDownload is the record of a file downloaded by a job
+type sharedDownload struct {
+	Path string `json:"path"` // File downloaded, in the run directory of the job
+	ManifestEntry
+}
+
+// openSharedDownloads picks up the shared downloads passed by runJobs
+func openSharedDownloads() {
+	if dir := os.Getenv(sharedDownloadsEnv); dir != "" {
+		sharedDownloads = &sharedDownloadDir{dir: dir}
+	}
+}
+
+// newSharedDownloads creates the directory of the downloads shared by the
+// jobs of runJobs and keeps removing its outdated records, e.g. of the
+// earlier runs downloaded by jobs using -watch
+func newSharedDownloads() (*sharedDownloadDir, error) {
+	dir, err := os.MkdirTemp("", "icond-shared-")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for range time.Tick(time.Hour) {
+			pruneSharedDownloads(dir)
+		}
+	}()
+	return &sharedDownloadDir{dir: dir}, nil
+}
+
+// pruneSharedDownloads removes the records older than sharedDownloadKeep
+func pruneSharedDownloads(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && strings.HasSuffix(entry.Name(), ".json") && time.Since(info.ModTime()) > sharedDownloadKeep {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// env returns the environment variable passing the shared downloads to a
+// job process, or nil without shared downloads
+func (s *sharedDownloadDir) env() []string {
+	if s == nil {
+		return nil
+	}
+	return []string{sharedDownloadsEnv + "=" + s.dir}
+}
+
+// remove deletes the directory of the shared downloads once the jobs are
+// done
+func (s *sharedDownloadDir) remove() {
+	if s != nil {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// recordPath returns the record of a planned file. Files are identified by
+// their URL and the -messages subset taken from them, so jobs share only
+// identical files.
+func (s *sharedDownloadDir) recordPath(f *PlannedFile) string {
+	sum := sha256.Sum256([]byte(f.URL + "\n" + *messagesSpec))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// obtain links a file downloaded by another job into its run directory, or
+// downloads it and records the download for the other jobs. Jobs getting
+// to the same file at the same time are serialised with a lock file next to
+// its record.
+func (s *sharedDownloadDir) obtain(f *PlannedFile) (downloadResult, error) {
+	record := s.recordPath(f)
+	result, origin, ok := s.lookup(record, f)
+	if !ok {
+		lock, err := waitForLockFile(strings.TrimSuffix(record, ".json")+".lock", "shared download of "+f.File, invariantLockTimeout)
+		if err != nil {
+			log.Printf("Warning: %v, downloading %s without sharing it", err, f.File)
+			return downloadAndUncompressFile(f.URL, f.LocalPath, *maxRetries)
+		}
+		defer lock.release()
+
+		// Another job may have downloaded the file while we waited
+		result, origin, ok = s.lookup(record, f)
+		if !ok {
+			result, err = downloadAndUncompressFile(f.URL, f.LocalPath, *maxRetries)
+			if err != nil {
+				return result, err
+			}
+			if err := saveSharedDownload(record, f.LocalPath, result); err != nil {
+				log.Printf("Warning: failed to share %s with the other jobs: %v", f.File, err)
+			}
+			return result, nil
+		}
+	}
+
+	if *verbose {
+		log.Printf("Linking %s downloaded by another job from %s", filepath.Base(f.LocalPath), origin)
+	}
+	result.Cached = true
+	result.SharedFrom = origin
+	if path, _ := filepath.Abs(f.LocalPath); path == origin {
+		return result, nil // The jobs share the run directory
+	}
+	return result, linkOrCopy(origin, f.LocalPath)
+}
+
+// lookup returns the recorded download of a file and the path it was
+// downloaded to if that file is intact and, with -prefetch, the remote file
+// has not changed
+func (s *sharedDownloadDir) lookup(record string, f *PlannedFile) (downloadResult, string, bool) {
+	data, err := os.ReadFile(record)
+	if err != nil {
+		return downloadResult{}, "", false
+	}
+	var shared sharedDownload
+	if err := json.Unmarshal(data, &shared); err != nil {
+		return downloadResult{}, "", false
+	}
+	info, err := os.Stat(shared.Path)
+	if err != nil || info.Size() != shared.Size {
+		return downloadResult{}, "", false
+	}
+	if f.RemoteSize >= 0 && shared.Source == f.URL && shared.CompressedSize != f.RemoteSize {
+		return downloadResult{}, "", false
+	}
+	return downloadResult{
+		URL:              shared.Source,
+		Compression:      shared.Compression,
+		CompressedSize:   shared.CompressedSize,
+		CompressedSHA256: shared.CompressedSHA256,
+		Size:             shared.Size,
+		SHA256:           shared.SHA256,
+	}, shared.Path, true
+}
+
+// saveSharedDownload records the download of a file for the other jobs
+func saveSharedDownload(record, path string, result downloadResult) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sharedDownload{
+		Path: path,
+		ManifestEntry: ManifestEntry{
+			Source:           result.URL,
+			Compression:      result.Compression,
+			CompressedSize:   result.CompressedSize,
+			CompressedSHA256: result.CompressedSHA256,
+			Size:             result.Size,
+			SHA256:           result.SHA256,
+			Downloaded:       time.Now().UTC(),
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := record + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, record)
+}
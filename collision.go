@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Strategies for planned files that map to the same local name
+const (
+	collisionError  = "error"  // Abort before downloading anything
+	collisionSuffix = "suffix" // Append _2, _3, ... to the later file names
+	collisionSubdir = "subdir" // Move the later files to a subdirectory named after their parameter
+)
+
+// validateCollisionStrategy checks the value of -collision
+func validateCollisionStrategy(strategy string) error {
+	switch strategy {
+	case collisionError, collisionSuffix, collisionSubdir:
+		return nil
+	}
+	return fmt.Errorf("invalid collision strategy '%s'. Valid values are: %s, %s, %s",
+		strategy, collisionError, collisionSuffix, collisionSubdir)
+}
+
+// resolveCollisions detects planned files that would be written to the same
+// local path and applies the -collision strategy. taken maps local paths that
+// are already in use to the URL writing them and is updated in place.
+func resolveCollisions(plan []*PlannedFile, taken map[string]string) error {
+	// Process files in a stable order so that names do not change between invocations
+	sort.SliceStable(plan, func(i, j int) bool {
+		if plan[i].Param != plan[j].Param {
+			return plan[i].Param < plan[j].Param
+		}
+		return plan[i].Info.Less(plan[j].Info)
+	})
+
+	for _, f := range plan {
+		owner, exists := taken[f.LocalPath]
+		if !exists || owner == f.URL {
+			taken[f.LocalPath] = f.URL
+			continue
+		}
+
+		switch *collisionStrategy {
+		case collisionError:
+			return fmt.Errorf("naming collision: %s and %s would both be saved as %s", owner, f.URL, f.LocalPath)
+
+		case collisionSuffix:
+			ext := filepath.Ext(f.LocalPath)
+			base := strings.TrimSuffix(f.LocalPath, ext)
+			for n := 2; ; n++ {
+				candidate := fmt.Sprintf("%s_%d%s", base, n, ext)
+				if _, used := taken[candidate]; !used {
+					f.LocalPath = candidate
+					break
+				}
+			}
+
+		case collisionSubdir:
+			dir := filepath.Join(filepath.Dir(f.LocalPath), f.Param)
+			candidate := filepath.Join(dir, filepath.Base(f.LocalPath))
+			if _, used := taken[candidate]; used {
+				return fmt.Errorf("naming collision: %s cannot be moved to %s, which is also taken", f.URL, candidate)
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create directory for colliding file: %v", err)
+			}
+			f.LocalPath = candidate
+		}
+
+		log.Printf("Warning: naming collision with %s, saving %s as %s", owner, f.URL, f.LocalPath)
+		taken[f.LocalPath] = f.URL
+	}
+
+	return nil
+}
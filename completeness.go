@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// ParamCompleteness compares the files of a parameter with the number the
+// publication schedule of the model leads to expect
+type ParamCompleteness struct {
+	Expected int `json:"expected"` // Files expected from the schedule
+	Listed   int `json:"listed"`   // Files published when the download was planned
+	Obtained int `json:"obtained"` // Files downloaded or kept
+}
+
+// expectedStepCount returns the number of scheduled steps of a parameter,
+// starting from its first published step as accumulated and extreme value
+// fields start at step 1. It returns false for parameters without forecast
+// steps, models without a schedule and selections by valid time.
+func expectedStepCount(param, runHour string) (int, bool) {
+	// A filtered selection cannot be compared with the full schedule
+	if len(validTimeRanges) > 0 {
+		return 0, false
+	}
+	expected, ok := selectedModel.paramExpectedSteps(runHour, param)
+	if !ok {
+		return 0, false
+	}
+
+	catalog.mu.Lock()
+	published := catalog.Steps[param]
+	catalog.mu.Unlock()
+
+	// Parameters without forecast steps, e.g. time-invariant fields, are not scheduled
+	if len(published) == 0 {
+		return 0, false
+	}
+
+	count := 0
+	for _, step := range expected {
+		if step >= published[0] {
+			count++
+		}
+	}
+	return count, true
+}
+
+// planCompleteness counts the planned files of each parameter and the files
+// expected from the schedule, one per scheduled step and selected level
+func planCompleteness(plan []*PlannedFile, runHour string) map[string]*ParamCompleteness {
+	listed := make(map[string]int)
+	perStep := make(map[string]map[time.Duration]int)
+	for _, f := range plan {
+		listed[f.Param]++
+		if !f.Info.HasLeadtime() {
+			continue
+		}
+		if perStep[f.Param] == nil {
+			perStep[f.Param] = make(map[time.Duration]int)
+		}
+		perStep[f.Param][f.Info.Leadtime]++
+	}
+
+	counts := make(map[string]*ParamCompleteness)
+	for param, steps := range perStep {
+		n, ok := expectedStepCount(param, runHour)
+		if !ok {
+			continue
+		}
+		levels := 0
+		for _, count := range steps {
+			levels = max(levels, count)
+		}
+		counts[param] = &ParamCompleteness{Expected: n * levels, Listed: listed[param]}
+	}
+	return counts
+}
+
+// reportListedCompleteness logs the parameters that have fewer files
+// published than expected before downloading
+func reportListedCompleteness(counts map[string]*ParamCompleteness) {
+	for _, param := range sortedParamNames(counts) {
+		if c := counts[param]; c.Listed < c.Expected {
+			log.Printf("Warning: parameter %s has %d of %d expected files published before downloading",
+				param, c.Listed, c.Expected)
+		}
+	}
+}
+
+// reportObtainedCompleteness logs the parameters that have fewer files than
+// expected after downloading and returns them for the manifest, or nil if
+// all are complete
+func reportObtainedCompleteness(counts map[string]*ParamCompleteness) map[string]*ParamCompleteness {
+	incomplete := make(map[string]*ParamCompleteness)
+	obtainedFiles.mu.Lock()
+	for param, c := range counts {
+		c.Obtained = obtainedFiles.counts[param]
+		if c.Obtained < c.Expected {
+			incomplete[param] = c
+		}
+	}
+	obtainedFiles.mu.Unlock()
+
+	for _, param := range sortedParamNames(incomplete) {
+		c := incomplete[param]
+		log.Printf("Warning: parameter %s has %d of %d expected files after downloading (%d published)",
+			param, c.Obtained, c.Expected, c.Listed)
+	}
+	if len(counts) > 0 {
+		log.Printf("Completeness: %d of %d scheduled parameters complete", len(counts)-len(incomplete), len(counts))
+	}
+	if len(incomplete) == 0 {
+		return nil
+	}
+	return incomplete
+}
+
+// sortedParamNames returns the parameter names of a completeness report in
+// sorted order
+func sortedParamNames(counts map[string]*ParamCompleteness) []string {
+	var names []string
+	for param := range counts {
+		names = append(names, param)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// compressionSuffixes are the suffixes of files published compressed. They
+// are removed from the output names, as files are uncompressed while they
+// are downloaded.
+var compressionSuffixes = []string{".bz2", ".gz"}
+
+// Compression of a downloaded file as recorded in the manifest
+const (
+	compressionNone  = "none"
+	compressionBzip2 = "bzip2"
+	compressionGzip  = "gzip"
+)
+
+// alternateAfterDecodeFailures is the number of downloads of a file that
+// must fail to uncompress before its alternate variant is tried
+const alternateAfterDecodeFailures = 2
+
+// uncompressedName returns a file name without its compression suffix
+func uncompressedName(name string) string {
+	for _, suffix := range compressionSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+// compressionOf returns the compression of a file by the suffix of its name or URL
+func compressionOf(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".bz2"):
+		return compressionBzip2
+	case strings.HasSuffix(name, ".gz"):
+		return compressionGzip
+	}
+	return compressionNone
+}
+
+// decodeError is a failure to uncompress a download, as opposed to a
+// failure to transfer it
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string { return "failed to uncompress: " + e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// newDecompressor returns a reader uncompressing a file according to the
+// suffix of its name or URL. Files published uncompressed are read as is.
+// Errors of the decompressor itself are returned as decodeError.
+func newDecompressor(name string, r io.Reader) (io.Reader, error) {
+	src := &sourceReader{r: r}
+	var d io.Reader
+	switch compressionOf(name) {
+	case compressionBzip2:
+		d = bzip2.NewReader(src)
+	case compressionGzip:
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, src.classify(err)
+		}
+		d = gz
+	default:
+		return r, nil
+	}
+	return &decodingReader{r: d, src: src}, nil
+}
+
+// sourceReader remembers the last error of the compressed stream
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// classify returns errors of the compressed stream as is and wraps all
+// others in decodeError
+func (s *sourceReader) classify(err error) error {
+	if err == nil || err == io.EOF || s.err != nil {
+		return err
+	}
+	return &decodeError{err}
+}
+
+// decodingReader reads from a decompressor and classifies its errors
+type decodingReader struct {
+	r   io.Reader
+	src *sourceReader
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	return n, d.src.classify(err)
+}
+
+// alternateURL returns the URL of the other variant of a DWD GRIB file, the
+// uncompressed file for a .bz2 file and vice versa, or "" if there is none
+func alternateURL(url string) string {
+	if messageFilter != nil || (selectedModel.Provider != "" && selectedModel.Provider != "dwd") {
+		return ""
+	}
+	switch {
+	case strings.HasSuffix(url, ".grib2.bz2"):
+		return strings.TrimSuffix(url, ".bz2")
+	case strings.HasSuffix(url, ".grib2"):
+		return url + ".bz2"
+	}
+	return ""
+}
+
+// alternateAvailable reports whether a HEAD request finds the alternate variant
+func alternateAvailable(url string) bool {
+	client := &http.Client{Timeout: 30 * time.Second, Transport: httpTransport()}
+	resp, err := client.Head(indexClient.HTTPURL(url))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// isDecodeError reports whether err is a failure to uncompress a download
+func isDecodeError(err error) bool {
+	var d *decodeError
+	return errors.As(err, &d)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration keys holding sections rather than options
+const (
+	configModelsKey   = "models"   // Per-model defaults
+	configProfilesKey = "profiles" // Named option sets selected with -profile
+	configJobsKey     = "jobs"     // Download jobs run side by side
+	configGroupsKey   = "groups"   // Parameter groups usable as group:name in -params
+	configAliasesKey  = "aliases"  // Parameter aliases usable in -params
+)
+
+// envPrefix prefixes the environment variables setting options. The
+// variables passed to hooks use hookEnvPrefix and those passed to child
+// processes a leading underscore, so that no option takes them for its own.
+const envPrefix = "ICOND_"
+
+// envName returns the environment variable of a flag, e.g.
+// ICOND_BASE_URL for -base-url
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadEnvironment sets the flags not given on the command line from their
+// ICOND_ environment variables. Empty variables are ignored. Flags
+// set here count as given for loadConfig, so the environment overrides the
+// configuration file.
+func loadEnvironment() error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		value := os.Getenv(envName(f.Name))
+		if err != nil || explicit[f.Name] || value == "" {
+			return
+		}
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s=%s: %v", envName(f.Name), value, setErr)
+		}
+	})
+	return err
+}
+
+// configSetting is an option value from the configuration file
+type configSetting struct {
+	flag  *flag.Flag
+	value string
+	line  int
+}
+
+// loadConfig sets the flags not given on the command line or in the
+// environment from a YAML configuration file. Top-level keys are flag names
+// without the dash. The "models" key maps model names to values that apply
+// only when that model is selected, the "profiles" key maps profile names
+// to values that apply when the profile is selected with -profile, and the
+// "jobs" key maps job names to the values of each job run by runJobs. The
+// "groups" and "aliases" keys define parameter groups and aliases for
+// -params. Job values take precedence over profiles, which take precedence
+// over per-model values, which take precedence over the top-level values. It
+// returns the names of the jobs defined.
+//
+//	outdir: /data/icon
+//	concurrent: 8
+//	models:
+//	  icon-d2:
+//	    params: [t_2m, tot_prec]
+//	profiles:
+//	  surface-fast:
+//	    params: [t_2m, pmsl]
+//	    steps: 0-24
+//	groups:
+//	  my-surface: [group:surface, cape_ml]
+//	aliases:
+//	  temperature: t_2m
+//	jobs:
+//	  eu:
+//	    model: icon-eu
+//	    outdir: /data/icon-eu
+func loadConfig(path, profile, job string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of option names to values", root.Line)
+	}
+
+	// Check the whole file, including the sections not applied
+	var top []configSetting
+	models := make(map[string][]configSetting)
+	profiles := make(map[string][]configSetting)
+	jobs := make(map[string][]configSetting)
+	var profileNames, jobNames []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case configModelsKey:
+			sections, err := configSections(key.Value, value)
+			if err != nil {
+				return nil, err
+			}
+			for name, section := range sections {
+				model, ok := lookupModel(name)
+				if !ok {
+					return nil, fmt.Errorf("line %d: unknown model %q in %s. Valid values are: %s", section.line, name, configModelsKey, strings.Join(modelNames(), ", "))
+				}
+				for _, s := range section.settings {
+					if s.flag.Name == "model" {
+						return nil, fmt.Errorf("line %d: model cannot be set per model", s.line)
+					}
+				}
+				models[model.Name] = append(models[model.Name], section.settings...)
+			}
+		case configProfilesKey:
+			sections, err := configSections(key.Value, value)
+			if err != nil {
+				return nil, err
+			}
+			for name, section := range sections {
+				profiles[name] = section.settings
+				profileNames = append(profileNames, name)
+			}
+		case configGroupsKey:
+			if err := loadParamGroups(value); err != nil {
+				return nil, err
+			}
+		case configAliasesKey:
+			if err := loadParamAliases(value); err != nil {
+				return nil, err
+			}
+		case configJobsKey:
+			sections, err := configSections(key.Value, value)
+			if err != nil {
+				return nil, err
+			}
+			for name, section := range sections {
+				jobs[name] = section.settings
+				jobNames = append(jobNames, name)
+			}
+		default:
+			s, err := configValue(key, value)
+			if err != nil {
+				return nil, err
+			}
+			top = append(top, s)
+		}
+	}
+
+	// A top-level profile key selects the profile used without -profile
+	if profile == "" {
+		for _, s := range top {
+			if s.flag.Name == "profile" {
+				profile = s.value
+			}
+		}
+	}
+	var selected []configSetting
+	if profile != "" {
+		var ok bool
+		if selected, ok = profiles[profile]; !ok {
+			sort.Strings(profileNames)
+			if len(profileNames) == 0 {
+				return nil, fmt.Errorf("unknown profile %q, the file defines no %s", profile, configProfilesKey)
+			}
+			return nil, fmt.Errorf("unknown profile %q. Valid values are: %s", profile, strings.Join(profileNames, ", "))
+		}
+	}
+	sort.Strings(jobNames)
+	var jobSettings []configSetting
+	if job != "" {
+		var ok bool
+		if jobSettings, ok = jobs[job]; !ok {
+			if len(jobNames) == 0 {
+				return nil, fmt.Errorf("unknown job %q, the file defines no %s", job, configJobsKey)
+			}
+			return nil, fmt.Errorf("unknown job %q. Valid values are: %s", job, strings.Join(jobNames, ", "))
+		}
+	}
+
+	// The command line and the environment win over the file
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// The model selects the per-model values, so it is settled first. An
+	// unknown -model is reported by main.
+	model := *modelName
+	if !explicit["model"] {
+		for _, s := range append(append(top, selected...), jobSettings...) {
+			if s.flag.Name == "model" {
+				model = s.value
+			}
+		}
+	}
+	resolved, _ := lookupModel(model)
+
+	settings := make(map[string]configSetting)
+	for _, layer := range [][]configSetting{top, models[resolved.Name], selected, jobSettings} {
+		for _, s := range layer {
+			settings[s.flag.Name] = s
+		}
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := settings[name]
+		if explicit[name] {
+			continue
+		}
+		if err := s.flag.Value.Set(s.value); err != nil {
+			return nil, fmt.Errorf("line %d: invalid value %q for %s: %v", s.line, s.value, name, err)
+		}
+	}
+	return jobNames, nil
+}
+
+// loadParamGroups reads the parameter groups of the configuration file,
+// each a list of -params entries or a single -params string
+func loadParamGroups(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: %s must map group names to parameters", node.Line, configGroupsKey)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i], node.Content[i+1]
+		if name.Value == "" || strings.ContainsAny(name.Value, ", \t@:") {
+			return fmt.Errorf("line %d: invalid group name %q", name.Line, name.Value)
+		}
+		var entries []string
+		switch value.Kind {
+		case yaml.ScalarNode:
+			entries = splitParamFields(value.Value)
+		case yaml.SequenceNode:
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: group %s must be a list of parameters", item.Line, name.Value)
+				}
+				entries = append(entries, item.Value)
+			}
+		default:
+			return fmt.Errorf("line %d: group %s must be a list of parameters", value.Line, name.Value)
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("line %d: group %s is empty", value.Line, name.Value)
+		}
+		userParamGroups[name.Value] = entries
+	}
+	return nil
+}
+
+// loadParamAliases reads the parameter aliases of the configuration file,
+// mapping alias names to DWD parameter names
+func loadParamAliases(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: %s must map alias names to parameters", node.Line, configAliasesKey)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i], node.Content[i+1]
+		if name.Value == "" || strings.ContainsAny(name.Value, ", \t@:") {
+			return fmt.Errorf("line %d: invalid alias name %q", name.Line, name.Value)
+		}
+		if value.Kind != yaml.ScalarNode || value.Value == "" || strings.ContainsAny(value.Value, ", \t@:") {
+			return fmt.Errorf("line %d: alias %s must name a single parameter", value.Line, name.Value)
+		}
+		userParamAliases[strings.ToLower(name.Value)] = value.Value
+	}
+	return nil
+}
+
+// configSection is a named mapping of options in the configuration file
+type configSection struct {
+	line     int
+	settings []configSetting
+}
+
+// configSections reads a mapping of names to option mappings, such as the
+// models or profiles
+func configSections(key string, node *yaml.Node) (map[string]configSection, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: %s must map names to options", node.Line, key)
+	}
+	sections := make(map[string]configSection)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: options of %s in %s must be a mapping", value.Line, name.Value, key)
+		}
+		if _, dup := sections[name.Value]; dup {
+			return nil, fmt.Errorf("line %d: %s defined twice in %s", name.Line, name.Value, key)
+		}
+		section := configSection{line: name.Line}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			option := value.Content[j]
+			switch option.Value {
+			case configModelsKey, configProfilesKey, configJobsKey, configGroupsKey, configAliasesKey, "profile", "job":
+				return nil, fmt.Errorf("line %d: %s cannot be set in %s", option.Line, option.Value, key)
+			}
+			s, err := configValue(option, value.Content[j+1])
+			if err != nil {
+				return nil, err
+			}
+			section.settings = append(section.settings, s)
+		}
+		sections[name.Value] = section
+	}
+	return sections, nil
+}
+
+// configValue returns the flag named by a configuration key and its value
+// as given on the command line. Lists are joined with commas.
+func configValue(key, value *yaml.Node) (configSetting, error) {
+	name := key.Value
+	f := flag.Lookup(name)
+	if f == nil {
+		return configSetting{}, fmt.Errorf("line %d: unknown option %q", key.Line, name)
+	}
+	if commandLineOnly[name] {
+		return configSetting{}, fmt.Errorf("line %d: %s can only be given on the command line", key.Line, name)
+	}
+
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return configSetting{flag: f, value: value.Value, line: value.Line}, nil
+	case yaml.SequenceNode:
+		var items []string
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return configSetting{}, fmt.Errorf("line %d: %s must be a list of values", item.Line, name)
+			}
+			items = append(items, item.Value)
+		}
+		separator := ","
+		if name == "watch-schedule" {
+			separator = ";" // Cron expressions contain commas
+		}
+		return configSetting{flag: f, value: strings.Join(items, separator), line: value.Line}, nil
+	}
+	return configSetting{}, fmt.Errorf("line %d: %s must be a value or a list of values", value.Line, name)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"icon-grib-downloader/pkg/index"
+
+	"gopkg.in/yaml.v3"
+)
+
+// commandLineOnly are the options that make no sense in a configuration
+// file, as they replace the download by another action
+var commandLineOnly = map[string]bool{
+	"config":       true,
+	"job":          true,
+	"check-config": true,
+	"version":      true,
+	"dump-catalog": true,
+	"describe":     true,
+	"usage-report": true,
+	"probe":        true,
+}
+
+// parsed adapts a parser of an option to configCheckers. Empty values are
+// not parsed, as main leaves the option unset for them.
+func parsed[T any](parse func(string) (T, error)) func(string) error {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+		_, err := parse(value)
+		return err
+	}
+}
+
+// configCheckers check the values of the options that main parses further
+// than their flag type, with the same parsers
+var configCheckers = map[string]func(string) error{
+	"model": func(value string) error {
+		if _, ok := lookupModel(value); !ok {
+			return fmt.Errorf("unknown model. Valid values are: %s", strings.Join(modelNames(), ", "))
+		}
+		return nil
+	},
+	"index-format":   parsed(index.ParseFormat),
+	"base-url":       parsed(parseBaseURLs), // The mirrors it sets up are not used
+	"watch-schedule": parsed(parseCronSchedules),
+	"watch-backlog":  validateWatchBacklog,
+	"leveltype":      parsed(parseLevelTypes),
+	"level":          parsed(parseLevelTypes),
+	"overwrite":      validateOverwritePolicy,
+	"collision":      validateCollisionStrategy,
+	"sanitize":       validateSanitizeScheme,
+	"zero-byte":      validateZeroBytePolicy,
+	"deadlines":      parsed(parseDeadlines),
+	"max-rss":        parsed(parseByteSize),
+	"confirm-above":  func(value string) error { _, err := parseConfirmThresholds(value); return err },
+	"estimate-rate":  func(value string) error { _, err := parseByteSize(value); return err },
+	"monthly-cap":    parsed(parseByteSize),
+	"budgets":        parsed(parseBudgets),
+	"messages":       parsed(regexp.Compile),
+	"min-files":      parsed(parseMinFiles),
+	"model-levels":   parsed(parseLevelRanges),
+	"soil-levels":    parsed(parseLevelRanges),
+	"steps":          parsed(parseStepRanges),
+	"leadtime-hook":  parsed(parseLeadtimeHook),
+	"file-hook":      parsed(parseFileHook),
+	"run-hook":       parsed(parseRunHook),
+	"valid-times":    parsed(parseValidTimes),
+	"cap-feeds":      parsed(validateCAPFeeds),
+}
+
+// modelConfigCheckers check the values that depend on the model, such as
+// parameter sets, with the model of the section selected
+var modelConfigCheckers = map[string]func(string) error{
+	"params": checkParams,
+	"params-file": func(value string) error {
+		if value == "" || value == stdinParams {
+			return nil
+		}
+		listed, err := readParamsFile(value)
+		if err != nil {
+			return err
+		}
+		return checkParams(listed)
+	},
+	"exclude-params": parsed(parseExcludeParams),
+}
+
+// checkParams checks a -params value, unless it is read from stdin
+func checkParams(value string) error {
+	if value == "" || value == stdinParams {
+		return nil
+	}
+	_, _, err := parseParamList(value)
+	return err
+}
+
+// checkConfigFile checks the configuration file for -check-config after
+// loadConfig accepted it, logging each problem found, and returns the exit
+// status. Unlike loadConfig, it checks the values of every section,
+// including the models, profiles and jobs not selected, with the parsers
+// main uses, and it reports options set twice in a section, of which only
+// the last value would apply.
+func checkConfigFile(path string) int {
+	problems, err := configProblems(path)
+	if err != nil {
+		log.Printf("Invalid -config %s: %v", path, err)
+		return 1
+	}
+	for _, problem := range problems {
+		log.Printf("Invalid -config %s: %s", path, problem)
+	}
+	if len(problems) > 0 {
+		log.Printf("%s: %d problems found", path, len(problems))
+		return 1
+	}
+	log.Printf("%s: no problems found", path)
+	return 0
+}
+
+// configProblems returns the problems of the options of a configuration file
+func configProblems(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+
+	// Sections without a model of their own are checked with the model in
+	// use, which the top level, the command line or the environment select
+	var problems []string
+	problems = append(problems, checkConfigSection("the top level", root, *modelName, true)...)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case configModelsKey, configProfilesKey, configJobsKey:
+		default:
+			continue
+		}
+		seen := make(map[string]bool)
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			name, section := value.Content[j], value.Content[j+1]
+			model := *modelName
+			if key.Value == configModelsKey {
+				model = name.Value
+			} else if s, ok := sectionSetting(section, "model"); ok {
+				model = s
+			}
+			if seen[name.Value] {
+				continue // Reported by loadConfig
+			}
+			seen[name.Value] = true
+			problems = append(problems, checkConfigSection(key.Value+"."+name.Value, section, model, false)...)
+		}
+	}
+	return problems, nil
+}
+
+// sectionSetting returns the last value of an option in a section
+func sectionSetting(section *yaml.Node, name string) (string, bool) {
+	var value string
+	var found bool
+	for i := 0; i+1 < len(section.Content); i += 2 {
+		if section.Content[i].Value == name && section.Content[i+1].Kind == yaml.ScalarNode {
+			value, found = section.Content[i+1].Value, true
+		}
+	}
+	return value, found
+}
+
+// checkConfigSection returns the problems of the options of a section. The
+// sections of the top level are skipped.
+func checkConfigSection(name string, section *yaml.Node, model string, top bool) []string {
+	var problems []string
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(section.Content); i += 2 {
+		key, value := section.Content[i], section.Content[i+1]
+		if line, dup := seen[key.Value]; dup {
+			problems = append(problems, fmt.Sprintf("line %d: %s set again in %s, the value of line %d is not applied", key.Line, key.Value, name, line))
+		}
+		seen[key.Value] = key.Line
+		if top {
+			switch key.Value {
+			case configModelsKey, configProfilesKey, configJobsKey, configGroupsKey, configAliasesKey:
+				continue
+			}
+		}
+		s, err := configValue(key, value)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if err := checkConfigValue(s, model); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: invalid value %q for %s in %s: %v", s.line, s.value, s.flag.Name, name, err))
+		}
+	}
+	return problems
+}
+
+// checkConfigValue checks an option value with its flag type, on a fresh
+// value so the flag is not set, and with the checker of the option
+func checkConfigValue(s configSetting, model string) error {
+	value := reflect.New(reflect.TypeOf(s.flag.Value).Elem()).Interface().(flag.Value)
+	if err := value.Set(s.value); err != nil {
+		return err
+	}
+	if check, ok := configCheckers[s.flag.Name]; ok {
+		return check(s.value)
+	}
+	if check, ok := modelConfigCheckers[s.flag.Name]; ok {
+		m, ok := lookupModel(model)
+		if !ok {
+			return nil // The unknown model is reported by itself
+		}
+		saved := selectedModel
+		selectedModel = m
+		defer func() { selectedModel = saved }()
+		return check(s.value)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// defaultConfirmThresholds are the plan sizes above which a download has to
+// be confirmed, high enough for a full run of a few parameters
+const defaultConfirmThresholds = "size=50G,files=10000,duration=3h"
+
+// confirmThresholds are the limits set with -confirm-above; zero values are
+// not checked
+type confirmThresholds struct {
+	Size     int64
+	Files    int
+	Duration time.Duration
+}
+
+// confirmLimits holds the parsed -confirm-above flag
+var confirmLimits confirmThresholds
+
+// parseConfirmThresholds parses a comma-separated list of size=, files= and
+// duration= limits, e.g. "size=50G,files=10000,duration=3h", or "none"
+func parseConfirmThresholds(spec string) (confirmThresholds, error) {
+	var t confirmThresholds
+	if strings.TrimSpace(spec) == "none" {
+		return t, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return t, fmt.Errorf("invalid threshold %q, expected e.g. size=50G", part)
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "size":
+			t.Size, err = parseByteSize(value)
+		case "files":
+			t.Files, err = strconv.Atoi(value)
+			if err == nil && t.Files < 0 {
+				err = fmt.Errorf("negative count")
+			}
+		case "duration":
+			t.Duration, err = time.ParseDuration(value)
+		default:
+			return t, fmt.Errorf("unknown threshold %q, expected size, files or duration", key)
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid threshold %q: %v", part, err)
+		}
+	}
+	return t, nil
+}
+
+// planEstimate is what a plan would transfer, counting only the files that
+// are downloaded or replaced
+type planEstimate struct {
+	Files    int
+	Bytes    int64
+	Unknown  int // Files of unknown size, not included in Bytes
+	Duration time.Duration
+}
+
+// estimatePlan sums up the files a plan would download and the time this
+// takes at the -estimate-rate
+func estimatePlan(plan []*PlannedFile, rate int64) planEstimate {
+	var e planEstimate
+	for _, f := range plan {
+		if action, _ := planAction(f); action != actionDownload && action != actionReplace {
+			continue
+		}
+		e.Files++
+		if size := plannedSize(f); size >= 0 {
+			e.Bytes += size
+		} else {
+			e.Unknown++
+		}
+	}
+	if rate > 0 {
+		e.Duration = time.Duration(float64(e.Bytes) / float64(rate) * float64(time.Second)).Round(time.Second)
+	}
+	return e
+}
+
+// exceeded lists the thresholds a plan estimate is above
+func (t confirmThresholds) exceeded(e planEstimate) []string {
+	var reasons []string
+	if t.Size > 0 && e.Bytes > t.Size {
+		reasons = append(reasons, fmt.Sprintf("%s to transfer exceeds %s", formatBytes(e.Bytes), formatBytes(t.Size)))
+	}
+	if t.Files > 0 && e.Files > t.Files {
+		reasons = append(reasons, fmt.Sprintf("%d files exceed %d", e.Files, t.Files))
+	}
+	if t.Duration > 0 && e.Duration > t.Duration {
+		reasons = append(reasons, fmt.Sprintf("estimated %s exceeds %s", e.Duration, t.Duration))
+	}
+	return reasons
+}
+
+// confirmPlan asks before starting a plan above the -confirm-above
+// thresholds unless -yes is given. Without a terminal to ask on, e.g. under
+// cron, such a plan is refused.
+func confirmPlan(plan []*PlannedFile) error {
+	if *assumeYes {
+		return nil
+	}
+	rate, err := parseByteSize(*estimateRate)
+	if err != nil {
+		return err
+	}
+	e := estimatePlan(plan, rate)
+	reasons := confirmLimits.exceeded(e)
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	summary := fmt.Sprintf("%d files, %s, about %s at %s/s", e.Files, formatBytes(e.Bytes), e.Duration, formatBytes(rate))
+	if e.Unknown > 0 {
+		summary += fmt.Sprintf(", %d files of unknown size", e.Unknown)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("plan is too large (%s): %s; use -yes to download anyway", summary, strings.Join(reasons, ", "))
+	}
+
+	fmt.Fprintf(os.Stderr, "The plan is %s:\n", summary)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "  %s\n", reason)
+	}
+	fmt.Fprint(os.Stderr, "Start the download? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("download not confirmed")
+}
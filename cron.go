@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression with the five fields minute,
+// hour, day of month, month and day of week, evaluated in UTC
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of the matching values
+	domAny, dowAny                bool   // Whether the day fields are "*"
+}
+
+// cronFields are the ranges of the fields of a cron expression
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCronSchedules parses a list of cron expressions separated by
+// semicolons, e.g. "30 2 * * *; 30 14 * * *"
+func parseCronSchedules(spec string) ([]cronSchedule, error) {
+	var schedules []cronSchedule
+	for _, expr := range strings.Split(spec, ";") {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		s, err := parseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	if len(schedules) == 0 {
+		return nil, fmt.Errorf("no cron expression given")
+	}
+	return schedules, nil
+}
+
+// parseCron parses a cron expression such as "30 2,8,14,20 * * *". Fields
+// take "*", values, ranges "1-5" and steps "*/15" or "0-30/10", separated by
+// commas. Day of week 0 and 7 are Sunday.
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSchedule{}, fmt.Errorf("invalid cron expression %q, expected minute, hour, day of month, month and day of week", strings.TrimSpace(expr))
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("invalid %s %q in cron expression %q: %v", cronFields[i].name, field, strings.TrimSpace(expr), err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses one field of a cron expression into a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepSpec)
+			}
+			step = n
+		}
+
+		from, to := min, max
+		if rng != "*" {
+			lo, hi, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(lo); err != nil {
+				return 0, fmt.Errorf("invalid value %q", lo)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(hi); err != nil {
+					return 0, fmt.Errorf("invalid value %q", hi)
+				}
+			} else if hasStep {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the minute of t matches the schedule. As in cron,
+// a day matches either day field if both are restricted.
+func (s cronSchedule) matches(t time.Time) bool {
+	t = t.UTC()
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first matching minute after t, or the zero time if there
+// is none within a year, e.g. for February 30
+func (s cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(1, 0, 0); t.Before(end); {
+		if s.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.matches(t) {
+			return t
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+		ok       bool
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}, true},
+		{"3", 0, 59, []int{3}, true},
+		{"1-4", 0, 59, []int{1, 2, 3, 4}, true},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}, true},
+		{"0-30/10", 0, 59, []int{0, 10, 20, 30}, true},
+		{"50/5", 0, 59, []int{50, 55}, true},
+		{"2,8,14,20", 0, 23, []int{2, 8, 14, 20}, true},
+		{"1-3,*/10", 0, 23, []int{0, 1, 2, 3, 10, 20}, true},
+		{"*/15", 1, 31, []int{1, 16, 31}, true},
+		{"60", 0, 59, nil, false},
+		{"0", 1, 31, nil, false},
+		{"5-3", 0, 59, nil, false},
+		{"*/0", 0, 59, nil, false},
+		{"*/x", 0, 59, nil, false},
+		{"a", 0, 59, nil, false},
+		{"1-b", 0, 59, nil, false},
+		{"", 0, 59, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseCronField(%q, %d, %d) error = %v, want ok %v", tt.field, tt.min, tt.max, err, tt.ok)
+			continue
+		}
+		var want uint64
+		for _, v := range tt.want {
+			want |= 1 << v
+		}
+		if got != want {
+			t.Errorf("parseCronField(%q, %d, %d) = %b, want %b", tt.field, tt.min, tt.max, got, want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr           string
+		ok             bool
+		domAny, dowAny bool
+	}{
+		{"30 2,8,14,20 * * *", true, true, true},
+		{"0 0 1 * *", true, false, true},
+		{"0 0 * * 1-5", true, true, false},
+		{"0 0 */15 * *", true, false, true},
+		{"0 0 * * */2", true, true, false},
+		{"0 0 * *", false, false, false},
+		{"0 0 * * * *", false, false, false},
+		{"0 24 * * *", false, false, false},
+		{"0 0 32 * *", false, false, false},
+		{"0 0 * 13 *", false, false, false},
+		{"0 0 * * 8", false, false, false},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseCron(%q) error = %v, want ok %v", tt.expr, err, tt.ok)
+			continue
+		}
+		if tt.ok && (s.domAny != tt.domAny || s.dowAny != tt.dowAny) {
+			t.Errorf("parseCron(%q) domAny, dowAny = %v, %v, want %v, %v", tt.expr, s.domAny, s.dowAny, tt.domAny, tt.dowAny)
+		}
+	}
+
+	// Sunday is both 0 and 7
+	s, err := parseCron("0 0 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.dow != 1|1<<7 {
+		t.Errorf("parseCron(\"0 0 * * 7\") day of week = %b, want Sunday as 0 and 7", s.dow)
+	}
+}
+
+func TestParseCronSchedules(t *testing.T) {
+	tests := []struct {
+		spec string
+		want int
+		ok   bool
+	}{
+		{"30 2 * * *", 1, true},
+		{"30 2 * * *; 30 14 * * *", 2, true},
+		{"30 2 * * *;", 1, true},
+		{"", 0, false},
+		{" ; ", 0, false},
+		{"30 2 * * *; 61 * * * *", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseCronSchedules(tt.spec)
+		if (err == nil) != tt.ok || len(got) != tt.want {
+			t.Errorf("parseCronSchedules(%q) = %d schedules, %v, want %d, ok %v", tt.spec, len(got), err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr, after, want string
+	}{
+		// Within the hour and the day, always after the given minute
+		{"*/15 * * * *", "2025-03-12 06:00", "2025-03-12 06:15"},
+		{"*/15 * * * *", "2025-03-12 06:14", "2025-03-12 06:15"},
+		{"30 2,8,14,20 * * *", "2025-03-12 08:30", "2025-03-12 14:30"},
+		{"0-30/10 6 * * *", "2025-03-12 06:21", "2025-03-12 06:30"},
+		{"0-30/10 6 * * *", "2025-03-12 06:31", "2025-03-13 06:00"},
+
+		// Month and year rollover
+		{"0 0 1 * *", "2025-01-31 12:00", "2025-02-01 00:00"},
+		{"30 2 * * *", "2025-12-31 23:59", "2026-01-01 02:30"},
+		{"0 0 1 1 *", "2025-01-01 00:00", "2026-01-01 00:00"},
+		{"0 0 29 2 *", "2027-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 31 * *", "2025-04-01 00:00", "2025-05-31 12:00"},
+		{"0 6 * 3,9 *", "2025-03-31 07:00", "2025-09-01 06:00"},
+
+		// A day matches either restricted day field; */15 is restricted
+		{"0 0 13 * 5", "2025-03-12 00:00", "2025-03-13 00:00"},
+		{"0 0 20 * 5", "2025-03-12 00:00", "2025-03-14 00:00"},
+		{"0 0 */15 * 1", "2025-03-12 00:00", "2025-03-16 00:00"},
+		{"0 0 */15 * 1", "2025-03-16 00:00", "2025-03-17 00:00"},
+		{"0 0 * * 1", "2025-03-12 00:00", "2025-03-17 00:00"},
+		{"0 0 1 * *", "2025-03-12 00:00", "2025-04-01 00:00"},
+		{"0 0 * * 0", "2025-03-12 00:00", "2025-03-16 00:00"},
+		{"0 0 * * 7", "2025-03-12 00:00", "2025-03-16 00:00"},
+
+		// No match within a year
+		{"0 0 30 2 *", "2025-03-12 00:00", ""},
+		{"0 0 29 2 *", "2025-03-01 00:00", ""},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		got := s.next(utc(tt.after))
+		var want time.Time
+		if tt.want != "" {
+			want = utc(tt.want)
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseCron(%q).next(%s) = %s, want %s", tt.expr, tt.after, got.Format("2006-01-02 15:04"), want.Format("2006-01-02 15:04"))
+		}
+	}
+}
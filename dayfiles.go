@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// dayFilesLayout describes a tree of daily directories holding the files of
+// all runs of the day side by side, as used by NOAA HRRR and NAM
+type dayFilesLayout struct {
+	Day    *regexp.Regexp // Matches daily directory names, capturing the date as YYYYMMDD
+	File   *regexp.Regexp // Matches product files, capturing the run hour, product and step
+	Suffix string         // Path from a daily directory to the directory of its files
+	Days   int            // Number of latest daily directories to list
+}
+
+// dayFilesSource reads a tree of daily directories with one uncompressed
+// GRIB file per run, product and step, each with an .idx inventory. Products
+// take the place of DWD parameter directories.
+type dayFilesSource struct {
+	httpFetcher                // Also lists the directory indexes with its client
+	baseURL     string         // URL of the directory containing the daily directories
+	layout      dayFilesLayout // Names of the directories and files
+}
+
+// newDayFilesSource creates a source for the daily directories of a layout
+// under baseURL
+func newDayFilesSource(client *index.Client, baseURL string, layout dayFilesLayout) *dayFilesSource {
+	return &dayFilesSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+		layout:      layout,
+	}
+}
+
+// ListRuns returns the runs found in the files of the latest daily
+// directories. Runs are not directories, so the newest file time of a run
+// is used as its timestamp.
+func (s *dayFilesSource) ListRuns() ([]ModelRun, error) {
+	days, err := latestDays(s.client, s.baseURL, s.layout.Day, s.layout.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []ModelRun
+	for _, day := range days {
+		date := s.layout.Day.FindStringSubmatch(day.Name)[1]
+		dirURL := day.URL + s.layout.Suffix
+		entries, err := s.client.List(dirURL)
+		if err != nil {
+			return nil, err
+		}
+
+		newest := make(map[string]time.Time)
+		for _, e := range entries {
+			match := s.layout.File.FindStringSubmatch(e.Name)
+			if e.Dir || match == nil {
+				continue
+			}
+			if t, seen := newest[match[1]]; !seen || e.ModTime.After(t) {
+				newest[match[1]] = e.ModTime
+			}
+		}
+
+		hours := make([]string, 0, len(newest))
+		for hour := range newest {
+			hours = append(hours, hour)
+		}
+		sort.Strings(hours)
+
+		for _, hour := range hours {
+			timestamp := newest[hour]
+			ref, err := time.ParseInLocation("20060102 15", date+" "+hour, time.UTC)
+			if err != nil {
+				continue
+			}
+			if timestamp.IsZero() {
+				timestamp = ref
+			}
+			runs = append(runs, ModelRun{
+				Time:          hour,
+				URL:           dirURL,
+				Timestamp:     timestamp.UTC(),
+				ReferenceTime: ref,
+			})
+		}
+	}
+	return runs, nil
+}
+
+// ListParameters returns the products published for a run
+func (s *dayFilesSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	entries, err := s.client.List(run.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var params []Parameter
+	for _, e := range entries {
+		match := s.layout.File.FindStringSubmatch(e.Name)
+		if e.Dir || match == nil || match[1] != run.Time || seen[match[2]] {
+			continue
+		}
+		seen[match[2]] = true
+		params = append(params, Parameter{Name: match[2], URL: run.URL, Run: run.Time})
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params, nil
+}
+
+// ListFiles returns the files of a product of the parameter's run. Files
+// without an .idx inventory are still being written and are left out.
+func (s *dayFilesSource) ListFiles(param Parameter) ([]index.File, error) {
+	entries, err := s.client.List(param.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var ref time.Time
+	if match := s.layout.Day.FindStringSubmatch(dayDirName(param.URL, s.layout.Suffix)); match != nil {
+		ref, _ = time.ParseInLocation("20060102 15", match[1]+" "+param.Run, time.UTC)
+	}
+
+	inventories := make(map[string]bool)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name, ".idx") {
+			inventories[strings.TrimSuffix(e.Name, ".idx")] = true
+		}
+	}
+
+	var files []index.File
+	pending := 0
+	for _, e := range entries {
+		match := s.layout.File.FindStringSubmatch(e.Name)
+		if e.Dir || match == nil || match[1] != param.Run || match[2] != param.Name {
+			continue
+		}
+		if !inventories[e.Name] {
+			pending++
+			continue
+		}
+		files = append(files, index.File{
+			Name:          e.Name,
+			URL:           e.URL,
+			ModTime:       e.ModTime,
+			Size:          e.Size,
+			ReferenceTime: ref,
+			Leadtime:      time.Duration(parseInt(match[3])) * time.Hour,
+		})
+	}
+
+	if pending > 0 && *verbose {
+		log.Printf("Skipping %d %s files without an .idx inventory, they are still being written", pending, param.Name)
+	}
+	return files, nil
+}
+
+// InventoryURL returns the URL of the .idx inventory of a GRIB file
+func (s *dayFilesSource) InventoryURL(fileURL string) string {
+	return fileURL + ".idx"
+}
+
+// dayDirName returns the name of the daily directory of a file directory
+// URL with the given suffix, e.g. "hrrr.20250312" for
+// ".../hrrr.20250312/conus/"
+func dayDirName(dirURL, suffix string) string {
+	dirURL = strings.TrimSuffix(strings.TrimSuffix(dirURL, suffix), "/")
+	return dirURL[strings.LastIndex(dirURL, "/")+1:]
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deadlines holds the parsed -deadlines flag, keyed by parameter name or level type
+var deadlines map[string]time.Duration
+
+// parseDeadlines parses a comma-separated list of key=duration pairs, where
+// the key is a parameter name or a level type (single, pressure, model, soil)
+func parseDeadlines(spec string) (map[string]time.Duration, error) {
+	result := make(map[string]time.Duration)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid deadline %q, expected e.g. single=1h", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid deadline %q: %v", part, err)
+		}
+		result[strings.ToLower(strings.TrimSpace(key))] = d
+	}
+	return result, nil
+}
+
+// deadlineFor returns the deadline group and deadline of a planned file. A
+// deadline for the parameter name takes precedence over one for its level type.
+func deadlineFor(f *PlannedFile) (string, time.Duration, bool) {
+	if d, ok := deadlines[strings.ToLower(f.Param)]; ok {
+		return "parameter", d, true
+	}
+	level := strings.TrimSuffix(f.Info.LevelType, "-level")
+	if d, ok := deadlines[level]; ok && level != "" {
+		return level + "-level", d, true
+	}
+	return "", 0, false
+}
+
+// deadlineTracker checks when the files of each parameter and deadline group
+// are done whether they were completed before their deadline
+type deadlineTracker struct {
+	mu        sync.Mutex
+	remaining map[string]int
+	failed    map[string]int
+}
+
+// newDeadlineTracker counts the planned files per parameter and deadline group
+func newDeadlineTracker(plan []*PlannedFile) *deadlineTracker {
+	t := &deadlineTracker{
+		remaining: make(map[string]int),
+		failed:    make(map[string]int),
+	}
+	for _, f := range plan {
+		if group, _, ok := deadlineFor(f); ok {
+			t.remaining[f.Param+"|"+group]++
+		}
+	}
+	return t
+}
+
+// fileDone records a finished file and reports a missed deadline once the
+// last file of its parameter and group is done
+func (t *deadlineTracker) fileDone(f *PlannedFile, success bool) {
+	group, deadline, ok := deadlineFor(f)
+	if !ok {
+		return
+	}
+	key := f.Param + "|" + group
+
+	t.mu.Lock()
+	t.remaining[key]--
+	if !success {
+		t.failed[key]++
+	}
+	remaining, failed := t.remaining[key], t.failed[key]
+	t.mu.Unlock()
+
+	if remaining > 0 {
+		return
+	}
+
+	if f.Info.ReferenceTime.IsZero() {
+		return
+	}
+	due := f.Info.ReferenceTime.Add(deadline)
+	now := time.Now().UTC()
+
+	switch {
+	case failed > 0:
+		log.Printf("Warning: DEADLINE MISSED for %s (%s): %d files failed, deadline was %s",
+			f.Param, group, failed, due.Format("2006-01-02 15:04 UTC"))
+	case now.After(due):
+		log.Printf("Warning: DEADLINE MISSED for %s (%s): completed %s late at %s",
+			f.Param, group, now.Sub(due).Round(time.Second), now.Format("2006-01-02 15:04 UTC"))
+	case *verbose:
+		log.Printf("Deadline met for %s (%s) with %s to spare", f.Param, group, due.Sub(now).Round(time.Second))
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// freeDiskSpace is not supported on this platform
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space check not supported on this platform")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// doneFileName marks a run directory whose run was downloaded completely
+const doneFileName = "DONE"
+
+// DoneMarker is the content of the DONE file: the run status, the selection
+// the run was completed with, the total size and the SHA-256 of the
+// manifest, which in turn holds the SHA-256 of every file, so that any later
+// change to the run can be detected
+type DoneMarker struct {
+	RunStatus
+	Request        RunRequest `json:"request"`
+	Bytes          int64      `json:"bytes"`           // Total size of the files in the manifest
+	ManifestSHA256 string     `json:"manifest_sha256"` // SHA-256 of the manifest at completion
+}
+
+// RunRequest is the selection of parameters, levels and steps a run was
+// downloaded with, so that a later invocation asking for more is not turned
+// away by the DONE marker
+type RunRequest struct {
+	Parameters map[string][]string `json:"parameters"`            // Levels selected per parameter with name@level, null for all
+	LevelTypes []string            `json:"level_types,omitempty"` // -leveltype categories, empty for all
+	Levels     map[string][]string `json:"levels,omitempty"`      // -model-levels and -soil-levels by level type
+	Steps      []string            `json:"steps"`                 // Forecast steps expected from the schedule
+	ValidTimes string              `json:"valid_times,omitempty"` // -valid-times, empty for all
+	Messages   string              `json:"messages,omitempty"`    // -messages, empty for whole files
+}
+
+// newRunRequest describes the selection of this invocation for a run
+func newRunRequest(params []Parameter, runHour string) RunRequest {
+	request := RunRequest{
+		Parameters: make(map[string][]string),
+		LevelTypes: slices.Sorted(slices.Values(levelTypeFilter)),
+		ValidTimes: *validTimes,
+		Messages:   *messagesSpec,
+	}
+	for _, p := range params {
+		var levels []string
+		if selected, ok := paramLevels[p.Name]; ok {
+			levels = sortedLevels(selected)
+		}
+		request.Parameters[p.Name] = levels
+	}
+	if len(levelSelections) > 0 {
+		request.Levels = make(map[string][]string)
+		for levelType, selected := range levelSelections {
+			request.Levels[levelType] = sortedLevels(selected)
+		}
+	}
+	expected, _ := selectedModel.expectedSteps(runHour)
+	for _, step := range expected {
+		request.Steps = append(request.Steps, formatStep(step))
+	}
+	return request
+}
+
+// sortedLevels returns the levels of a level selection in sorted order
+func sortedLevels(selected map[string]bool) []string {
+	levels := make([]string, 0, len(selected))
+	for level := range selected {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	return levels
+}
+
+// covers reports whether a run completed with this selection holds
+// everything another selection asks for. It errs on the side of
+// downloading: selections it cannot compare are not covered, and the
+// download then only fetches the files that are missing.
+func (r RunRequest) covers(other RunRequest) bool {
+	if r.Messages != other.Messages || (r.ValidTimes != "" && r.ValidTimes != other.ValidTimes) {
+		return false
+	}
+	if !isSubset(other.Steps, r.Steps) {
+		return false
+	}
+	if len(r.LevelTypes) > 0 && (len(other.LevelTypes) == 0 || !isSubset(other.LevelTypes, r.LevelTypes)) {
+		return false
+	}
+	for levelType, levels := range r.Levels {
+		if wanted, ok := other.Levels[levelType]; !ok || !isSubset(wanted, levels) {
+			return false
+		}
+	}
+	for param, wanted := range other.Parameters {
+		levels, ok := r.Parameters[param]
+		if !ok {
+			return false
+		}
+		switch {
+		case levels == nil && wanted == nil:
+			// All levels, within the level type selections compared above
+		case levels == nil:
+			// Explicit levels bypass the level type selections
+			if len(r.LevelTypes) > 0 || len(r.Levels) > 0 {
+				return false
+			}
+		case wanted == nil || !isSubset(wanted, levels):
+			return false
+		}
+	}
+	return true
+}
+
+// isSubset reports whether all of the values are in the set
+func isSubset(values, set []string) bool {
+	for _, v := range values {
+		if !slices.Contains(set, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadDoneMarker reads the DONE file of a run directory, or returns nil if
+// there is none or it cannot be read
+func loadDoneMarker(runDir string) *DoneMarker {
+	data, err := os.ReadFile(filepath.Join(runDir, doneFileName))
+	if err != nil {
+		return nil
+	}
+	var marker DoneMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		log.Printf("Warning: ignoring unreadable %s in %s: %v", doneFileName, runDir, err)
+		return nil
+	}
+	return &marker
+}
+
+// checkDoneMarker reports whether a run directory holds the selected run
+// completed before with at least the requested selection, which is then
+// left alone unless -force is given. A marker of an earlier run of the same
+// hour does not protect the directory, which is reused for the new run as
+// usual, and neither does a marker of a narrower selection.
+func checkDoneMarker(runDir string, run ModelRun, request RunRequest) bool {
+	marker := loadDoneMarker(runDir)
+	if marker == nil {
+		return false
+	}
+	if !marker.ReferenceTime.Equal(run.ReferenceTime) {
+		if *verbose {
+			log.Printf("Replacing run %s completed in %s", formatUTC(marker.ReferenceTime), runDir)
+		}
+		return false
+	}
+	if !marker.Request.covers(request) {
+		log.Printf("Run %s was completed at %s with a narrower selection, downloading the rest",
+			run.Time, formatUTC(marker.Completed))
+		return false
+	}
+
+	if sum, err := fileSHA256(filepath.Join(runDir, manifestFileName)); err != nil || sum != marker.ManifestSHA256 {
+		log.Printf("Warning: %s of run %s changed since the run was completed", manifestFileName, run.Time)
+	}
+	if *force {
+		log.Printf("Run %s was completed at %s, modifying it because of -force", run.Time, formatUTC(marker.Completed))
+		return false
+	}
+	log.Printf("Run %s was completed at %s with %d files and is read-only, use -force to modify it",
+		run.Time, formatUTC(marker.Completed), marker.Files)
+	return true
+}
+
+// removeDoneMarker removes the DONE file of a run directory about to be
+// modified, so that an interrupted download does not look complete
+func removeDoneMarker(runDir string) error {
+	err := os.Remove(filepath.Join(runDir, doneFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// saveDoneMarker writes the read-only DONE file of a run completed with a
+// selection, after the manifest was saved
+func saveDoneMarker(runDir string, run ModelRun, request RunRequest) {
+	marker := DoneMarker{RunStatus: newRunStatus(run), Request: request}
+	sum, err := fileSHA256(filepath.Join(runDir, manifestFileName))
+	if err != nil {
+		log.Printf("Warning: could not write %s: %v", doneFileName, err)
+		return
+	}
+	marker.ManifestSHA256 = sum
+	runManifest.mu.Lock()
+	for _, entry := range runManifest.Files {
+		marker.Bytes += entry.Size
+	}
+	runManifest.mu.Unlock()
+
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		log.Printf("Warning: could not write %s: %v", doneFileName, err)
+		return
+	}
+	path := filepath.Join(runDir, doneFileName)
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := os.WriteFile(tmp, data, 0444); err != nil {
+		log.Printf("Warning: could not write %s: %v", doneFileName, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		log.Printf("Warning: could not write %s: %v", doneFileName, err)
+		return
+	}
+	if *verbose {
+		log.Printf("Marked run %s as done at %s", run.Time, marker.Completed.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+func TestRunRequestCovers(t *testing.T) {
+	steps := []string{"0h", "1h", "2h", "3h"}
+	all := RunRequest{
+		Parameters: map[string][]string{"t_2m": nil, "t": nil, "u": {"40", "41"}},
+		Steps:      steps,
+	}
+	tests := []struct {
+		name  string
+		done  RunRequest
+		other RunRequest
+		want  bool
+	}{
+		{"same selection", all, all, true},
+		{"fewer parameters", all, RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps}, true},
+		{"other parameter", all, RunRequest{Parameters: map[string][]string{"pmsl": nil}, Steps: steps}, false},
+		{"fewer steps", all, RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps[:2]}, true},
+		{"more steps", RunRequest{Parameters: all.Parameters, Steps: steps[:2]}, all, false},
+
+		// Levels of parameters
+		{"levels of all levels", all, RunRequest{Parameters: map[string][]string{"t": {"850"}}, Steps: steps}, true},
+		{"fewer levels", all, RunRequest{Parameters: map[string][]string{"u": {"41"}}, Steps: steps}, true},
+		{"other level", all, RunRequest{Parameters: map[string][]string{"u": {"42"}}, Steps: steps}, false},
+		{"all levels of some", all, RunRequest{Parameters: map[string][]string{"u": nil}, Steps: steps}, false},
+		{
+			"levels bypassing the level types",
+			RunRequest{Parameters: map[string][]string{"t": nil}, LevelTypes: []string{"pressure"}, Steps: steps},
+			RunRequest{Parameters: map[string][]string{"t": {"850"}}, LevelTypes: []string{"pressure"}, Steps: steps},
+			false,
+		},
+
+		// Level types and level selections
+		{
+			"fewer level types",
+			RunRequest{Parameters: map[string][]string{"t": nil}, LevelTypes: []string{"pressure", "single"}, Steps: steps},
+			RunRequest{Parameters: map[string][]string{"t": nil}, LevelTypes: []string{"single"}, Steps: steps},
+			true,
+		},
+		{
+			"all level types of some",
+			RunRequest{Parameters: map[string][]string{"t": nil}, LevelTypes: []string{"single"}, Steps: steps},
+			RunRequest{Parameters: map[string][]string{"t": nil}, Steps: steps},
+			false,
+		},
+		{
+			"level types of all",
+			all,
+			RunRequest{Parameters: map[string][]string{"t": nil}, LevelTypes: []string{"single"}, Steps: steps},
+			true,
+		},
+		{
+			"fewer model levels",
+			RunRequest{Parameters: map[string][]string{"u": nil}, Levels: map[string][]string{"model": {"40", "41"}}, Steps: steps},
+			RunRequest{Parameters: map[string][]string{"u": nil}, Levels: map[string][]string{"model": {"41"}}, Steps: steps},
+			true,
+		},
+		{
+			"all model levels of some",
+			RunRequest{Parameters: map[string][]string{"u": nil}, Levels: map[string][]string{"model": {"40", "41"}}, Steps: steps},
+			RunRequest{Parameters: map[string][]string{"u": nil}, Steps: steps},
+			false,
+		},
+
+		// Valid times and messages
+		{
+			"valid times of all",
+			all,
+			RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps, ValidTimes: "2025-03-12T06:00"},
+			true,
+		},
+		{
+			"same valid times",
+			RunRequest{Parameters: all.Parameters, Steps: steps, ValidTimes: "2025-03-12T06:00"},
+			RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps, ValidTimes: "2025-03-12T06:00"},
+			true,
+		},
+		{
+			"all valid times of some",
+			RunRequest{Parameters: all.Parameters, Steps: steps, ValidTimes: "2025-03-12T06:00"},
+			RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps},
+			false,
+		},
+		{
+			"other messages",
+			RunRequest{Parameters: all.Parameters, Steps: steps, Messages: ":TMP:"},
+			RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps, Messages: ":UGRD:"},
+			false,
+		},
+		{
+			"whole files of messages",
+			RunRequest{Parameters: all.Parameters, Steps: steps, Messages: ":TMP:"},
+			RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps},
+			false,
+		},
+		{
+			"messages of whole files",
+			all,
+			RunRequest{Parameters: map[string][]string{"t_2m": nil}, Steps: steps, Messages: ":TMP:"},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.done.covers(tt.other); got != tt.want {
+			t.Errorf("%s: covers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Actions of a planned file in the -plan output
+const (
+	actionDownload = "download" // No local file yet
+	actionReplace  = "replace"  // Existing local file is downloaded again
+	actionSkip     = "skip"     // Existing local file is kept
+	actionWait     = "wait"     // Remote file is a zero-byte placeholder
+)
+
+// planAction returns what downloadPlan would do with a planned file and why
+func planAction(f *PlannedFile) (string, string) {
+	if f.RemoteSize == 0 {
+		return actionWait, "remote file is empty"
+	}
+	skip, reason := shouldSkip(f)
+	if skip {
+		return actionSkip, reason
+	}
+	if _, err := os.Stat(f.LocalPath); err != nil {
+		return actionDownload, reason
+	}
+	return actionReplace, reason
+}
+
+// printPlan prints the action for each planned file against the local state
+// of the run directory, followed by a summary
+func printPlan(plan []*PlannedFile) {
+	counts := make(map[string]int)
+	var bytes int64
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tFILE\tREASON")
+	for _, f := range plan {
+		action, reason := planAction(f)
+		counts[action]++
+		if (action == actionDownload || action == actionReplace) && f.RemoteSize > 0 {
+			bytes += f.RemoteSize
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", action, f.LocalPath, reason)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d files: %d to download, %d to replace, %d to skip, %d not yet available",
+		len(plan), counts[actionDownload], counts[actionReplace], counts[actionSkip], counts[actionWait])
+	if *prefetch {
+		fmt.Printf(" (%s to transfer)", formatBytes(bytes))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// ecmwfLayout is the ECMWF open data tree of daily YYYYMMDD directories with
+// HHz run directories. The server keeps the last few days.
+var ecmwfLayout = dailyLayout{
+	Day:    regexp.MustCompile(`^(\d{8})$`),
+	Hour:   regexp.MustCompile(`^(\d\d)z$`),
+	Suffix: "ifs/0p25/",
+	Days:   2,
+}
+
+// ecmwfFilePattern matches data files, e.g. "20250312000000-12h-oper-fc.grib2",
+// capturing the reference time and step
+var ecmwfFilePattern = regexp.MustCompile(`^(\d{14})-(\d+)h-[a-z]+-[a-z]+\.grib2$`)
+
+// ecmwfSource reads the ECMWF open data tree of the IFS 0.25° runs:
+// YYYYMMDD/HHz/ifs/0p25/ directories with one directory per stream, such as
+// oper, scda, enfo or wave, holding one GRIB file per step with a JSON
+// .index of its messages. Streams take the place of DWD parameter directories.
+type ecmwfSource struct {
+	httpFetcher        // Also lists the directory indexes with its client
+	baseURL     string // URL of the directory containing the daily directories
+}
+
+// newECMWFSource creates a source for the daily directories under baseURL
+func newECMWFSource(client *index.Client, baseURL string) *ecmwfSource {
+	return &ecmwfSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+	}
+}
+
+// ListRuns returns the runs of the latest daily directories
+func (s *ecmwfSource) ListRuns() ([]ModelRun, error) {
+	return listDailyRuns(s.client, s.baseURL, ecmwfLayout)
+}
+
+// ListParameters returns the stream directories of a run
+func (s *ecmwfSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	entries, err := s.client.List(run.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var params []Parameter
+	for _, e := range entries {
+		if e.Dir {
+			params = append(params, Parameter{Name: e.Name, URL: e.URL})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params, nil
+}
+
+// ListFiles returns the GRIB files of a stream. The .index is written after
+// the GRIB file is complete, so files without one are left out.
+func (s *ecmwfSource) ListFiles(param Parameter) ([]index.File, error) {
+	entries, err := s.client.List(param.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	inventories := make(map[string]bool)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name, ".index") {
+			inventories[strings.TrimSuffix(e.Name, ".index")] = true
+		}
+	}
+
+	var files []index.File
+	pending := 0
+	for _, e := range entries {
+		match := ecmwfFilePattern.FindStringSubmatch(e.Name)
+		if e.Dir || match == nil {
+			continue
+		}
+		if !inventories[strings.TrimSuffix(e.Name, ".grib2")] {
+			pending++
+			continue
+		}
+		ref, _ := time.ParseInLocation("20060102150405", match[1], time.UTC)
+		files = append(files, index.File{
+			Name:          e.Name,
+			URL:           e.URL,
+			ModTime:       e.ModTime,
+			Size:          e.Size,
+			ReferenceTime: ref,
+			Leadtime:      time.Duration(parseInt(match[2])) * time.Hour,
+		})
+	}
+
+	if pending > 0 && *verbose {
+		log.Printf("Skipping %d %s files without an .index, they are still being written", pending, param.Name)
+	}
+	return files, nil
+}
+
+// InventoryURL returns the URL of the .index of a GRIB file
+func (s *ecmwfSource) InventoryURL(fileURL string) string {
+	return strings.TrimSuffix(fileURL, ".grib2") + ".index"
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// exitExpiredUpstream is the exit status when the selected run was removed
+// from the server while it was being downloaded
+const exitExpiredUpstream = 3
+
+// expiryCheckAfter is the number of downloads answered with 404 Not Found
+// after which the run listing is checked for the selected run
+const expiryCheckAfter = 3
+
+// errRunExpired is returned for downloads of a run that has left the server
+var errRunExpired = errors.New("run expired upstream")
+
+// statusError is an unsuccessful HTTP response to a download
+type statusError struct {
+	Status string
+	Code   int
+}
+
+func (e *statusError) Error() string { return "download failed with status: " + e.Status }
+
+// isNotFound reports whether a download failed with 404 Not Found
+func isNotFound(err error) bool {
+	var s *statusError
+	return errors.As(err, &s) && s.Code == http.StatusNotFound
+}
+
+// upstreamExpiry notices when the selected run is rotated away on the server
+// in the middle of the download, e.g. near the end of the retention period.
+// Files that are not found trigger a check of the run listing, and once the
+// run is no longer listed, no further downloads are started.
+type upstreamExpiry struct {
+	run ModelRun
+
+	mu       sync.Mutex
+	notFound int // Downloads answered with 404 since the last check
+	expired  atomic.Bool
+}
+
+// runExpiry watches the selected run, nil before a run is selected
+var runExpiry *upstreamExpiry
+
+// isExpired reports whether the selected run has left the server
+func (e *upstreamExpiry) isExpired() bool {
+	return e != nil && e.expired.Load()
+}
+
+// fileNotFound records a download answered with 404 and checks the run
+// listing after every expiryCheckAfter of them. It reports whether the run
+// has expired.
+func (e *upstreamExpiry) fileNotFound() bool {
+	if e == nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.expired.Load() {
+		return true
+	}
+	e.notFound++
+	if e.notFound < expiryCheckAfter {
+		return false
+	}
+	e.notFound = 0
+
+	runs, err := source.ListRuns()
+	if err != nil {
+		if *verbose {
+			log.Printf("Warning: cannot check whether run %s is still listed: %v", e.run.Time, err)
+		}
+		return false
+	}
+	for _, run := range runs {
+		if run.Time == e.run.Time && run.ReferenceTime.Equal(e.run.ReferenceTime) {
+			return false
+		}
+	}
+
+	log.Printf("Warning: run %s (reference time: %s) is no longer listed upstream, stopping the download",
+		e.run.Time, formatUTC(e.run.ReferenceTime))
+	e.expired.Store(true)
+	return true
+}
+
+// exitIfExpired ends the downloader with exitExpiredUpstream if the selected
+// run expired, marking the run as expired in its manifest
+func exitIfExpired(plan []*PlannedFile, runLock *RunLock) {
+	if !runExpiry.isExpired() {
+		return
+	}
+
+	missing := 0
+	for _, f := range plan {
+		if _, err := os.Stat(f.LocalPath); err != nil {
+			missing++
+		}
+	}
+	runManifest.markExpired(time.Now().UTC())
+	if err := runManifest.save(); err != nil {
+		log.Printf("Warning: failed to save manifest: %v", err)
+	}
+	if err := bandwidthUsage.save(); err != nil {
+		log.Printf("Warning: failed to save bandwidth accounting: %v", err)
+	}
+
+	log.Printf("Run %s expired upstream: %d of %d planned files were not downloaded", runExpiry.run.Time, missing, len(plan))
+	runLock.release()
+	os.Exit(exitExpiredUpstream)
+}
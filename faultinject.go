@@ -0,0 +1,93 @@
+//go:build faultinject
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Fault injection for resilience testing, compiled in only with
+// "go build -tags faultinject" and configured through the environment:
+//
+//	ICOND_FAULT_FAIL_PERCENT     fail this percentage of downloads before the request
+//	ICOND_FAULT_DELAY            delay every download by this duration, e.g. 30s
+//	ICOND_FAULT_CORRUPT_PERCENT  corrupt the bz2 stream of this percentage of downloads
+var faults struct {
+	failPercent    float64
+	delay          time.Duration
+	corruptPercent float64
+}
+
+func init() {
+	faults.failPercent = faultPercent("ICOND_FAULT_FAIL_PERCENT")
+	faults.corruptPercent = faultPercent("ICOND_FAULT_CORRUPT_PERCENT")
+	if value := os.Getenv("ICOND_FAULT_DELAY"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid ICOND_FAULT_DELAY: %v", err)
+		}
+		faults.delay = d
+	}
+
+	log.Printf("Warning: fault injection build: failing %.0f%%, corrupting %.0f%%, delaying downloads by %s",
+		faults.failPercent, faults.corruptPercent, faults.delay)
+}
+
+// faultPercent reads a percentage from the environment
+func faultPercent(name string) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil || p < 0 || p > 100 {
+		log.Fatalf("Invalid %s %q, expected a percentage between 0 and 100", name, value)
+	}
+	return p
+}
+
+// injectFault delays the download of url and returns an error for the
+// configured share of downloads
+func injectFault(url string) error {
+	if faults.delay > 0 {
+		time.Sleep(faults.delay)
+	}
+	if rand.Float64()*100 < faults.failPercent {
+		return fmt.Errorf("injected failure for %s", url)
+	}
+	return nil
+}
+
+// faultReader corrupts the downloaded stream of the configured share of downloads
+func faultReader(url string, r io.Reader) io.Reader {
+	if rand.Float64()*100 >= faults.corruptPercent {
+		return r
+	}
+	if *verbose {
+		log.Printf("Injecting corruption into %s", url)
+	}
+	return &corruptingReader{r: r}
+}
+
+// corruptingReader flips bits in the data it reads after the bz2 header
+type corruptingReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (c *corruptingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i := 0; i < n; i++ {
+		if (c.offset+int64(i))%1024 == 100 {
+			p[i] ^= 0xff
+		}
+	}
+	c.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,11 @@
+//go:build !faultinject
+
+package main
+
+import "io"
+
+// injectFault is a no-op outside fault injection builds
+func injectFault(url string) error { return nil }
+
+// faultReader returns r unchanged outside fault injection builds
+func faultReader(url string, r io.Reader) io.Reader { return r }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// gridNames are the grid tokens known to appear in DWD file names
+var gridNames = []string{"icosahedral", "regular-lat-lon", "rotated-lat-lon"}
+
+// maxFileNameSamples is the number of example names logged per problem
+const maxFileNameSamples = 3
+
+// reportedFileNames remembers the problems already reported per parameter,
+// so that polling with -wait does not repeat them
+var reportedFileNames = struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// fileNameProblem returns why the fields parsed from a file name do not fit
+// the selected model and run, or "" if they do
+func fileNameProblem(f index.File, param, runHour string) string {
+	if f.ReferenceTime.IsZero() {
+		return "no reference time"
+	}
+	if fmt.Sprintf("%02d", f.ReferenceTime.Hour()) != runHour {
+		return "reference time not in run " + runHour
+	}
+	if selectedModel.LevelTypes && f.LevelType == "" {
+		return "no known level type"
+	}
+	if selectedModel.Grid != "" && !hasKnownGrid(f.Name) {
+		return "no known grid"
+	}
+	if !f.HasLeadtime() {
+		if f.LevelType != "time-invariant" && !selectedModel.Archives {
+			return "no forecast step"
+		}
+		return ""
+	}
+	// The schedules list the hourly steps; sub-hourly output comes on top of them
+	if f.Leadtime%time.Hour != 0 {
+		return ""
+	}
+	if steps, ok := selectedModel.paramScheduledSteps(runHour, param); ok && !containsStep(steps, f.Leadtime) {
+		return "forecast step not in the model schedule"
+	}
+	return ""
+}
+
+// hasKnownGrid reports whether a file name contains one of the known grid tokens
+func hasKnownGrid(name string) bool {
+	for _, grid := range gridNames {
+		if strings.Contains(name, "_"+grid+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFileNames validates the names of a parameter listing against the model
+// schedule and logs an "unknown filename format" warning with sample names for
+// each kind of mismatch. Such files would otherwise be skipped by the filters
+// without notice, which usually means DWD changed its naming convention.
+func checkFileNames(param, runHour string, files []index.File) {
+	samples := make(map[string][]string)
+	counts := make(map[string]int)
+	for _, f := range files {
+		problem := fileNameProblem(f, param, runHour)
+		if problem == "" {
+			continue
+		}
+		counts[problem]++
+		if len(samples[problem]) < maxFileNameSamples {
+			samples[problem] = append(samples[problem], f.Name)
+		}
+	}
+
+	problems := make([]string, 0, len(counts))
+	for problem := range counts {
+		problems = append(problems, problem)
+	}
+	sort.Strings(problems)
+
+	reportedFileNames.mu.Lock()
+	defer reportedFileNames.mu.Unlock()
+	for _, problem := range problems {
+		key := param + "\x00" + problem
+		if reportedFileNames.seen[key] {
+			continue
+		}
+		reportedFileNames.seen[key] = true
+		log.Printf("Warning: UNKNOWN FILENAME FORMAT for parameter %s: %s (%d of %d files), e.g. %s",
+			param, problem, counts[problem], len(files), strings.Join(samples[problem], ", "))
+	}
+}
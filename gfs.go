@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// gfsLayout is the NOMADS tree of daily gfs.YYYYMMDD directories. NOMADS
+// keeps about ten days, but only the latest runs are of interest.
+var gfsLayout = dailyLayout{
+	Day:    regexp.MustCompile(`^gfs\.(\d{8})$`),
+	Hour:   regexp.MustCompile(`^(\d\d)$`),
+	Suffix: "atmos/",
+	Days:   2,
+}
+
+var (
+	// gfsFilePattern matches product files with a forecast step, e.g.
+	// "gfs.t00z.pgrb2.0p25.f012", capturing the run hour, product and step
+	gfsFilePattern = regexp.MustCompile(`^gfs\.t(\d\d)z\.([a-z0-9]+\.\dp\d\d)\.f(\d{3})$`)
+
+	// gfsRunPattern finds the day and hour of a run in a directory URL
+	gfsRunPattern = regexp.MustCompile(`/gfs\.(\d{8})/(\d\d)/`)
+)
+
+// gfsSource reads the NOMADS directory tree of NOAA GFS, or a mirror of it:
+// gfs.YYYYMMDD/HH/atmos/ directories holding one uncompressed GRIB file per
+// product and forecast step, each with an .idx inventory of its messages.
+// Products such as pgrb2.0p25 take the place of DWD parameter directories.
+type gfsSource struct {
+	httpFetcher        // Also lists the directory indexes with its client
+	baseURL     string // URL of the directory containing the daily directories
+}
+
+// newGFSSource creates a source for the daily GFS directories under baseURL
+func newGFSSource(client *index.Client, baseURL string) *gfsSource {
+	return &gfsSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+	}
+}
+
+// ListRuns returns the runs of the latest daily directories
+func (s *gfsSource) ListRuns() ([]ModelRun, error) {
+	return listDailyRuns(s.client, s.baseURL, gfsLayout)
+}
+
+// ListParameters returns the products published in a run directory
+func (s *gfsSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	entries, err := s.client.List(run.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var params []Parameter
+	for _, e := range entries {
+		match := gfsFilePattern.FindStringSubmatch(e.Name)
+		if e.Dir || match == nil || seen[match[2]] {
+			continue
+		}
+		seen[match[2]] = true
+		params = append(params, Parameter{Name: match[2], URL: run.URL})
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params, nil
+}
+
+// ListFiles returns the files of a product. NOMADS writes the .idx inventory
+// after the GRIB file is complete, so files without one are still being
+// written and are left out.
+func (s *gfsSource) ListFiles(param Parameter) ([]index.File, error) {
+	entries, err := s.client.List(param.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var ref time.Time
+	if match := gfsRunPattern.FindStringSubmatch(param.URL); match != nil {
+		ref, _ = time.ParseInLocation("20060102 15", match[1]+" "+match[2], time.UTC)
+	}
+
+	inventories := make(map[string]bool)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name, ".idx") {
+			inventories[strings.TrimSuffix(e.Name, ".idx")] = true
+		}
+	}
+
+	var files []index.File
+	pending := 0
+	for _, e := range entries {
+		match := gfsFilePattern.FindStringSubmatch(e.Name)
+		if e.Dir || match == nil || match[2] != param.Name {
+			continue
+		}
+		if !inventories[e.Name] {
+			pending++
+			continue
+		}
+		files = append(files, index.File{
+			Name:          e.Name,
+			URL:           e.URL,
+			ModTime:       e.ModTime,
+			Size:          e.Size,
+			ReferenceTime: ref,
+			Leadtime:      time.Duration(parseInt(match[3])) * time.Hour,
+		})
+	}
+
+	if pending > 0 && *verbose {
+		log.Printf("Skipping %d %s files without an .idx inventory, they are still being written", pending, param.Name)
+	}
+	return files, nil
+}
+
+// InventoryURL returns the URL of the .idx inventory of a GRIB file
+func (s *gfsSource) InventoryURL(fileURL string) string {
+	return fileURL + ".idx"
+}
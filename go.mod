@@ -5,3 +5,10 @@ go 1.23.0
 toolchain go1.23.7
 
 require golang.org/x/net v0.37.0
+
+require (
+	golang.org/x/term v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.31.0 // indirect
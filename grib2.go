@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// grib2Message is the location and level of a message in a GRIB2 file
+type grib2Message struct {
+	Offset int64
+	Length int64
+	Level  string // Label of the first fixed surface, e.g. "pl850"
+}
+
+// scanGRIB2 lists the messages of a GRIB2 file, reading only the section
+// headers and the product definition sections
+func scanGRIB2(r io.ReaderAt, size int64) ([]grib2Message, error) {
+	var messages []grib2Message
+	for offset := int64(0); offset < size; {
+		indicator := make([]byte, 16)
+		if _, err := r.ReadAt(indicator, offset); err != nil {
+			return nil, fmt.Errorf("truncated message at offset %d", offset)
+		}
+		if string(indicator[:4]) != "GRIB" {
+			return nil, fmt.Errorf("no GRIB message at offset %d", offset)
+		}
+		if indicator[7] != 2 {
+			return nil, fmt.Errorf("message at offset %d is GRIB edition %d, only edition 2 is supported", offset, indicator[7])
+		}
+		length := int64(binary.BigEndian.Uint64(indicator[8:]))
+		if length < 16 || offset+length > size {
+			return nil, fmt.Errorf("invalid message length %d at offset %d", length, offset)
+		}
+
+		level, err := messageLevel(r, offset, length)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, grib2Message{Offset: offset, Length: length, Level: level})
+		offset += length
+	}
+	return messages, nil
+}
+
+// messageLevel returns the level label of the first product definition
+// section of a message
+func messageLevel(r io.ReaderAt, offset, length int64) (string, error) {
+	header := make([]byte, 5)
+	for pos := offset + 16; pos+4 < offset+length; {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return "", fmt.Errorf("truncated section at offset %d", pos)
+		}
+		if string(header[:4]) == "7777" {
+			break
+		}
+		sectionLength := int64(binary.BigEndian.Uint32(header))
+		if sectionLength < 5 || pos+sectionLength > offset+length {
+			return "", fmt.Errorf("invalid section length %d at offset %d", sectionLength, pos)
+		}
+		if header[4] == 4 {
+			section := make([]byte, sectionLength)
+			if _, err := r.ReadAt(section, pos); err != nil {
+				return "", fmt.Errorf("truncated section at offset %d", pos)
+			}
+			return productLevel(section), nil
+		}
+		pos += sectionLength
+	}
+	return "unknown", nil
+}
+
+// productLevel returns the level label of a product definition section.
+// Templates 4.0 to 4.15 share the layout up to the first fixed surface;
+// other templates are labelled "unknown".
+func productLevel(section []byte) string {
+	if len(section) < 28 {
+		return "unknown"
+	}
+	template := binary.BigEndian.Uint16(section[7:9])
+	if template > 15 {
+		return "unknown"
+	}
+	surface := section[22]
+	scale := gribSigned(section[23])
+	value := binary.BigEndian.Uint32(section[24:28])
+	return levelLabel(surface, scale, value)
+}
+
+// gribSigned decodes a GRIB2 sign-and-magnitude octet
+func gribSigned(b byte) int {
+	if b&0x80 != 0 {
+		return -int(b & 0x7f)
+	}
+	return int(b)
+}
+
+// levelLabel names a fixed surface for file names, e.g. "pl850" for the
+// 850 hPa isobaric surface or "hag2" for 2 m above ground
+func levelLabel(surface byte, scale int, scaled uint32) string {
+	missing := scaled == math.MaxUint32
+	value := float64(scaled) * math.Pow(10, float64(-scale))
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	switch surface {
+	case 1:
+		return "sfc"
+	case 101:
+		return "msl"
+	case 100:
+		if !missing {
+			return "pl" + format(value/100)
+		}
+	case 102:
+		if !missing {
+			return "amsl" + format(value)
+		}
+	case 103:
+		if !missing {
+			return "hag" + format(value)
+		}
+	case 105:
+		if !missing {
+			return "ml" + format(value)
+		}
+	case 106:
+		if !missing {
+			return "dbl" + format(value)
+		}
+	}
+	if missing {
+		return fmt.Sprintf("lt%d", surface)
+	}
+	return fmt.Sprintf("lt%d_%s", surface, format(value))
+}
+
+// splitDownload splits a downloaded file by level for -split-levels,
+// recording the level files in the plan and the download result. A file
+// that cannot be scanned is kept as downloaded.
+func splitDownload(f *PlannedFile, result *downloadResult) {
+	levels, err := splitByLevel(f.LocalPath)
+	if err != nil {
+		log.Printf("Warning: keeping %s unsplit: %v", filepath.Base(f.LocalPath), err)
+		return
+	}
+	if levels == nil {
+		return
+	}
+	result.Levels = make(map[string]string)
+	for level, path := range levels {
+		result.Levels[level] = filepath.Base(path)
+		f.Outputs = append(f.Outputs, path)
+	}
+	sort.Strings(f.Outputs)
+	if *verbose {
+		log.Printf("Split %s into %d levels", filepath.Base(f.LocalPath), len(levels))
+	}
+}
+
+// splitOutputsExist reports whether a file missing from the run directory
+// was split by level before and all its level files are still there,
+// returning their number
+func splitOutputsExist(f *PlannedFile) (int, bool) {
+	entry := runManifest.entry(f.LocalPath)
+	if entry == nil || len(entry.Levels) == 0 {
+		return 0, false
+	}
+	dir := filepath.Dir(f.LocalPath)
+	for _, name := range entry.Levels {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() == 0 {
+			return 0, false
+		}
+	}
+	return len(entry.Levels), true
+}
+
+// splitByLevel writes the messages of a GRIB2 file into one file per level
+// next to it, named after the level, and removes the original. It returns
+// the paths of the level files by level, or nil if the file is not GRIB or
+// holds a single level and was kept as is.
+func splitByLevel(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// NetCDF, KMZ and archive outputs are left alone
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, []byte("GRIB")) {
+		return nil, nil
+	}
+
+	messages, err := scanGRIB2(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	byLevel := make(map[string][]grib2Message)
+	var levels []string
+	for _, m := range messages {
+		if _, seen := byLevel[m.Level]; !seen {
+			levels = append(levels, m.Level)
+		}
+		byLevel[m.Level] = append(byLevel[m.Level], m)
+	}
+	if len(levels) < 2 {
+		return nil, nil
+	}
+	sort.Strings(levels)
+
+	ext := filepath.Ext(path)
+	if ext != ".grib2" && ext != ".grb2" {
+		ext = ""
+	}
+	base := strings.TrimSuffix(path, ext)
+
+	outputs := make(map[string]string)
+	for _, level := range levels {
+		levelPath := base + "_" + level + ext
+		if err := writeMessages(f, byLevel[level], levelPath); err != nil {
+			for _, p := range outputs {
+				os.Remove(p)
+			}
+			return nil, err
+		}
+		outputs[level] = levelPath
+	}
+	f.Close()
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// writeMessages copies messages of a GRIB file into a new file atomically
+func writeMessages(src io.ReaderAt, messages []grib2Message, path string) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if _, err = io.Copy(out, io.NewSectionReader(src, m.Offset, m.Length)); err != nil {
+			break
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
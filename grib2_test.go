@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// testSection returns a GRIB2 section of the given number with its length
+// header, followed by the content
+func testSection(number byte, content []byte) []byte {
+	section := binary.BigEndian.AppendUint32(nil, uint32(5+len(content)))
+	section = append(section, number)
+	return append(section, content...)
+}
+
+// testProductSection returns a product definition section of a template
+// with its first fixed surface
+func testProductSection(template uint16, surface byte, scale byte, value uint32) []byte {
+	content := make([]byte, 29) // Up to octet 34
+	binary.BigEndian.PutUint16(content[2:4], template)
+	content[17] = surface
+	content[18] = scale
+	binary.BigEndian.PutUint32(content[19:23], value)
+	return testSection(4, content)
+}
+
+// testMessage returns a GRIB message of an edition holding the sections,
+// with its length in the indicator section
+func testMessage(edition byte, sections ...[]byte) []byte {
+	body := bytes.Join(sections, nil)
+	message := append([]byte("GRIB"), 0, 0, 0, edition)
+	message = binary.BigEndian.AppendUint64(message, uint64(16+len(body)+4))
+	message = append(message, body...)
+	return append(message, "7777"...)
+}
+
+func TestScanGRIB2(t *testing.T) {
+	identification := testSection(1, make([]byte, 16))
+	pl850 := testMessage(2, identification, testProductSection(0, 100, 0, 85000))
+	hag2 := testMessage(2, identification, testProductSection(8, 103, 0, 2))
+	msl := testMessage(2, identification, testProductSection(0, 101, 0, 0))
+	noProduct := testMessage(2, identification)
+	otherTemplate := testMessage(2, identification, testProductSection(40, 100, 0, 50000))
+
+	badLength := testMessage(2, identification)
+	binary.BigEndian.PutUint64(badLength[8:], 8)
+	badSection := testMessage(2, identification)
+	binary.BigEndian.PutUint32(badSection[16:], 3)
+	longSection := testMessage(2, identification)
+	binary.BigEndian.PutUint32(longSection[16:], 1000)
+	notGRIB := append([]byte("GRIX"), pl850[4:]...)
+
+	join := func(messages ...[]byte) []byte { return bytes.Join(messages, nil) }
+	tests := []struct {
+		name   string
+		data   []byte
+		levels []string
+		err    string
+	}{
+		{"empty", nil, nil, ""},
+		{"single message", pl850, []string{"pl850"}, ""},
+		{"multiple messages", join(pl850, hag2, msl), []string{"pl850", "hag2", "msl"}, ""},
+		{"no product definition", noProduct, []string{"unknown"}, ""},
+		{"other template", otherTemplate, []string{"unknown"}, ""},
+		{"truncated indicator", join(pl850, pl850[:10]), nil, "truncated message at offset"},
+		{"truncated message", join(pl850, hag2[:len(hag2)-10]), nil, "invalid message length"},
+		{"not GRIB", notGRIB, nil, "no GRIB message at offset 0"},
+		{"trailing garbage", join(pl850, []byte(strings.Repeat("x", 20))), nil, "no GRIB message at offset"},
+		{"edition 1", testMessage(1, identification), nil, "GRIB edition 1"},
+		{"message too short", badLength, nil, "invalid message length 8"},
+		{"section too short", badSection, nil, "invalid section length 3"},
+		{"section beyond message", longSection, nil, "invalid section length 1000"},
+	}
+	for _, tt := range tests {
+		messages, err := scanGRIB2(bytes.NewReader(tt.data), int64(len(tt.data)))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: scanGRIB2 error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: scanGRIB2: %v", tt.name, err)
+			continue
+		}
+		if len(messages) != len(tt.levels) {
+			t.Errorf("%s: scanGRIB2 found %d messages, want %d", tt.name, len(messages), len(tt.levels))
+			continue
+		}
+		var offset int64
+		for i, m := range messages {
+			if m.Offset != offset || m.Level != tt.levels[i] {
+				t.Errorf("%s: message %d at offset %d with level %q, want offset %d and level %q", tt.name, i, m.Offset, m.Level, offset, tt.levels[i])
+			}
+			offset += m.Length
+		}
+		if offset != int64(len(tt.data)) {
+			t.Errorf("%s: messages span %d bytes, want %d", tt.name, offset, len(tt.data))
+		}
+	}
+}
+
+func TestLevelLabel(t *testing.T) {
+	tests := []struct {
+		surface byte
+		scale   int
+		value   uint32
+		want    string
+	}{
+		{1, 0, 0, "sfc"},
+		{101, 0, 0, "msl"},
+		{100, 0, 85000, "pl850"},
+		{100, 0, 5000, "pl50"},
+		{103, 0, 2, "hag2"},
+		{103, 1, 15, "hag1.5"},
+		{105, 0, 65, "ml65"},
+		{106, 2, 3, "dbl0.03"},
+		{102, -1, 50, "amsl500"},
+		{100, 0, 0xffffffff, "lt100"},
+		{200, 0, 0xffffffff, "lt200"},
+		{200, 0, 7, "lt200_7"},
+	}
+	for _, tt := range tests {
+		if got := levelLabel(tt.surface, tt.scale, tt.value); got != tt.want {
+			t.Errorf("levelLabel(%d, %d, %d) = %q, want %q", tt.surface, tt.scale, tt.value, got, tt.want)
+		}
+	}
+}
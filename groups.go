@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// groupPrefix marks a parameter group in -params, e.g. "group:surface"
+const groupPrefix = "group:"
+
+// paramGroups are the built-in parameter groups of the ICON models. Unlike
+// the parameter sets of a model, groups are the same for all models and may
+// select levels with the -params syntax; parameters a model does not publish
+// are skipped with a warning.
+var paramGroups = map[string][]string{
+	"surface": {
+		"t_2m", "td_2m", "relhum_2m", "tmax_2m", "tmin_2m", "pmsl", "ps",
+		"u_10m", "v_10m", "vmax_10m",
+		"tot_prec", "rain_gsp", "rain_con", "snow_gsp", "snow_con", "h_snow",
+		"t_g", "clct", "ww",
+	},
+	"wind-energy": {
+		"u_10m", "v_10m", "vmax_10m", "t_2m", "relhum_2m", "ps", "z0",
+		"u@1000,950,925", "v@1000,950,925", "t@1000,950,925",
+	},
+	"solar-energy": {
+		"asob_s", "aswdir_s", "aswdifd_s", "aswdifu_s", "alb_rad",
+		"clct", "clcl", "clcm", "clch", "t_2m", "h_snow",
+	},
+	"aviation": {
+		"t_2m", "td_2m", "pmsl", "u_10m", "v_10m", "vmax_10m", "ww",
+		"clct", "clcl", "clcm", "clch", "hbas_con", "htop_con", "hzerocl", "cape_ml",
+		"tot_prec", "t@850,700,500,300,250", "u@850,700,500,300,250", "v@850,700,500,300,250",
+		"relhum@850,700,500",
+	},
+}
+
+// userParamGroups are the groups defined under "groups" in the -config
+// file, taking precedence over built-in groups of the same name
+var userParamGroups = make(map[string][]string)
+
+// lookupParamGroup returns the entries of a parameter group
+func lookupParamGroup(name string) ([]string, bool) {
+	if entries, ok := userParamGroups[name]; ok {
+		return entries, true
+	}
+	entries, ok := paramGroups[name]
+	return entries, ok
+}
+
+// paramGroupNames returns the names of all groups in sorted order
+func paramGroupNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, groups := range []map[string][]string{paramGroups, userParamGroups} {
+		for name := range groups {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, groupPrefix+name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandParamGroups replaces the group:name fields of a -params list by the
+// fields of the groups, which may refer to other groups
+func expandParamGroups(fields []string) ([]string, error) {
+	return expandParamGroupsFrom(fields, nil)
+}
+
+// expandParamGroupsFrom expands groups within the groups named in path,
+// rejecting groups that include themselves
+func expandParamGroupsFrom(fields []string, path []string) ([]string, error) {
+	var expanded []string
+	for _, field := range fields {
+		name, isGroup := strings.CutPrefix(field, groupPrefix)
+		if !isGroup {
+			expanded = append(expanded, field)
+			continue
+		}
+		for _, p := range path {
+			if p == name {
+				return nil, fmt.Errorf("parameter group %s includes itself", groupPrefix+name)
+			}
+		}
+		entries, ok := lookupParamGroup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter group %s, available: %s", field, strings.Join(paramGroupNames(), ", "))
+		}
+		fields, err := expandParamGroupsFrom(splitParamFields(strings.Join(entries, " ")), append(path, name))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, fields...)
+	}
+	return expanded, nil
+}
+
+// splitParamFields splits a -params list at commas and white space
+func splitParamFields(spec string) []string {
+	return strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' })
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// Parsed hook commands, nil if not given
+var (
+	leadtimeHookTemplate *template.Template // -leadtime-hook
+	fileHookTemplate     *template.Template // -file-hook
+	runHookTemplate      *template.Template // -run-hook
+)
+
+// runningHooks waits for the hook commands started in the background
+var runningHooks sync.WaitGroup
+
+// hookSlots limits the hook commands running in the background to
+// -concurrent, so that a burst of small files does not start a process for
+// each of them at once
+var hookSlots chan struct{}
+
+// startHook runs a hook command in the background once a hook slot is free
+func startHook(run func()) {
+	runningHooks.Add(1)
+	go func() {
+		defer runningHooks.Done()
+		hookSlots <- struct{}{}
+		defer func() { <-hookSlots }()
+		run()
+	}()
+}
+
+// hookRun is the run the hooks are fired for
+var hookRun ModelRun
+
+// hookFuncs are the functions available in hook templates besides the
+// predefined ones, e.g. {{json .Files}} for a webhook payload
+var hookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// LeadtimeEvent describes a lead time whose files are all done and is passed
+// to the -leadtime-hook template
+type LeadtimeEvent struct {
+	Model    string   // Model name, e.g. "icon-eu"
+	Run      string   // Run hour, e.g. "06"
+	RunTime  string   // Reference time, e.g. "2025031206"
+	Leadtime int      // Forecast step in whole hours
+	Step     string   // Forecast step as a duration, e.g. "6h" or "2h15m"
+	Dir      string   // Run directory
+	Files    []string // Local paths of the files of the lead time
+}
+
+// FileEvent describes a downloaded file and is passed to the -file-hook
+// template
+type FileEvent struct {
+	Model    string   // Model name, e.g. "icon-eu"
+	Run      string   // Run hour, e.g. "06"
+	RunTime  string   // Reference time, e.g. "2025031206"
+	Param    string   // Parameter name, e.g. "t_2m"
+	Leadtime int      // Forecast step in whole hours, -1 for time-invariant files
+	Step     string   // Forecast step as a duration, empty for time-invariant files
+	Path     string   // Local path of the file
+	Files    []string // Level files after -split-levels, otherwise just Path
+}
+
+// RunEvent describes a finished run and is passed to the -run-hook template
+type RunEvent struct {
+	Model       string   // Model name, e.g. "icon-eu"
+	Run         string   // Run hour, e.g. "06"
+	RunTime     string   // Reference time, e.g. "2025031206"
+	Path        string   // Run directory
+	Complete    bool     // Whether the run was found complete
+	Downloaded  int      // Number of files downloaded by this invocation
+	FailedCount int      // Number of files that failed to download, plus parameters that could not be listed
+	Failed      []string // Parameters with fewer files than required, whatever the cause
+}
+
+// parseHook parses a hook command template and checks that it only refers
+// to fields of the event type of the sample and expands to a command line
+// that can be split
+func parseHook(name, command string, sample any) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(hookFuncs).Parse(command)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, sample); err != nil {
+		return nil, err
+	}
+	if _, err := splitCommand(buf.String()); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// parseLeadtimeHook parses the -leadtime-hook command template
+func parseLeadtimeHook(command string) (*template.Template, error) {
+	return parseHook("leadtime-hook", command, LeadtimeEvent{Files: []string{""}})
+}
+
+// parseFileHook parses the -file-hook command template
+func parseFileHook(command string) (*template.Template, error) {
+	return parseHook("file-hook", command, FileEvent{Files: []string{""}})
+}
+
+// parseRunHook parses the -run-hook command template
+func parseRunHook(command string) (*template.Template, error) {
+	return parseHook("run-hook", command, RunEvent{Failed: []string{""}})
+}
+
+// splitCommand splits an expanded hook command into arguments at
+// whitespace outside of single and double quotes. A backslash outside of
+// single quotes escapes the next character.
+func splitCommand(command string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("command ends in a backslash")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// hookEnvPrefix prefixes the environment variables passed to hook commands.
+// No option is named hook-*, so a download started by a hook does not take
+// them for options.
+const hookEnvPrefix = "ICOND_HOOK_"
+
+// execHook expands a hook template for an event and runs the command with
+// the given ICOND_HOOK_* variables added to the environment
+func execHook(tmpl *template.Template, event any, env ...string) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return err
+	}
+	args, err := splitCommand(buf.String())
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+
+	if *verbose {
+		log.Printf("Running %s: %s", tmpl.Name(), strings.Join(args, " "))
+	}
+	return cmd.Run()
+}
+
+// hookRunTime returns the reference time of the hook run as YYYYMMDDHH,
+// taken from the file name if the listing had none
+func hookRunTime(info index.File) string {
+	reference := hookRun.ReferenceTime
+	if reference.IsZero() {
+		reference = info.ReferenceTime
+	}
+	if reference.IsZero() {
+		return ""
+	}
+	return reference.Format("2006010215")
+}
+
+// leadtimes tracks the lead times of the run for the -leadtime-hook, across
+// all polls of -wait
+var leadtimes *leadtimeTracker
+
+// leadtimeTracker fires the -leadtime-hook once the files of all requested
+// parameters of a lead time have been downloaded or kept. The files expected
+// per parameter and lead time follow from the listings: a parameter is
+// expected at the scheduled lead times from its first published step on,
+// or at its listed lead times for models without a schedule, with the
+// largest number of levels listed for one step.
+type leadtimeTracker struct {
+	mu        sync.Mutex
+	run       ModelRun
+	scheduled map[string]map[time.Duration]bool // Lead times of the schedule per parameter, nil without a schedule
+	params    []string
+	steps     map[string]map[time.Duration]bool // Listed lead times per parameter
+	levels    map[string]int                    // Most files listed for one lead time per parameter
+	done      map[string]map[time.Duration]int  // Finished files per parameter and lead time
+	failed    map[time.Duration]bool
+	fired     map[time.Duration]bool
+	files     map[time.Duration][]string
+}
+
+// newLeadtimeTracker creates the tracker for the requested parameters of a run
+func newLeadtimeTracker(run ModelRun, params []Parameter) *leadtimeTracker {
+	t := &leadtimeTracker{
+		run:    run,
+		steps:  make(map[string]map[time.Duration]bool),
+		levels: make(map[string]int),
+		done:   make(map[string]map[time.Duration]int),
+		failed: make(map[time.Duration]bool),
+		fired:  make(map[time.Duration]bool),
+		files:  make(map[time.Duration][]string),
+	}
+	if _, ok := selectedModel.expectedSteps(run.Time); ok {
+		t.scheduled = make(map[string]map[time.Duration]bool)
+	}
+	for _, p := range params {
+		t.params = append(t.params, p.Name)
+		if t.scheduled == nil {
+			continue
+		}
+		expected, _ := selectedModel.paramExpectedSteps(run.Time, p.Name)
+		t.scheduled[p.Name] = make(map[time.Duration]bool)
+		for _, step := range expected {
+			t.scheduled[p.Name][step] = true
+		}
+	}
+	return t
+}
+
+// listed records the files of a parameter listing that pass the grid and
+// level filters, before they are filtered by step
+func (t *leadtimeTracker) listed(param string, files []index.File) {
+	if t == nil || leadtimeHookTemplate == nil {
+		return
+	}
+	perStep := make(map[time.Duration]int)
+	for _, f := range files {
+		if f.HasLeadtime() {
+			perStep[f.Leadtime]++
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.steps[param] == nil {
+		t.steps[param] = make(map[time.Duration]bool)
+	}
+	for step, count := range perStep {
+		t.steps[param][step] = true
+		t.levels[param] = max(t.levels[param], count)
+	}
+}
+
+// expects reports whether files of a parameter are expected at a lead time
+func (t *leadtimeTracker) expects(param string, leadtime time.Duration) bool {
+	steps := t.steps[param]
+	if t.scheduled == nil {
+		return steps[leadtime]
+	}
+	if !t.scheduled[param][leadtime] || len(steps) == 0 {
+		return false
+	}
+	first := leadtime
+	for step := range steps {
+		first = min(first, step)
+	}
+	return leadtime >= first
+}
+
+// complete reports whether all expected files of a lead time are finished
+func (t *leadtimeTracker) complete(leadtime time.Duration) bool {
+	for _, param := range t.params {
+		if t.expects(param, leadtime) && t.done[param][leadtime] < t.levels[param] {
+			return false
+		}
+	}
+	return true
+}
+
+// notYetAvailable records a zero-byte placeholder. Its lead time waits for
+// the file to be published unless -zero-byte ignores or fails it.
+func (t *leadtimeTracker) notYetAvailable(f *PlannedFile) {
+	switch *zeroBytePolicy {
+	case zeroByteIgnore:
+		t.finish(f, true, false)
+	case zeroByteError:
+		t.finish(f, false, false)
+	}
+}
+
+// fileDone records a finished file and runs the hook when it completed its
+// lead time. Lead times with failed files do not fire the hook.
+func (t *leadtimeTracker) fileDone(f *PlannedFile, success bool) {
+	t.finish(f, success, success)
+}
+
+// finish records a finished file, with its outputs if it has any, and runs
+// the hook when it completed its lead time
+func (t *leadtimeTracker) finish(f *PlannedFile, success, hasOutputs bool) {
+	if t == nil || leadtimeHookTemplate == nil || !f.Info.HasLeadtime() {
+		return
+	}
+	leadtime := f.Info.Leadtime
+
+	t.mu.Lock()
+	t.record(f, success, hasOutputs)
+	if t.fired[leadtime] || !t.complete(leadtime) {
+		t.mu.Unlock()
+		return
+	}
+	t.fired[leadtime] = true
+	failed := t.failed[leadtime]
+	files := append([]string(nil), t.files[leadtime]...)
+	t.mu.Unlock()
+
+	if failed {
+		log.Printf("Warning: not running lead time hook for +%s, some files failed", formatStep(leadtime))
+		return
+	}
+
+	sort.Strings(files)
+	event := LeadtimeEvent{
+		Model:    selectedModel.Name,
+		Run:      t.run.Time,
+		RunTime:  hookRunTime(f.Info),
+		Leadtime: int(leadtime / time.Hour),
+		Step:     formatStep(leadtime),
+		Dir:      filepath.Join(*outputDir, runDirName(t.run.Time)),
+		Files:    files,
+	}
+
+	startHook(func() {
+		err := execHook(leadtimeHookTemplate, event,
+			hookEnvPrefix+"MODEL="+event.Model,
+			hookEnvPrefix+"RUN="+event.Run,
+			hookEnvPrefix+"RUN_TIME="+event.RunTime,
+			hookEnvPrefix+"LEADTIME="+strconv.Itoa(event.Leadtime),
+			hookEnvPrefix+"STEP="+event.Step,
+			hookEnvPrefix+"DIR="+event.Dir,
+			hookEnvPrefix+"FILES="+strings.Join(event.Files, " "),
+		)
+		if err != nil {
+			log.Printf("Warning: lead time hook for +%s failed: %v", formatStep(leadtime), err)
+		}
+	})
+}
+
+// record counts a finished file of a lead time, with its outputs if it has
+// any
+func (t *leadtimeTracker) record(f *PlannedFile, success, hasOutputs bool) {
+	leadtime := f.Info.Leadtime
+	if t.done[f.Param] == nil {
+		t.done[f.Param] = make(map[time.Duration]int)
+	}
+	t.done[f.Param][leadtime]++
+	switch {
+	case !success:
+		t.failed[leadtime] = true
+	case !hasOutputs:
+	case len(f.Outputs) > 0:
+		t.files[leadtime] = append(t.files[leadtime], f.Outputs...)
+	default:
+		t.files[leadtime] = append(t.files[leadtime], f.LocalPath)
+	}
+}
+
+// resumed records a file finished by an interrupted invocation whose
+// download queue is resumed. A lead time it completes does not fire again,
+// as the interrupted invocation may have run the hook already.
+func (t *leadtimeTracker) resumed(f *PlannedFile) {
+	if t == nil || leadtimeHookTemplate == nil || !f.Info.HasLeadtime() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(f, true, true)
+	if t.complete(f.Info.Leadtime) {
+		t.fired[f.Info.Leadtime] = true
+	}
+}
+
+// fileDownloaded runs the -file-hook in the background for a downloaded
+// file, at most -concurrent at a time
+func fileDownloaded(f *PlannedFile) {
+	if fileHookTemplate == nil {
+		return
+	}
+	event := FileEvent{
+		Model:    selectedModel.Name,
+		Run:      hookRun.Time,
+		RunTime:  hookRunTime(f.Info),
+		Param:    f.Param,
+		Leadtime: -1,
+		Path:     f.LocalPath,
+		Files:    f.Outputs,
+	}
+	if f.Info.HasLeadtime() {
+		event.Leadtime = int(f.Info.Leadtime / time.Hour)
+		event.Step = formatStep(f.Info.Leadtime)
+	}
+	if len(event.Files) == 0 {
+		event.Files = []string{f.LocalPath}
+	}
+
+	startHook(func() {
+		err := execHook(fileHookTemplate, event,
+			hookEnvPrefix+"MODEL="+event.Model,
+			hookEnvPrefix+"RUN="+event.Run,
+			hookEnvPrefix+"RUN_TIME="+event.RunTime,
+			hookEnvPrefix+"PARAM="+event.Param,
+			hookEnvPrefix+"LEADTIME="+strconv.Itoa(event.Leadtime),
+			hookEnvPrefix+"STEP="+event.Step,
+			hookEnvPrefix+"PATH="+event.Path,
+			hookEnvPrefix+"FILES="+strings.Join(event.Files, " "),
+		)
+		if err != nil {
+			log.Printf("Warning: file hook for %s failed: %v", f.LocalPath, err)
+		}
+	})
+}
+
+// runFinished runs the -run-hook for the finished run and waits for it,
+// after the hooks still running in the background
+func runFinished(runDir string, complete bool, failed []string) {
+	waitForHooks()
+	if runHookTemplate == nil {
+		return
+	}
+	if failed == nil {
+		failed = []string{}
+	}
+	event := RunEvent{
+		Model:       selectedModel.Name,
+		Run:         hookRun.Time,
+		RunTime:     hookRunTime(index.File{}),
+		Path:        runDir,
+		Complete:    complete,
+		Downloaded:  int(downloadedFiles.Load()),
+		FailedCount: int(failedDownloads.Load()),
+		Failed:      failed,
+	}
+	err := execHook(runHookTemplate, event,
+		hookEnvPrefix+"MODEL="+event.Model,
+		hookEnvPrefix+"RUN="+event.Run,
+		hookEnvPrefix+"RUN_TIME="+event.RunTime,
+		hookEnvPrefix+"PATH="+event.Path,
+		hookEnvPrefix+"COMPLETE="+strconv.FormatBool(event.Complete),
+		hookEnvPrefix+"DOWNLOADED="+strconv.Itoa(event.Downloaded),
+		hookEnvPrefix+"FAILED_COUNT="+strconv.Itoa(event.FailedCount),
+		hookEnvPrefix+"FAILED="+strings.Join(event.Failed, " "),
+	)
+	if err != nil {
+		log.Printf("Warning: run hook failed: %v", err)
+	}
+}
+
+// waitForHooks waits for the hooks running in the background to finish
+func waitForHooks() {
+	runningHooks.Wait()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		ok      bool
+	}{
+		{"", nil, true},
+		{"   ", nil, true},
+		{"post.sh", []string{"post.sh"}, true},
+		{"post.sh 2025031206 12", []string{"post.sh", "2025031206", "12"}, true},
+		{" \tpost.sh  a\n b\r\n", []string{"post.sh", "a", "b"}, true},
+
+		// Quoting
+		{`notify.sh "run 06" 't_2m pmsl'`, []string{"notify.sh", "run 06", "t_2m pmsl"}, true},
+		{`echo "" ''`, []string{"echo", "", ""}, true},
+		{`echo a"b c"d`, []string{"echo", "ab cd"}, true},
+		{`echo "it's" 'say "hi"'`, []string{"echo", "it's", `say "hi"`}, true},
+		{`echo "a b`, nil, false},
+		{`echo 'a b`, nil, false},
+
+		// Escapes
+		{`echo a\ b`, []string{"echo", "a b"}, true},
+		{`echo \"a\"`, []string{"echo", `"a"`}, true},
+		{`echo "a \"b\" \\ c"`, []string{"echo", `a "b" \ c`}, true},
+		{`echo 'a\b'`, []string{"echo", `a\b`}, true},
+		{`echo \'`, []string{"echo", "'"}, true},
+		{`echo \\`, []string{"echo", `\`}, true},
+		{`echo \`, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.command)
+		if (err == nil) != tt.ok {
+			t.Errorf("splitCommand(%q) error = %v, want ok %v", tt.command, err, tt.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
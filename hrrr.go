@@ -0,0 +1,15 @@
+package main
+
+import "regexp"
+
+// hrrrLayout is the NOAA HRRR tree of the AWS open data bucket:
+// hrrr.YYYYMMDD/conus/ directories holding the files of all hourly runs of a
+// day, one uncompressed GRIB file per product and step with an .idx
+// inventory, e.g. "hrrr.t06z.wrfsfcf12.grib2". Products such as wrfsfc or
+// the sub-hourly wrfsubh take the place of DWD parameter directories.
+var hrrrLayout = dayFilesLayout{
+	Day:    regexp.MustCompile(`^hrrr\.(\d{8})$`),
+	File:   regexp.MustCompile(`^hrrr\.t(\d\d)z\.(wrf[a-z]+)f(\d\d)\.grib2$`),
+	Suffix: "conus/",
+	Days:   2,
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// inhibitStartup is how long systemd-inhibit is given to fail before the
+// lock is considered taken
+const inhibitStartup = 200 * time.Millisecond
+
+// inhibitSleep takes a logind inhibitor lock against suspend, shutdown and
+// idle through systemd-inhibit, held until the returned function is called.
+// The lock is held by systemd-inhibit running cat on a pipe from this
+// process, so it is also released if the downloader exits or is killed.
+func inhibitSleep(why string) (func(), error) {
+	path, err := exec.LookPath("systemd-inhibit")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(path, "--what=sleep:shutdown:idle", "--who=icon-downloader",
+		"--why="+why, "--mode=block", "cat")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// systemd-inhibit exits right away if logind cannot be reached
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		stdin.Close()
+		return nil, fmt.Errorf("systemd-inhibit exited: %v", err)
+	case <-time.After(inhibitStartup):
+	}
+
+	return func() {
+		stdin.Close()
+		<-done
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// inhibitSleep is not supported on this platform
+func inhibitSleep(why string) (func(), error) {
+	return nil, errors.New("inhibitor locks are only supported on Linux with systemd")
+}
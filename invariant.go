@@ -0,0 +1,21 @@
+package main
+
+import "regexp"
+
+// invariantReferenceTime matches the reference time in the names of
+// time-invariant fields, e.g. "_2025031206_" in
+// "icon-eu_europe_regular-lat-lon_time-invariant_2025031206_HSURF.grib2"
+var invariantReferenceTime = regexp.MustCompile(`_\d{10}([_.])`)
+
+// invariantDirName is the directory below the output directory that
+// -invariant saves the time-invariant fields in
+const invariantDirName = "invariant"
+
+// runDirName returns the directory below the output directory that the
+// files of a run are saved in
+func runDirName(runTime string) string {
+	if *invariantOnly {
+		return invariantDirName
+	}
+	return runTime
+}
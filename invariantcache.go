@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// invariantLockTimeout is how long to wait for another invocation filling
+// the same cache entry before downloading into the run directory instead
+const invariantLockTimeout = 10 * time.Minute
+
+// invariantCache is a directory of time-invariant fields shared between
+// runs and invocations, keyed by model and grid. Each field is downloaded
+// once and linked into the run directories.
+type invariantCache struct {
+	dir string
+}
+
+// invariants is the cache set with -invariant-cache, or nil
+var invariants *invariantCache
+
+// entryPath returns the cache path of a planned time-invariant file, e.g.
+// "<dir>/icon-eu/europe_regular-lat-lon/hsurf_icon-eu_europe_regular-lat-lon_time-invariant_HSURF.grib2"
+func (c *invariantCache) entryPath(f *PlannedFile) string {
+	name := uncompressedName(f.File)
+	before, _, _ := strings.Cut(name, "_time-invariant_")
+	_, grid, found := strings.Cut(before, "_")
+	if !found {
+		grid = before
+	}
+	name = invariantReferenceTime.ReplaceAllString(name, "$1")
+	return filepath.Join(c.dir, selectedModel.Name, grid, f.Param+"_"+name)
+}
+
+// obtain links a time-invariant file from the cache into its run directory,
+// downloading it into the cache first if needed. Concurrent invocations
+// filling the same entry are serialised with a lock file next to it.
+func (c *invariantCache) obtain(f *PlannedFile) (downloadResult, error) {
+	path := c.entryPath(f)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return downloadResult{}, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	result, ok := c.lookup(path, f)
+	if !ok {
+		lock, err := waitForLockFile(path+".lock", "cache entry "+path, invariantLockTimeout)
+		if err != nil {
+			log.Printf("Warning: %v, downloading %s without the cache", err, f.File)
+			return downloadAndUncompressFile(f.URL, f.LocalPath, *maxRetries)
+		}
+		defer lock.release()
+
+		// Another invocation may have filled the entry while we waited
+		result, ok = c.lookup(path, f)
+		if !ok {
+			result, err = downloadAndUncompressFile(f.URL, path, *maxRetries)
+			if err != nil {
+				return downloadResult{}, err
+			}
+			if err := saveInvariantEntry(path, result); err != nil {
+				return downloadResult{}, fmt.Errorf("failed to record cache entry: %v", err)
+			}
+			if *verbose {
+				log.Printf("Added %s to the invariant cache", filepath.Base(path))
+			}
+			return result, linkOrCopy(path, f.LocalPath)
+		}
+	}
+
+	if *verbose {
+		log.Printf("Linking %s from the invariant cache", filepath.Base(path))
+	}
+	result.Cached = true
+	return result, linkOrCopy(path, f.LocalPath)
+}
+
+// lookup returns the recorded download of a cache entry if the cached file
+// is intact and, with -prefetch, the remote file has not changed
+func (c *invariantCache) lookup(path string, f *PlannedFile) (downloadResult, bool) {
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return downloadResult{}, false
+	}
+	var entry ManifestEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return downloadResult{}, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != entry.Size {
+		return downloadResult{}, false
+	}
+	if f.RemoteSize >= 0 && entry.Source == f.URL && entry.CompressedSize != f.RemoteSize {
+		return downloadResult{}, false
+	}
+	return downloadResult{
+		URL:              entry.Source,
+		Compression:      entry.Compression,
+		CompressedSize:   entry.CompressedSize,
+		CompressedSHA256: entry.CompressedSHA256,
+		Size:             entry.Size,
+		SHA256:           entry.SHA256,
+	}, true
+}
+
+// saveInvariantEntry records the download of a cache entry next to it
+func saveInvariantEntry(path string, result downloadResult) error {
+	data, err := json.MarshalIndent(ManifestEntry{
+		Source:           result.URL,
+		Compression:      result.Compression,
+		CompressedSize:   result.CompressedSize,
+		CompressedSHA256: result.CompressedSHA256,
+		Size:             result.Size,
+		SHA256:           result.SHA256,
+		Downloaded:       time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".json.tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path+".json")
+}
+
+// waitForLockFile creates a lock file, waiting for other holders to release
+// it for up to timeout
+func waitForLockFile(path, what string, timeout time.Duration) (*RunLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := createLockFile(path, what)
+		if err == nil || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// linkOrCopy makes the file at src available at dst, as a hard link where
+// possible and as a copy otherwise, e.g. across file systems
+func linkOrCopy(src, dst string) error {
+	tmp := dst + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobSlotsEnv passes the download slots shared by the jobs of a multi-job
+// configuration to the job processes, as "<read fd>,<write fd>" of a pipe
+// holding one byte per free slot
+const jobSlotsEnv = "_ICOND_JOB_SLOTS"
+
+// jobSlots limits the concurrent downloads of all jobs started by runJobs,
+// like the jobserver of make: a slot is taken by reading a byte from the
+// pipe and returned by writing it back
+type jobSlots struct {
+	r, w  *os.File
+	pause string // File making acquire wait while it exists, or empty
+}
+
+// jobPauseEnv passes the pause file of -watch to the processes of its runs.
+// While ad-hoc jobs are waiting or running, the file exists and the runs
+// start no new downloads, so that the download slots go to the jobs.
+const jobPauseEnv = "_ICOND_JOB_PAUSE"
+
+// jobPausePoll is how often a paused process checks whether the pause file
+// is gone
+var jobPausePoll = time.Second
+
+// sharedSlots are the download slots shared with the other jobs, or nil if
+// this process is not a job of a multi-job configuration
+var sharedSlots *jobSlots
+
+// openJobSlots picks up the shared download slots passed by runJobs
+func openJobSlots() error {
+	spec := os.Getenv(jobSlotsEnv)
+	if spec == "" {
+		return nil
+	}
+	rfd, wfd, ok := strings.Cut(spec, ",")
+	r, err1 := strconv.Atoi(rfd)
+	w, err2 := strconv.Atoi(wfd)
+	if !ok || err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid %s %q", jobSlotsEnv, spec)
+	}
+	sharedSlots = &jobSlots{r: os.NewFile(uintptr(r), "job slots"), w: os.NewFile(uintptr(w), "job slots"), pause: os.Getenv(jobPauseEnv)}
+	return nil
+}
+
+// newJobSlots creates a pool of n download slots
+func newJobSlots(n int) (*jobSlots, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(bytes.Repeat([]byte{'+'}, n)); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	return &jobSlots{r: r, w: w}, nil
+}
+
+// files returns the pipe of the shared download slots, or nils if there
+// are none
+func (s *jobSlots) files() (r, w *os.File) {
+	if s == nil {
+		return nil, nil
+	}
+	return s.r, s.w
+}
+
+// acquire waits for a free shared download slot, and while the pool is
+// paused for it to be resumed
+func (s *jobSlots) acquire() {
+	if s == nil {
+		return
+	}
+	token := make([]byte, 1)
+	for {
+		for s.paused() {
+			time.Sleep(jobPausePoll)
+		}
+		if _, err := s.r.Read(token); err != nil {
+			log.Printf("Warning: shared download slots unavailable: %v", err)
+			return
+		}
+		if !s.paused() {
+			return
+		}
+		// Paused while waiting, the slot is left to the ad-hoc jobs
+		s.release()
+	}
+}
+
+// paused reports whether the pause file of the pool exists
+func (s *jobSlots) paused() bool {
+	if s.pause == "" {
+		return false
+	}
+	_, err := os.Stat(s.pause)
+	return err == nil
+}
+
+// release returns a shared download slot
+func (s *jobSlots) release() {
+	if s == nil {
+		return
+	}
+	if _, err := s.w.Write([]byte{'+'}); err != nil {
+		log.Printf("Warning: failed to return shared download slot: %v", err)
+	}
+}
+
+// logPrefixEnv passes the log prefix of a child process started by
+// runChildren, e.g. "eu: 2025031206: " for a run of the job eu
+const logPrefixEnv = "_ICOND_LOG_PREFIX"
+
+// childProcess is a job or run processed by a process of its own
+type childProcess struct {
+	name string   // Name in the log, e.g. the job name
+	args []string // Arguments added to those of this process, e.g. -job eu
+	env  []string // Environment variables added to those of this process
+}
+
+// runJobs runs the jobs of the configuration file side by side, each in a
+// process of its own started with the same arguments and -job, sharing
+// -concurrent download slots and the files requested by several jobs
+// between them. It returns the highest exit status of the jobs.
+func runJobs(jobs []string) int {
+	shared, err := newSharedDownloads()
+	if err != nil {
+		log.Printf("Warning: the jobs cannot share downloads: %v", err)
+	}
+	defer shared.remove()
+
+	var children []childProcess
+	for _, job := range jobs {
+		children = append(children, childProcess{name: job, args: []string{"-job", job}, env: shared.env()})
+	}
+	return runChildren("job", children)
+}
+
+// runChildren runs child processes of a kind, e.g. "job", side by side,
+// started with the same arguments as this process and those of the child.
+// The children share the download slots of this process if it has any, or
+// -concurrent new ones. It returns the highest exit status of the children.
+func runChildren(kind string, children []childProcess) int {
+	return runChildrenSharing(kind, children, sharedSlots)
+}
+
+// runChildrenSharing runs child processes like runChildren, sharing the
+// given download slots, or -concurrent new ones if slots is nil
+func runChildrenSharing(kind string, children []childProcess, slots *jobSlots) int {
+	title := strings.ToUpper(kind[:1]) + kind[1:]
+	exe, err := os.Executable()
+	if err != nil {
+		log.Printf("Cannot start %ss: %v", kind, err)
+		return 1
+	}
+
+	noun := kind + "s"
+	if len(children) == 1 {
+		noun = kind
+	}
+	r, w := slots.files()
+	if r == nil {
+		if r, w, err = os.Pipe(); err != nil {
+			log.Printf("Cannot start %ss: %v", kind, err)
+			return 1
+		}
+		defer r.Close()
+		defer w.Close()
+		if _, err := w.Write(bytes.Repeat([]byte{'+'}, *maxConcurrent)); err != nil {
+			log.Printf("Cannot start %ss: %v", kind, err)
+			return 1
+		}
+		log.Printf("Running %d %s sharing %d download slots: %s", len(children), noun, *maxConcurrent, childNames(children))
+	} else {
+		log.Printf("Running %d %s sharing the download slots: %s", len(children), noun, childNames(children))
+	}
+
+	// A parameter list read from stdin is passed on to every child
+	var params []byte
+	if *paramList == stdinParams || *paramsFile == stdinParams {
+		if params, err = readStdinParams(); err != nil {
+			log.Printf("Cannot start %ss: reading parameters from stdin: %v", kind, err)
+			return 1
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		status int
+	)
+	for _, child := range children {
+		// Children cannot ask for confirmation, so stdin is not passed on
+		cmd := exec.Command(exe, append(os.Args[1:], child.args...)...)
+		cmd.Stdout = os.Stdout
+		// The log output, which -status-addr scans for errors
+		cmd.Stderr = log.Writer()
+		if params != nil {
+			cmd.Stdin = bytes.NewReader(params)
+		}
+		cmd.Env = append(os.Environ(), logPrefixEnv+"="+log.Prefix()+child.name+": ")
+		cmd.Env = append(cmd.Env, child.env...)
+		if !shareJobSlots(cmd, r, w) {
+			log.Printf("Warning: download slots cannot be shared on this platform, %s %s uses its own -concurrent limit", kind, child.name)
+		}
+		if err := cmd.Start(); err != nil {
+			log.Printf("%s %s failed to start: %v", title, child.name, err)
+			status = max(status, 1)
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, cmd *exec.Cmd) {
+			defer wg.Done()
+			code := 0
+			if err := cmd.Wait(); err != nil {
+				code = 1
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+					code = exitErr.ExitCode()
+				}
+				log.Printf("%s %s failed: %v", title, name, err)
+			} else if *verbose {
+				log.Printf("%s %s completed", title, name)
+			}
+			mu.Lock()
+			status = max(status, code)
+			mu.Unlock()
+		}(child.name, cmd)
+	}
+	wg.Wait()
+	return status
+}
+
+// childNames lists the names of child processes for the log
+func childNames(children []childProcess) string {
+	var names []string
+	for _, child := range children {
+		names = append(names, child.name)
+	}
+	return strings.Join(names, ", ")
+}
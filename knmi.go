@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// knmiDataset is a dataset of the KNMI open data API published as a parameter
+type knmiDataset struct {
+	Name string // Parameter name, e.g. "p1"
+	Path string // Dataset path below the base URL, e.g. "harmonie_arome_cy43_p1/versions/1.0/"
+}
+
+// knmiDatasets are the Harmonie-Arome Cy43 datasets. Each file is a tar
+// archive of one run holding a GRIB file per lead time; runs are taken from
+// the first dataset.
+var knmiDatasets = []knmiDataset{
+	{Name: "p1", Path: "harmonie_arome_cy43_p1/versions/1.0/"},
+}
+
+// knmiFilePattern finds the reference time in archive names, e.g.
+// "HARM43_V1_P1_2025031206.tar"
+var knmiFilePattern = regexp.MustCompile(`_(\d{10})\.tar$`)
+
+// knmiRecentFiles is the number of newest files listed per dataset. Harmonie
+// runs every hour, so this covers each run hour once.
+const knmiRecentFiles = 24
+
+// knmiFile is a file entry of the JSON listing of a dataset
+type knmiFile struct {
+	Filename     string    `json:"filename"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// knmiListing is a page of the JSON listing of a dataset
+type knmiListing struct {
+	Files         []knmiFile `json:"files"`
+	IsTruncated   bool       `json:"isTruncated"`
+	NextPageToken string     `json:"nextPageToken"`
+}
+
+// knmiSource reads the KNMI open data API. Files are listed as JSON pages
+// and fetched through a temporary download URL, both requested with the
+// API key.
+type knmiSource struct {
+	httpFetcher        // Fetches the temporary download URLs
+	baseURL     string // URL of the datasets endpoint
+	apiKey      string
+}
+
+// newKNMISource creates a source for the datasets under baseURL
+func newKNMISource(client *index.Client, baseURL, apiKey string) *knmiSource {
+	return &knmiSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+		apiKey:      apiKey,
+	}
+}
+
+// ListRuns returns the runs of the newest archives of the first dataset
+func (s *knmiSource) ListRuns() ([]ModelRun, error) {
+	files, err := s.listFiles(knmiDatasets[0], knmiRecentFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var runs []ModelRun
+	for _, f := range files {
+		match := knmiFilePattern.FindStringSubmatch(f.Filename)
+		if match == nil {
+			continue
+		}
+		ref, err := time.ParseInLocation("2006010215", match[1], time.UTC)
+		if err != nil || seen[match[1][8:]] {
+			continue
+		}
+		seen[match[1][8:]] = true
+		runs = append(runs, ModelRun{
+			Time:          match[1][8:],
+			URL:           s.baseURL,
+			Timestamp:     f.LastModified.UTC(),
+			ReferenceTime: ref,
+		})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ReferenceTime.Before(runs[j].ReferenceTime) })
+	return runs, nil
+}
+
+// ListParameters returns the datasets
+func (s *knmiSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	var params []Parameter
+	for _, d := range knmiDatasets {
+		params = append(params, Parameter{Name: d.Name, URL: s.baseURL + d.Path, Run: run.Time})
+	}
+	return params, nil
+}
+
+// ListFiles returns the archive of the parameter's run in a dataset
+func (s *knmiSource) ListFiles(param Parameter) ([]index.File, error) {
+	var dataset knmiDataset
+	for _, d := range knmiDatasets {
+		if d.Name == param.Name {
+			dataset = d
+		}
+	}
+	if dataset.Path == "" {
+		return nil, fmt.Errorf("unknown dataset %s", param.Name)
+	}
+
+	listed, err := s.listFiles(dataset, knmiRecentFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []index.File
+	for _, f := range listed {
+		match := knmiFilePattern.FindStringSubmatch(f.Filename)
+		if match == nil || match[1][8:] != param.Run {
+			continue
+		}
+		ref, _ := time.ParseInLocation("2006010215", match[1], time.UTC)
+		files = append(files, index.File{
+			Name:          f.Filename,
+			URL:           param.URL + "files/" + url.PathEscape(f.Filename),
+			ModTime:       f.LastModified.UTC(),
+			Size:          f.Size,
+			ReferenceTime: ref,
+			Leadtime:      -1,
+		})
+	}
+	return files, nil
+}
+
+// listFiles returns up to max of the newest files of a dataset, following
+// the page tokens of the listing
+func (s *knmiSource) listFiles(dataset knmiDataset, max int) ([]knmiFile, error) {
+	var files []knmiFile
+	token := ""
+	for len(files) < max {
+		query := url.Values{
+			"maxKeys": {fmt.Sprint(max - len(files))},
+			"orderBy": {"created"},
+			"sorting": {"desc"},
+		}
+		if token != "" {
+			query.Set("nextPageToken", token)
+		}
+
+		var page knmiListing
+		if err := s.getJSON(s.baseURL+dataset.Path+"files?"+query.Encode(), &page); err != nil {
+			return nil, fmt.Errorf("failed to list dataset %s: %v", dataset.Name, err)
+		}
+		files = append(files, page.Files...)
+		if !page.IsTruncated || page.NextPageToken == "" || len(page.Files) == 0 {
+			break
+		}
+		token = page.NextPageToken
+	}
+	return files, nil
+}
+
+// Fetch asks the API for a temporary download URL of a file and starts
+// downloading from it
+func (s *knmiSource) Fetch(fileURL string) (io.ReadCloser, error) {
+	var download struct {
+		TemporaryDownloadURL string `json:"temporaryDownloadUrl"`
+	}
+	if err := s.getJSON(fileURL+"/url", &download); err != nil {
+		return nil, fmt.Errorf("failed to get download URL: %v", err)
+	}
+	if download.TemporaryDownloadURL == "" {
+		return nil, fmt.Errorf("no download URL for %s", fileURL)
+	}
+	return s.httpFetcher.Fetch(download.TemporaryDownloadURL)
+}
+
+// getJSON requests an API endpoint with the API key and decodes the response
+func (s *knmiSource) getJSON(endpoint string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", s.apiKey)
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// levelTypeFilter holds the level categories selected with -leveltype or
+// -level, e.g. "pressure-level"; all categories are downloaded if empty
+var levelTypeFilter []string
+
+// parseLevelTypes parses a comma-separated list of level categories, given
+// either in short form ("pressure") or as in the file names ("pressure-level")
+func parseLevelTypes(spec string) ([]string, error) {
+	var result []string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		lt, ok := normalizeLevelType(part)
+		if !ok {
+			return nil, fmt.Errorf("invalid level type '%s'. Valid values are: %s", part, strings.Join(levelTypeNames(), ", "))
+		}
+		result = append(result, lt)
+	}
+	return result, nil
+}
+
+// normalizeLevelType returns the file name form of a level category
+func normalizeLevelType(name string) (string, bool) {
+	for _, lt := range index.LevelTypes {
+		if name == lt || name+"-level" == lt {
+			return lt, true
+		}
+	}
+	return "", false
+}
+
+// levelTypeNames returns the short names of all level categories
+func levelTypeNames() []string {
+	var names []string
+	for _, lt := range index.LevelTypes {
+		names = append(names, strings.TrimSuffix(lt, "-level"))
+	}
+	return names
+}
+
+// levelTypeSelected reports whether a level category is selected
+func levelTypeSelected(levelType string) bool {
+	if len(levelTypeFilter) == 0 {
+		return true
+	}
+	for _, lt := range levelTypeFilter {
+		if lt == levelType {
+			return true
+		}
+	}
+	return false
+}
+
+// filterByGrid returns the files on the grid of the selected model, or all
+// files if the model publishes a single grid
+func filterByGrid(files []index.File) []index.File {
+	if selectedModel.Grid == "" {
+		return files
+	}
+
+	var filtered []index.File
+	for _, file := range files {
+		if strings.Contains(file.Name, "_"+selectedModel.Grid+"_") {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered
+}
+
+// filterByLevelType returns the files in the selected level categories, or
+// all files if no level type was selected
+func filterByLevelType(files []index.File) []index.File {
+	if len(levelTypeFilter) == 0 {
+		return files
+	}
+
+	var filtered []index.File
+	for _, file := range files {
+		if levelTypeSelected(file.LevelType) {
+			filtered = append(filtered, file)
+		}
+	}
+
+	if *verbose {
+		log.Printf("Filtered %d files down to %d %s files",
+			len(files), len(filtered), strings.Join(levelTypeFilter, " or "))
+	}
+	return filtered
+}
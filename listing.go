@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// indexClient lists the directory indexes of the open data server or mirror
+var indexClient = &index.Client{}
+
+// getAvailableModelRuns returns a list of available model runs of the source
+func getAvailableModelRuns() ([]ModelRun, error) {
+	log.Println("Listing model runs in:", selectedModel.BaseURL)
+	runs, err := source.ListRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, run := range runs {
+		log.Printf("Found run: %s, reference time: %s, timestamp: %s",
+			run.Time, formatUTC(run.ReferenceTime), formatUTC(run.Timestamp))
+	}
+
+	log.Printf("Found %d model runs", len(runs))
+	return runs, nil
+}
+
+// sortRunsNewestFirst orders runs by reference time, newest first. Both times
+// are in UTC, so the order does not depend on the time zone of the host.
+func sortRunsNewestFirst(runs []ModelRun) {
+	sort.SliceStable(runs, func(i, j int) bool {
+		a, b := runs[i].ReferenceTime, runs[j].ReferenceTime
+		if a.Equal(b) {
+			return runs[i].Timestamp.After(runs[j].Timestamp)
+		}
+		return a.After(b)
+	})
+}
+
+// formatUTC formats a time for logging, or "unknown" for the zero time
+func formatUTC(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// getAvailableParameters returns a list of available parameters for a model run
+func getAvailableParameters(run ModelRun) ([]Parameter, error) {
+	return source.ListParameters(run)
+}
+
+// listingCache holds the last successful file listing of each parameter
+// directory, used when re-listing fails later in the run
+var listingCache = struct {
+	mu       sync.Mutex
+	files    map[Parameter][]index.File
+	disabled bool // Set under memory pressure, see memoryGuard
+}{files: make(map[Parameter][]index.File)}
+
+// disableListingCache drops the cached listings and stops caching new ones
+func disableListingCache() {
+	listingCache.mu.Lock()
+	defer listingCache.mu.Unlock()
+	listingCache.files = make(map[Parameter][]index.File)
+	listingCache.disabled = true
+}
+
+// getGribFiles returns a list of GRIB files for a parameter. Failed listings
+// are retried up to -retries times; if all attempts fail, the last successful
+// listing of the directory is returned instead of an error.
+func getGribFiles(param Parameter) ([]index.File, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= *maxRetries; attempt++ {
+		if attempt > 0 {
+			if *verbose {
+				log.Printf("Retry attempt %d/%d for listing %s", attempt, *maxRetries, param.URL)
+			}
+			time.Sleep(time.Duration(attempt*attempt) * time.Second)
+		}
+
+		files, err := source.ListFiles(param)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		listingCache.mu.Lock()
+		if !listingCache.disabled {
+			listingCache.files[param] = files
+		}
+		listingCache.mu.Unlock()
+		return files, nil
+	}
+
+	listingCache.mu.Lock()
+	files, ok := listingCache.files[param]
+	listingCache.mu.Unlock()
+	if ok {
+		log.Printf("Warning: listing %s failed, using previous listing of %d files: %v", param.URL, len(files), lastErr)
+		return files, nil
+	}
+	return nil, lastErr
+}
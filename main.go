@@ -1,7 +1,9 @@
 package main
 
 import (
-	"compress/bzip2"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,73 +12,511 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime/debug"
-	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"golang.org/x/net/html"
-)
-
-const (
-	baseURL = "https://opendata.dwd.de/weather/nwp/icon-eu/grib/"
-)
-
-// Version info
-var (
-	version = "dev" // This will be overridden by -ldflags during build
+	"icon-grib-downloader/pkg/index"
 )
 
 // Command line flags
 var (
-	modelRun      = flag.String("run", "", "Model run time in format HH (e.g., 00, 06, 12, 18)")
-	paramList     = flag.String("params", "", "Comma-separated list of parameters to download (e.g., t_2m,clct,pmsl)")
-	latest        = flag.Bool("latest", false, "Download the latest available model run")
-	outputDir     = flag.String("outdir", ".", "Directory to save downloaded files")
-	maxConcurrent = flag.Int("concurrent", 5, "Maximum number of concurrent downloads")
-	verbose       = flag.Bool("verbose", false, "Enable verbose output")
-	maxRetries    = flag.Int("retries", 5, "Maximum number of retry attempts for failed downloads")
-	showVersion   = flag.Bool("version", false, "Show version information")
-	levelType     = flag.String("level", "", "Filter by level type: single, pressure, or model (if not specified, all types are downloaded)")
+	modelRun          = flag.String("run", "", "Model run hour HH (e.g., 00, 06, 12, 18) or run time YYYYMMDDHH (e.g., 2025031206), or a comma-separated list of runs downloaded side by side")
+	paramList         = flag.String("params", "", "Comma-separated list of parameters to download, optionally with levels (e.g., t_2m,clct,t@850,500), or - to read them from stdin")
+	latest            = flag.Bool("latest", false, "Download the latest available model run")
+	outputDir         = flag.String("outdir", ".", "Directory to save downloaded files")
+	maxConcurrent     = flag.Int("concurrent", 5, "Maximum number of concurrent downloads")
+	verbose           = flag.Bool("verbose", false, "Enable verbose output")
+	maxRetries        = flag.Int("retries", 5, "Maximum number of retry attempts for failed downloads")
+	showVersion       = flag.Bool("version", false, "Show version information")
+	prefetch          = flag.Bool("prefetch", false, "Run parallel HEAD requests for all planned files before downloading to get exact sizes and modification times")
+	waitFor           = flag.Duration("wait", 0, "Keep polling for scheduled forecast steps that are not yet published for up to this long (e.g. 2h)")
+	waitInterval      = flag.Duration("wait-interval", 2*time.Minute, "Polling interval used with -wait")
+	validTimes        = flag.String("valid-times", "", "Download only files valid at these UTC times, e.g. 2025-03-12T06:00/2025-03-12T18:00 (comma-separated times or ranges)")
+	overwritePolicy   = flag.String("overwrite", defaultOverwritePolicy, "Policy for existing files: skip-if-nonempty, skip-if-same-size, skip-if-checksum-match, always-overwrite or never-overwrite")
+	collisionStrategy = flag.String("collision", collisionError, "Strategy when two files map to the same local name: error, suffix or subdir")
+	usageReport       = flag.Bool("usage-report", false, "Print the downloaded bytes per day and model for the output directory and exit")
+	monthlyCap        = flag.String("monthly-cap", "", "Warn when more than this much data (e.g. 500G) has been downloaded in the current month")
+	deadlineSpec      = flag.String("deadlines", "", "Deadlines relative to the run time per level type or parameter, e.g. single=1h,model=3h,t_2m=45m")
+	probe             = flag.Bool("probe", false, "Check DNS, TLS, listing, a test download, decompression and write permissions, then exit")
+	minFilesSpec      = flag.String("min-files", "", "Minimum number of files per parameter, e.g. auto,t_2m=93 (auto derives it from the model schedule)")
+	stepSpec          = flag.String("steps", "", "Forecast steps to download, e.g. 0-48:3, 0-78,81-120:3 or 0-6:15m (default: all)")
+	maxHour           = flag.Int("maxhour", -1, "Skip files with a forecast step beyond this many hours (default: no limit)")
+	analysisOnly      = flag.Bool("analysis-only", false, "Download only the analysis (step 000) files, same as -steps 0")
+	zeroBytePolicy    = flag.String("zero-byte", zeroByteWait, "Treatment of zero-byte remote files: wait (not yet available), ignore or error")
+	levelTypes        = flag.String("leveltype", "", "Comma-separated level types to download: single, pressure, model, soil, time-invariant (default: all)")
+	planOnly          = flag.Bool("plan", false, "Print which files would be downloaded, replaced or skipped for the run and exit")
+	modelLevelSpec    = flag.String("model-levels", "", "Model levels to download for model-level parameters, e.g. 40-65 (default: all)")
+	soilLevelSpec     = flag.String("soil-levels", "", "Soil depths in cm to download for soil-level parameters such as t_so and w_so, e.g. 0,1,3 (default: all)")
+	gridName          = flag.String("grid", "", "Grid of the files to download for models publishing several, e.g. regular-lat-lon or icosahedral (default: model default)")
+	leadtimeHook      = flag.String("leadtime-hook", "", "Command run when all files of a lead time are done, a Go template, e.g. \"post.sh {{.RunTime}} {{.Leadtime}}\"")
+	fileHook          = flag.String("file-hook", "", "Command run after each downloaded file, a Go template, e.g. \"index.sh {{.Param}} {{.Path}}\"")
+	runHook           = flag.String("run-hook", "", "Command run when the run is finished, a Go template, e.g. \"notify.sh {{.Model}} {{.Run}} {{.FailedCount}}\"")
+	budgetSpec        = flag.String("budgets", "", "Byte budgets per run by level type or parameter, e.g. model=2G,t=500M; the latest lead times are skipped to fit")
+	messagesSpec      = flag.String("messages", "", "Download only the GRIB messages whose .idx inventory line matches this regular expression, e.g. ':(TMP|UGRD|VGRD):850 mb:' (models with .idx files)")
+	apiKey            = flag.String("api-key", os.Getenv("KNMI_API_KEY"), "API key of the KNMI open data API for the harmonie model (default: $KNMI_API_KEY)")
+	inhibit           = flag.Bool("inhibit", false, "Hold a systemd inhibitor lock against suspend and shutdown while downloading (Linux)")
+	maxRSS            = flag.String("max-rss", "", "Reduce parallel downloads, down to one, while the memory use of the process exceeds this size (e.g. 512M)")
+	verifyAgainst     = flag.String("verify-against", "", "Compare the local run directories with a partner archive built by this tool at this URL, spot checking checksums, and exit")
+	verifySamples     = flag.Int("verify-samples", 5, "Number of files per run whose checksums are spot checked with -verify-against")
+	sanitizeScheme    = flag.String("sanitize", sanitizeNone, "Sanitisation of output file names: none, windows, s3 or portable, optionally followed by :escape to percent-encode instead of replacing with _")
+	capFeeds          = flag.String("cap-feeds", "", "Comma-separated DWD CAP warning feeds to mirror into <outdir>/cap, e.g. COMMUNEUNION_DWD_STAT")
+	capURL            = flag.String("cap-url", "https://opendata.dwd.de/weather/alerts/cap/", "Base URL of the CAP warning feeds")
+	capInterval       = flag.Duration("cap-interval", 0, "Keep mirroring the warning feeds at this interval (e.g. 5m); without -latest or -run, only the warnings are mirrored until stopped")
+	invariantCacheDir = flag.String("invariant-cache", "", "Shared directory caching time-invariant fields by model and grid, linked into each run")
+	invariantOnly     = flag.Bool("invariant", false, "Download the time-invariant fields (@invariant set or -params) of the latest or -run run into <outdir>/invariant, keeping existing ones")
+	splitLevels       = flag.Bool("split-levels", false, "Split downloaded GRIB2 files holding several levels into one file per level")
+	configFile        = flag.String("config", "", "YAML file with default values of the options, optionally per model; command line options take precedence")
+	checkConfig       = flag.Bool("check-config", false, "Check every section of the -config file strictly, report all problems found and exit")
+	confirmAbove      = flag.String("confirm-above", defaultConfirmThresholds, "Ask before downloading a plan above these limits, e.g. size=50G,files=10000,duration=3h, or none")
+	estimateRate      = flag.String("estimate-rate", "10M", "Download rate per second assumed to estimate the duration of a plan for -confirm-above")
+	assumeYes         = flag.Bool("yes", false, "Download plans above the -confirm-above limits without asking")
+	profileName       = flag.String("profile", "", "Named profile of the -config file to apply, e.g. surface-fast")
+	catalogURL        = flag.String("catalog-url", defaultCatalogURL, "URL of a signed JSON model catalog replacing the built-in models and schedules if newer; the signature is read from <url>.sig")
+	catalogKey        = flag.String("catalog-key", catalogPublicKey, "Base64 encoded Ed25519 public key verifying the -catalog-url signature")
+	catalogRefresh    = flag.Duration("catalog-refresh", 24*time.Hour, "How long a fetched model catalog is used before fetching it again")
+	dumpCatalog       = flag.Bool("dump-catalog", false, "Print the model catalog in use as JSON and exit")
+	jobName           = flag.String("job", "", "Run only this job of the -config file; without it, all jobs of the file are run side by side")
+	force             = flag.Bool("force", false, "Modify a run marked as completed by its DONE file")
+	excludeParamList  = flag.String("exclude-params", "", "Comma-separated parameters not to download, as names, glob patterns (e.g. *_so), @sets or group:name")
+	paramsFile        = flag.String("params-file", "", "File listing parameters to download, one -params entry per line, # starts a comment; combined with -params")
+	lastRuns          = flag.Int("last-runs", 0, "Download the newest N available runs side by side, sharing the -concurrent download slots")
+	latestComplete    = flag.Bool("latest-complete", false, "Download the newest run whose parameters have the final expected forecast step, skipping runs still being uploaded")
+	fallbackPrevious  = flag.Bool("fallback-previous", false, "With -latest, download the previous run instead if the latest run misses requested parameters or steps and the previous one does not")
+	watchMode         = flag.Bool("watch", false, "Keep running, downloading the newest run (or -last-runs runs) as it appears until stopped")
+	watchInterval     = flag.Duration("watch-interval", 5*time.Minute, "Polling interval used with -watch")
+	watchSchedule     = flag.String("watch-schedule", "", "Cron expressions in UTC, separated by ;, starting the -watch polling windows (e.g. \"30 2,8,14,20 * * *\"); implies -watch")
+	watchWindow       = flag.Duration("watch-window", 3*time.Hour, "Longest polling window started by -watch-schedule")
+	statusAddr        = flag.String("status-addr", "", "Address to serve an HTML status page of the runs on with -watch, e.g. :8080")
+	controlAddr       = flag.String("control-addr", "", "Address to serve the control API for ad-hoc jobs on with -watch, a loopback address such as 127.0.0.1:8081 unless -control-token is set")
+	controlToken      = flag.String("control-token", "", "Bearer token required by the control API of -control-addr")
+	watchBacklog      = flag.String("watch-backlog", backlogParallel, "Download of several runs waiting with -watch -last-runs: parallel, newest-first or skip-superseded")
+	describe          = flag.String("describe", "", "Describe the given comma-separated parameters (or \"all\") and exit")
+	levelType         = flag.String("level", "", "Same as -leveltype (deprecated)")
+	modelName         = flag.String("model", defaultModel, "Model to download: "+strings.Join(modelNames(), ", "))
+	baseURLFlag       = flag.String("base-url", "", "Alternative base URL of the model run directories, e.g. an HTTPS mirror or s3://bucket/prefix/; several comma-separated HTTP(S) mirrors are failed over by health")
+	s3Endpoint        = flag.String("s3-endpoint", "", "Endpoint for s3:// base URLs using path-style access (default: AWS virtual-hosted buckets)")
+	indexFormat       = flag.String("index-format", "auto", "Format of the directory listings: auto, html, json or xml")
 )
 
 type ModelRun struct {
-	Time      string    // The run hour (e.g., "00", "12")
-	URL       string    // The URL to the run directory
-	Timestamp time.Time // The actual timestamp of the run
+	Time          string    // The run hour (e.g., "00", "12")
+	URL           string    // The URL to the run directory
+	Timestamp     time.Time // Modification time of the run directory in UTC
+	ReferenceTime time.Time // Nominal run time in UTC, zero if unknown
 }
 
 type Parameter struct {
 	Name string
 	URL  string
+	Run  string // Run hour, set by sources that keep several runs in one directory
 }
 
 func main() {
 	flag.Parse()
 
+	if err := loadEnvironment(); err != nil {
+		log.Fatalf("Invalid environment variable %v", err)
+	}
+	var jobs []string
+	if *configFile != "" {
+		var err error
+		if jobs, err = loadConfig(*configFile, *profileName, *jobName); err != nil {
+			log.Fatalf("Invalid -config %s: %v", *configFile, err)
+		}
+	} else if *profileName != "" || *jobName != "" || *checkConfig {
+		log.Fatal("-profile, -job and -check-config require -config")
+	}
+
+	// Run the jobs of a multi-job configuration in processes of their own
+	if len(jobs) > 0 && *jobName == "" && !*showVersion && !*dumpCatalog && *describe == "" && !*checkConfig {
+		os.Exit(runJobs(jobs))
+	}
+	if *jobName != "" {
+		log.SetPrefix(*jobName + ": ")
+		log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	}
+	if prefix := os.Getenv(logPrefixEnv); prefix != "" {
+		log.SetPrefix(prefix)
+		log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	}
+	if err := openJobSlots(); err != nil {
+		log.Fatal(err)
+	}
+	openSharedDownloads()
+
 	// Handle version flag
 	if *showVersion {
-		// Try to get build info if available
-		if info, ok := debug.ReadBuildInfo(); ok && version == "dev" {
-			version = info.Main.Version
+		printVersion()
+		os.Exit(0)
+	}
+
+	// Refresh the models and schedules from the remote catalog
+	if *catalogURL != "" {
+		updateModelCatalog(*catalogURL, *catalogKey, *catalogRefresh)
+	}
+	if *checkConfig {
+		os.Exit(checkConfigFile(*configFile))
+	}
+	if *dumpCatalog {
+		if err := printModelCatalog(); err != nil {
+			log.Fatal(err)
 		}
-		fmt.Printf("ICON GRIB Downloader version %s\n", version)
+		os.Exit(0)
+	}
+
+	// Handle describe flag
+	if *describe != "" {
+		describeParameters(*describe)
 		os.Exit(0)
 	}
 
 	log.Println("Starting ICON GRIB downloader")
 
-	// Validate level type if specified
+	// Select the model to download
+	model, ok := lookupModel(*modelName)
+	if !ok {
+		log.Fatalf("Unknown model '%s'. Valid values are: %s", *modelName, strings.Join(modelNames(), ", "))
+	}
+	selectedModel = model
+	log.Printf("Model: %s (%s)", selectedModel.Name, selectedModel.Description)
+	if selectedModel.Ensemble {
+		log.Printf("Each file contains all ensemble members as separate GRIB messages")
+	}
+
+	if *gridName != "" {
+		selectedModel.Grid = *gridName
+	}
+
+	indexClient.S3Endpoint = *s3Endpoint
+	format, err := index.ParseFormat(*indexFormat)
+	if err != nil {
+		log.Fatalf("Invalid -index-format: %v", err)
+	}
+	indexClient.Format = format
+
+	// Use a mirror instead of opendata.dwd.de if requested, failing over
+	// between several mirrors if more than one is given
+	if *baseURLFlag != "" {
+		base, err := parseBaseURLs(*baseURLFlag)
+		if err != nil {
+			log.Fatalf("Invalid -base-url: %v", err)
+		}
+		selectedModel.BaseURL = base
+		if mirrors != nil {
+			indexClient.HTTPClient = &http.Client{Transport: httpTransport()}
+			log.Printf("Failing over between %d mirrors: %s", len(mirrors.bases), strings.Join(mirrors.bases, ", "))
+		}
+	}
+	if source, err = newSource(selectedModel, indexClient); err != nil {
+		log.Fatalf("Invalid -model: %v", err)
+	}
+
+	// Run the connectivity self-test if requested
+	if *probe {
+		if failed := runProbe(); failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// -last-runs selects the newest runs like -latest
+	if *lastRuns < 0 {
+		log.Fatal("Invalid -last-runs: must not be negative")
+	}
+	if *lastRuns > 0 {
+		if *modelRun != "" {
+			log.Fatal("-last-runs and -run cannot be combined")
+		}
+		if *invariantOnly {
+			log.Fatal("-last-runs and -invariant cannot be combined")
+		}
+		*latest = true
+	}
+
+	// -latest-complete selects the newest complete run instead of the newest
+	if *latestComplete {
+		if *modelRun != "" {
+			log.Fatal("-latest-complete and -run cannot be combined")
+		}
+		if *lastRuns > 1 {
+			log.Fatal("-latest-complete and -last-runs cannot be combined")
+		}
+		*latest = true
+	}
+	if *fallbackPrevious && (*latestComplete || *lastRuns > 1) {
+		log.Fatal("-fallback-previous cannot be combined with -latest-complete or -last-runs")
+	}
+
+	// -watch keeps downloading new runs until stopped
+	if *watchSchedule != "" {
+		schedules, err := parseCronSchedules(*watchSchedule)
+		if err != nil {
+			log.Fatalf("Invalid -watch-schedule: %v", err)
+		}
+		if *watchWindow <= 0 {
+			log.Fatal("Invalid -watch-window: must be positive")
+		}
+		watchSchedules = schedules
+		*watchMode = true
+	}
+	if *watchMode {
+		if *modelRun != "" || *latestComplete || *fallbackPrevious || *invariantOnly || *planOnly {
+			log.Fatal("-watch cannot be combined with -run, -latest-complete, -fallback-previous, -invariant or -plan")
+		}
+		if *watchInterval <= 0 {
+			log.Fatal("Invalid -watch-interval: must be positive")
+		}
+		if err := validateWatchBacklog(*watchBacklog); err != nil {
+			log.Fatalf("Invalid -watch-backlog: %v", err)
+		}
+		*latest = true
+	} else if *statusAddr != "" || *controlAddr != "" {
+		log.Fatal("-status-addr and -control-addr require -watch or -watch-schedule")
+	}
+	if err := validateControlAddr(*controlAddr, *controlToken); err != nil {
+		log.Fatalf("Invalid -control-addr: %v", err)
+	}
+
+	// -level is the older name of -leveltype
+	levelSpec := *levelTypes
 	if *levelType != "" {
-		if *levelType != "single" && *levelType != "pressure" && *levelType != "model" {
-			log.Printf("Warning: Invalid level type '%s'. Valid values are: single, pressure, model", *levelType)
-			log.Printf("Downloading all level types instead")
-			*levelType = ""
-		} else {
-			log.Printf("Filtering files by level type: %s-level", *levelType)
+		if levelSpec != "" {
+			log.Fatal("-level and -leveltype cannot be combined")
+		}
+		levelSpec = *levelType
+	}
+
+	// -invariant fetches the time-invariant fields into a directory of their own
+	if *invariantOnly {
+		if !selectedModel.LevelTypes {
+			log.Fatalf("Invalid -invariant: model %s publishes no time-invariant fields", selectedModel.Name)
+		}
+		if levelSpec != "" && levelSpec != "time-invariant" {
+			log.Fatal("-invariant cannot be combined with -leveltype")
+		}
+		levelSpec = "time-invariant"
+		if !*latest && *modelRun == "" {
+			*latest = true
+		}
+	}
+
+	// Level types are only encoded in the file names of atmospheric models
+	if levelSpec != "" && !selectedModel.LevelTypes {
+		log.Printf("Warning: Model %s has no level types, ignoring level type %s", selectedModel.Name, levelSpec)
+		levelSpec = ""
+	}
+
+	if levelSpec != "" {
+		parsed, err := parseLevelTypes(levelSpec)
+		if err != nil {
+			log.Fatalf("Invalid -leveltype: %v", err)
+		}
+		levelTypeFilter = parsed
+		log.Printf("Filtering files by level type: %s", strings.Join(levelTypeFilter, ", "))
+	}
+
+	if err := validateOverwritePolicy(*overwritePolicy); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateCollisionStrategy(*collisionStrategy); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSanitizeScheme(*sanitizeScheme); err != nil {
+		log.Fatalf("Invalid -sanitize: %v", err)
+	}
+
+	if err := validateZeroBytePolicy(*zeroBytePolicy); err != nil {
+		log.Fatal(err)
+	}
+
+	// Parse download deadlines if specified
+	if *deadlineSpec != "" {
+		parsed, err := parseDeadlines(*deadlineSpec)
+		if err != nil {
+			log.Fatalf("Invalid -deadlines: %v", err)
+		}
+		deadlines = parsed
+	}
+
+	// Lower the parallelism under memory pressure if requested
+	memory.workers = *maxConcurrent
+	if *maxRSS != "" {
+		limit, err := parseByteSize(*maxRSS)
+		if err != nil || limit == 0 {
+			log.Fatalf("Invalid -max-rss: %q, expected a size such as 512M", *maxRSS)
+		}
+		memory.limit = limit
+	}
+
+	confirmLimits, err = parseConfirmThresholds(*confirmAbove)
+	if err != nil {
+		log.Fatalf("Invalid -confirm-above: %v", err)
+	}
+	if _, err := parseByteSize(*estimateRate); err != nil {
+		log.Fatalf("Invalid -estimate-rate: %v", err)
+	}
+	if *monthlyCap != "" {
+		if monthlyCapBytes, err = parseByteSize(*monthlyCap); err != nil {
+			log.Fatalf("Invalid -monthly-cap: %v", err)
+		}
+	}
+
+	// Parse byte budgets if specified
+	if *budgetSpec != "" {
+		parsed, err := parseBudgets(*budgetSpec)
+		if err != nil {
+			log.Fatalf("Invalid -budgets: %v", err)
+		}
+		budgets = parsed
+	}
+
+	// Compile the GRIB message filter if specified
+	if *messagesSpec != "" {
+		if !selectedModel.Inventories {
+			log.Fatalf("Invalid -messages: model %s does not publish .idx inventories", selectedModel.Name)
+		}
+		re, err := regexp.Compile(*messagesSpec)
+		if err != nil {
+			log.Fatalf("Invalid -messages: %v", err)
+		}
+		messageFilter = re
+	}
+
+	// Parse minimum file counts if specified
+	if *minFilesSpec != "" {
+		parsed, err := parseMinFiles(*minFilesSpec)
+		if err != nil {
+			log.Fatalf("Invalid -min-files: %v", err)
+		}
+		minFiles = parsed
+	}
+
+	// Parse the parameter list with optional levels, e.g. t@850,500
+	paramSpec := *paramList
+	if paramSpec == stdinParams {
+		if *paramsFile == stdinParams {
+			log.Fatal("-params and -params-file cannot both read stdin")
+		}
+		listed, err := readParamsFile(stdinParams)
+		if err != nil {
+			log.Fatalf("Invalid -params: %v", err)
+		}
+		paramSpec = listed
+	}
+	if *paramsFile != "" {
+		listed, err := readParamsFile(*paramsFile)
+		if err != nil {
+			log.Fatalf("Invalid -params-file: %v", err)
+		}
+		paramSpec = strings.TrimSpace(paramSpec + " " + listed)
+	}
+	var requestedParams []string
+	if paramSpec != "" {
+		names, levels, err := parseParamList(paramSpec)
+		if err != nil {
+			log.Fatalf("Invalid -params: %v", err)
+		}
+		requestedParams, paramLevels = names, levels
+	}
+	if *excludeParamList != "" {
+		patterns, err := parseExcludeParams(*excludeParamList)
+		if err != nil {
+			log.Fatalf("Invalid -exclude-params: %v", err)
 		}
+		excludedParams = patterns
+	}
+	if *invariantOnly && len(requestedParams) == 0 {
+		set, err := expandParamSet("@invariant")
+		if err != nil {
+			log.Fatalf("Invalid -invariant: %v", err)
+		}
+		requestedParams = set
+	}
+
+	// Parse model levels and soil depths if specified
+	if *modelLevelSpec != "" {
+		levels, err := parseLevelRanges(*modelLevelSpec)
+		if err != nil {
+			log.Fatalf("Invalid -model-levels: %v", err)
+		}
+		levelSelections["model-level"] = levels
+	}
+	if *soilLevelSpec != "" {
+		levels, err := parseLevelRanges(*soilLevelSpec)
+		if err != nil {
+			log.Fatalf("Invalid -soil-levels: %v", err)
+		}
+		levelSelections["soil-level"] = levels
+	}
+
+	// Parse forecast steps if specified
+	if *stepSpec != "" {
+		ranges, err := parseStepRanges(*stepSpec)
+		if err != nil {
+			log.Fatalf("Invalid -steps: %v", err)
+		}
+		stepRanges = ranges
+	}
+
+	// Analysis-only mode is a shorthand for step 0
+	if *analysisOnly {
+		if *stepSpec != "" {
+			log.Fatal("-analysis-only cannot be combined with -steps")
+		}
+		stepRanges = []StepRange{{From: 0, To: 0, Stride: time.Hour}}
+	}
+
+	// Parse the lead time hook if specified
+	if *leadtimeHook != "" {
+		tmpl, err := parseLeadtimeHook(*leadtimeHook)
+		if err != nil {
+			log.Fatalf("Invalid -leadtime-hook: %v", err)
+		}
+		leadtimeHookTemplate = tmpl
+	}
+	if *fileHook != "" {
+		tmpl, err := parseFileHook(*fileHook)
+		if err != nil {
+			log.Fatalf("Invalid -file-hook: %v", err)
+		}
+		fileHookTemplate = tmpl
+	}
+	if *runHook != "" {
+		tmpl, err := parseRunHook(*runHook)
+		if err != nil {
+			log.Fatalf("Invalid -run-hook: %v", err)
+		}
+		runHookTemplate = tmpl
+	}
+	hookSlots = make(chan struct{}, *maxConcurrent)
+
+	// Parse validity times if specified
+	if *validTimes != "" {
+		ranges, err := parseValidTimes(*validTimes)
+		if err != nil {
+			log.Fatalf("Invalid -valid-times: %v", err)
+		}
+		validTimeRanges = ranges
+	}
+
+	// Parse the warning feeds to mirror if specified
+	var capFeedList []string
+	if *capFeeds != "" {
+		feeds, err := validateCAPFeeds(*capFeeds)
+		if err != nil {
+			log.Fatalf("Invalid -cap-feeds: %v", err)
+		}
+		capFeedList = feeds
+	}
+
+	// Compare against a partner archive without downloading anything
+	if *verifyAgainst != "" {
+		if problems := verifyAgainstPartner(*verifyAgainst, *verifySamples); problems > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
 	// Create output directory if it doesn't exist
@@ -84,6 +524,46 @@ func main() {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
 
+	// Bandwidth accounting is kept per output directory
+	usage, err := loadUsage(*outputDir)
+	if err != nil {
+		log.Printf("Warning: bandwidth accounting disabled: %v", err)
+	}
+	bandwidthUsage = usage
+	mirrors.loadHealth()
+
+	if *usageReport {
+		if bandwidthUsage == nil {
+			os.Exit(1)
+		}
+		printUsageReport(bandwidthUsage)
+		os.Exit(0)
+	}
+
+	// Share time-invariant fields between runs and invocations
+	if *invariantCacheDir != "" {
+		invariants = &invariantCache{dir: *invariantCacheDir}
+	}
+
+	// Mirror the warning feeds, alone or next to the model download
+	if len(capFeedList) > 0 && !*planOnly {
+		failed := mirrorCAPFeeds(*capURL, capFeedList)
+		if !*latest && *modelRun == "" {
+			if *capInterval <= 0 {
+				if failed > 0 {
+					os.Exit(1)
+				}
+				return
+			}
+			mirrorCAPFeedsEvery(*capURL, capFeedList, *capInterval, nil)
+		}
+		if *capInterval > 0 {
+			stopCAP := make(chan struct{})
+			defer close(stopCAP)
+			go mirrorCAPFeedsEvery(*capURL, capFeedList, *capInterval, stopCAP)
+		}
+	}
+
 	// Validate command line parameters
 	if *latest && *modelRun != "" {
 		log.Fatal("Cannot specify both -latest and -run flags")
@@ -92,8 +572,18 @@ func main() {
 	if !*latest && *modelRun == "" {
 		log.Fatal("Either -latest or -run must be specified")
 	}
+	var runSpecs []runSelection
+	if *modelRun != "" {
+		if runSpecs, err = parseRunSelections(*modelRun); err != nil {
+			log.Fatalf("Invalid -run: %v", err)
+		}
+	}
 
-	log.Println("Fetching available model runs from:", baseURL)
+	if *watchMode {
+		os.Exit(watchRuns())
+	}
+
+	log.Println("Fetching available model runs from:", selectedModel.BaseURL)
 
 	// Get available model runs
 	availableRuns, err := getAvailableModelRuns()
@@ -105,32 +595,58 @@ func main() {
 		log.Fatal("No model runs found")
 	}
 
-	// Sort runs by actual timestamp (newest first)
-	sort.Slice(availableRuns, func(i, j int) bool {
-		return availableRuns[i].Timestamp.After(availableRuns[j].Timestamp)
-	})
+	sortRunsNewestFirst(availableRuns)
+
+	// Download several runs in processes of their own
+	if *lastRuns > 1 {
+		os.Exit(runChildren("run", runProcesses(newestRuns(availableRuns, *lastRuns))))
+	}
+	if len(runSpecs) > 1 {
+		runs, err := selectedRuns(availableRuns, runSpecs, *modelRun)
+		if err != nil {
+			log.Fatalf("Invalid -run: %v", err)
+		}
+		os.Exit(runChildren("run", runProcesses(runs)))
+	}
 
 	// Determine which run to download
 	var selectedRun ModelRun
-	if *latest {
+	if *latestComplete {
+		if selectedRun, err = latestCompleteRun(availableRuns, requestedParams); err != nil {
+			log.Fatalf("No complete model run: %v", err)
+		}
+		log.Printf("Latest complete model run: %s (reference time: %s, timestamp: %s)", selectedRun.Time,
+			formatUTC(selectedRun.ReferenceTime), formatUTC(selectedRun.Timestamp))
+	} else if *latest {
 		selectedRun = availableRuns[0]
-		log.Printf("Latest model run: %s (timestamp: %s)", selectedRun.Time, selectedRun.Timestamp.Format("2006-01-02 15:04:05"))
+		which := "Latest"
+		if *fallbackPrevious {
+			if selectedRun, err = previousRunIfIncomplete(availableRuns, requestedParams); err != nil {
+				log.Fatalf("Failed to check the latest run: %v", err)
+			}
+			if selectedRun.URL != availableRuns[0].URL || selectedRun.Time != availableRuns[0].Time {
+				which = "Previous"
+			}
+		}
+		log.Printf("%s model run: %s (reference time: %s, timestamp: %s)", which, selectedRun.Time,
+			formatUTC(selectedRun.ReferenceTime), formatUTC(selectedRun.Timestamp))
 	} else {
 		found := false
+		runSpec := runSpecs[0]
 		for _, run := range availableRuns {
-			if run.Time == *modelRun {
+			if runSpec.matches(run) {
 				selectedRun = run
 				found = true
 				break
 			}
 		}
 		if !found {
-			log.Fatalf("Model run %s not found. Available runs: %v", *modelRun, getRunTimes(availableRuns))
+			log.Fatalf("Model run %s not found. Available runs: %v", *modelRun, runSpec.describeRuns(availableRuns))
 		}
 	}
 
 	// Get available parameters for the selected run
-	availableParams, err := getAvailableParameters(selectedRun.URL)
+	availableParams, err := getAvailableParameters(selectedRun)
 	if err != nil {
 		log.Fatalf("Failed to get available parameters: %v", err)
 	}
@@ -139,283 +655,231 @@ func main() {
 		log.Fatal("No parameters found for the selected model run")
 	}
 
+	catalog = newRunCatalog(selectedRun, availableParams)
+	runExpiry = &upstreamExpiry{run: selectedRun}
+
 	// Determine which parameters to download
 	var paramsToDownload []Parameter
-	if *paramList == "" {
+	if len(requestedParams) == 0 {
 		// Download all parameters if none specified
 		paramsToDownload = availableParams
 		log.Printf("Downloading all %d parameters", len(paramsToDownload))
 	} else {
-		requestedParams := strings.Split(*paramList, ",")
-		for _, requested := range requestedParams {
-			found := false
-			for _, available := range availableParams {
-				if available.Name == requested {
-					paramsToDownload = append(paramsToDownload, available)
-					found = true
-					break
-				}
-			}
-			if !found {
-				log.Printf("Warning: Parameter %s not found and will be skipped", requested)
+		paramsToDownload = selectParams(requestedParams, availableParams)
+	}
+
+	if len(excludedParams) > 0 {
+		var kept []Parameter
+		var excluded []string
+		for _, p := range paramsToDownload {
+			if isExcludedParam(p.Name) {
+				excluded = append(excluded, p.Name)
+			} else {
+				kept = append(kept, p)
 			}
 		}
+		if len(excluded) > 0 {
+			log.Printf("Excluding %d parameters: %s", len(excluded), strings.Join(excluded, ", "))
+		}
+		paramsToDownload = kept
 	}
 
 	if len(paramsToDownload) == 0 {
 		log.Fatal("No valid parameters to download")
 	}
 
-	// Download GRIB files for each parameter
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, *maxConcurrent)
-
-	for _, param := range paramsToDownload {
-		wg.Add(1)
-		go func(param Parameter) {
-			defer wg.Done()
-			semaphore <- struct{}{}        // Acquire semaphore
-			defer func() { <-semaphore }() // Release semaphore
-
-			if err := downloadGribFiles(param, selectedRun.Time); err != nil {
-				log.Printf("Error downloading parameter %s: %v", param.Name, err)
-			}
-		}(param)
+	// A completed run is left alone unless -force is given or more is asked for
+	runDir := filepath.Join(*outputDir, runDirName(selectedRun.Time))
+	request := newRunRequest(paramsToDownload, selectedRun.Time)
+	if !*invariantOnly && checkDoneMarker(runDir, selectedRun, request) {
+		return
 	}
 
-	wg.Wait()
-	log.Println("Download completed")
-}
-
-// getAvailableModelRuns returns a list of available model runs
-func getAvailableModelRuns() ([]ModelRun, error) {
-	var runs []ModelRun
-
-	log.Println("Making HTTP request to:", baseURL)
-	resp, err := http.Get(baseURL)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	log.Printf("Response status: %s", resp.Status)
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get model runs list, status: %s", resp.Status)
+	// Lock the run directory so that other invocations can work on other runs.
+	// A dry run only reads the local state and needs no lock.
+	var runLock *RunLock
+	if !*planOnly {
+		runLock, err = acquireRunLock(runDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer runLock.release()
+		if err := removeDoneMarker(runDir); err != nil {
+			runLock.release()
+			log.Fatalf("Cannot remove %s: %v", doneFileName, err)
+		}
 	}
 
-	// Read the HTML content
-	htmlBytes, err := io.ReadAll(resp.Body)
+	runManifest, err = loadManifest(runDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read HTML content: %v", err)
+		log.Printf("Warning: ignoring unreadable manifest: %v", err)
+	} else if runManifest.Sanitize != "" && runManifest.Sanitize != *sanitizeScheme {
+		log.Printf("Warning: files of run %s were saved with -sanitize %s, existing files may be downloaded again under other names",
+			selectedRun.Time, runManifest.Sanitize)
 	}
-	htmlContent := string(htmlBytes)
-
-	log.Println("Extracting model run directories and timestamps")
-
-	// Regular expression to match run directories and their timestamps
-	// Matches patterns like <a href="00/">00/</a>                      12-Mar-2025 02:39    -
-	runPattern := regexp.MustCompile(`<a href="(\d\d)/.*?(\d\d-\w+-\d\d\d\d \d\d:\d\d)`)
-	matches := runPattern.FindAllStringSubmatch(htmlContent, -1)
-
-	for _, match := range matches {
-		if len(match) < 3 {
-			continue
-		}
-
-		runHour := match[1]
-		timestampStr := match[2]
-
-		log.Printf("Found run: %s, timestamp: %s", runHour, timestampStr)
-
-		// Parse the timestamp string
-		timestamp, err := time.Parse("02-Jan-2006 15:04", timestampStr)
-		if err != nil {
-			log.Printf("Warning: couldn't parse timestamp '%s': %v", timestampStr, err)
-			continue
-		}
 
-		runs = append(runs, ModelRun{
-			Time:      runHour,
-			URL:       baseURL + runHour + "/",
-			Timestamp: timestamp,
-		})
+	// List the GRIB files of each parameter and plan the downloads, unless
+	// an interrupted invocation left its download queue behind
+	hookRun = selectedRun
+	leadtimes = newLeadtimeTracker(selectedRun, paramsToDownload)
+	var plan []*PlannedFile
+	var finished map[string]*queueRecord
+	if !*planOnly {
+		plan, finished = resumeQueue(runDir, selectedRun, request)
 	}
-
-	log.Printf("Found %d model runs", len(runs))
-	return runs, nil
-}
-
-// getAvailableParameters returns a list of available parameters for a model run
-func getAvailableParameters(runURL string) ([]Parameter, error) {
-	var params []Parameter
-
-	resp, err := http.Get(runURL)
-	if err != nil {
-		return nil, err
+	resumed := plan != nil
+	if resumed {
+		replayListing(plan)
+	} else {
+		plan = planDownloads(paramsToDownload, selectedRun.Time)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get parameters list, status: %s", resp.Status)
+	localPaths := make(map[string]string)
+	if err := resolveCollisions(plan, localPaths); err != nil {
+		runLock.release()
+		log.Fatal(err)
 	}
 
-	doc, err := html.Parse(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "a" {
-			for _, a := range n.Attr {
-				if a.Key == "href" && len(a.Val) > 0 && a.Val != "../" {
-					// Format is typically like "parameter_name/"
-					if a.Val[len(a.Val)-1] == '/' {
-						paramName := a.Val[:len(a.Val)-1] // Remove trailing slash
-						params = append(params, Parameter{
-							Name: paramName,
-							URL:  runURL + a.Val,
-						})
-					}
-				}
+	// Compare the published files with the publication schedule
+	completeness := planCompleteness(plan, selectedRun.Time)
+	reportListedCompleteness(completeness)
+
+	// Collect exact sizes and modification times before downloading. A
+	// resumed queue has them already.
+	if *prefetch && !resumed {
+		prefetchPlan(plan)
+		if err := checkDiskSpace(plan); err != nil {
+			if !*planOnly {
+				runLock.release()
+				log.Fatal(err)
 			}
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+			log.Printf("Warning: %v", err)
 		}
 	}
-	f(doc)
-
-	return params, nil
-}
-
-// getGribFiles returns a list of GRIB files for a parameter
-func getGribFiles(paramURL string) ([]string, error) {
-	var files []string
-	var filteredFiles []string
 
-	resp, err := http.Get(paramURL)
-	if err != nil {
-		return nil, err
+	// Trim the plan to the byte budgets, using the prefetched sizes if available
+	if !resumed {
+		plan = applyBudgets(plan)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get GRIB files list, status: %s", resp.Status)
+	if *planOnly {
+		printPlan(plan)
+		return
 	}
 
-	doc, err := html.Parse(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// Find all .grib2.bz2 files first
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "a" {
-			for _, a := range n.Attr {
-				if a.Key == "href" && strings.HasSuffix(a.Val, ".grib2.bz2") {
-					files = append(files, a.Val)
-				}
-			}
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+	// Guard against accidentally starting a huge download
+	if !resumed {
+		if err := confirmPlan(plan); err != nil {
+			runLock.release()
+			log.Fatal(err)
 		}
 	}
-	f(doc)
-
-	// Apply level type filtering if specified
-	if *levelType != "" {
-		for _, file := range files {
-			levelString := ""
-			switch *levelType {
-			case "single":
-				levelString = "single-level"
-			case "pressure":
-				levelString = "pressure-level"
-			case "model":
-				levelString = "model-level"
-			}
 
-			if strings.Contains(file, levelString) {
-				filteredFiles = append(filteredFiles, file)
-			}
-		}
-
-		if *verbose {
-			log.Printf("Filtered %d files down to %d %s-level files",
-				len(files), len(filteredFiles), *levelType)
+	// Keep a workstation from suspending in the middle of the downloads
+	releaseInhibitor := func() {}
+	if *inhibit {
+		release, err := inhibitSleep(fmt.Sprintf("Downloading %s run %s", selectedModel.Name, selectedRun.Time))
+		if err != nil {
+			log.Printf("Warning: cannot inhibit suspend: %v", err)
+		} else {
+			releaseInhibitor = release
 		}
-
-		return filteredFiles, nil
 	}
 
-	return files, nil
-}
-
-// downloadGribFiles downloads all GRIB files for a parameter
-func downloadGribFiles(param Parameter, runTime string) error {
-	if *verbose {
-		log.Printf("Downloading parameter: %s", param.Name)
+	// Keep the queue until the downloads are done, so that an interrupted
+	// invocation can be resumed
+	remaining := plan
+	if resumed {
+		remaining = resumeFinished(plan, finished)
+	} else {
+		saveQueue(runDir, selectedRun, request, plan)
 	}
-
-	files, err := getGribFiles(param.URL)
-	if err != nil {
-		return err
+	downloadPlan(remaining)
+	removeQueue(runDir)
+	exitIfExpired(plan, runLock)
+
+	// Compare the listings against the publication schedule of the model
+	var incomplete []Parameter
+	if *waitFor > 0 {
+		incomplete = waitForScheduledSteps(paramsToDownload, selectedRun.Time, plan, localPaths)
+	} else {
+		incomplete = incompleteParameters(paramsToDownload, selectedRun.Time)
 	}
+	releaseInhibitor()
+	exitIfExpired(plan, runLock)
 
-	if len(files) == 0 {
-		if *levelType != "" {
-			return fmt.Errorf("no %s-level GRIB files found for parameter %s", *levelType, param.Name)
-		}
-		return fmt.Errorf("no GRIB files found for parameter %s", param.Name)
+	incompleteFiles := reportObtainedCompleteness(completeness)
+	runManifest.setIncomplete(incompleteFiles)
+	if err := runManifest.save(); err != nil {
+		log.Printf("Warning: failed to save manifest: %v", err)
 	}
 
-	// Create run directory (one directory per model run)
-	runDir := filepath.Join(*outputDir, runTime)
-	if err := os.MkdirAll(runDir, 0755); err != nil {
-		return fmt.Errorf("failed to create run directory: %v", err)
+	// A resumed queue does not list the products
+	if !resumed {
+		reportProductChanges(catalog)
 	}
 
-	// Download each GRIB file
-	for _, file := range files {
-		fileURL := param.URL + file
-
-		// Create a filename with parameter name as prefix to avoid conflicts
-		// e.g., "t_2m_icon-eu_europe_regular-lat-lon_single-level_2023030612_000.grib2"
-		outputFilename := fmt.Sprintf("%s_%s", param.Name, file)
-		if strings.HasSuffix(outputFilename, ".bz2") {
-			outputFilename = outputFilename[:len(outputFilename)-4] // Remove .bz2 extension
-		}
-
-		localPath := filepath.Join(runDir, outputFilename)
-
-		// Skip if file already exists and has non-zero size
-		if fileInfo, err := os.Stat(localPath); err == nil && fileInfo.Size() > 0 {
-			if *verbose {
-				log.Printf("Skipping existing file: %s", localPath)
-			}
-			continue
-		}
-
-		// Download and uncompress file with retries
-		if err := downloadAndUncompressFile(fileURL, localPath, *maxRetries); err != nil {
-			log.Printf("Error downloading %s: %v", fileURL, err)
-			continue
-		}
+	if err := bandwidthUsage.save(); err != nil {
+		log.Printf("Warning: failed to save bandwidth accounting: %v", err)
+	}
+	mirrors.saveHealth()
+	checkMonthlyCap(bandwidthUsage)
+
+	// Catch truncated listings and failed downloads
+	complete := len(incomplete) == 0 && incompleteFiles == nil && failedDownloads.Load() == 0 && pendingPlaceholders() == 0
+	if failed := failedParameters(paramsToDownload, completeness); len(failed) > 0 {
+		runFinished(runDir, false, failed)
+		runLock.release()
+		log.Fatalf("Download incomplete: %d of %d parameters failed: %s",
+			len(failed), len(paramsToDownload), strings.Join(failed, ", "))
+	}
 
+	// Tell downstream schedulers about the run once it is complete. Runs of
+	// models without a schedule cannot be checked and are never announced.
+	_, scheduled := selectedModel.expectedSteps(selectedRun.Time)
+	if *invariantOnly {
+		// The invariant directory is not a run to announce
+	} else if !scheduled {
 		if *verbose {
-			log.Printf("Downloaded and uncompressed: %s", localPath)
+			log.Printf("Model %s has no publication schedule for run %s to check it against, %s not updated",
+				selectedModel.Name, selectedRun.Time, runStatusFileName(selectedModel.Name))
 		}
+	} else if complete {
+		saveDoneMarker(runDir, selectedRun, request)
+		saveRunStatus(selectedRun)
+	} else if *verbose {
+		log.Printf("Run %s is not complete, %s not updated", selectedRun.Time, runStatusFileName(selectedModel.Name))
 	}
+	runFinished(runDir, complete, nil)
 
-	return nil
+	if *verbose {
+		logTimings()
+	}
+	log.Println("Download completed")
+}
+
+// downloadResult describes a successfully downloaded and uncompressed file
+type downloadResult struct {
+	URL              string            // URL downloaded, that of the alternate variant after repeated decoding failures
+	Compression      string            // Compression of the downloaded file: bzip2, gzip or none
+	Cached           bool              // Whether the file was linked from the invariant cache or another job instead of downloaded
+	SharedFrom       string            // File downloaded by another job the file was linked from, empty if not
+	Levels           map[string]string // Level files by level label after -split-levels, nil if not split
+	CompressedSize   int64             // Size of the file as downloaded
+	CompressedSHA256 string            // Hex encoded SHA-256 of the file as downloaded
+	Size             int64             // Size of the uncompressed file
+	SHA256           string            // Hex encoded SHA-256 of the uncompressed file
+	Timings          FileTimings
 }
 
-// downloadAndUncompressFile downloads a single file, uncompresses it from bz2, and retries on failure
-func downloadAndUncompressFile(url, destPath string, retries int) error {
+// downloadAndUncompressFile downloads a single file, uncompresses it unless
+// it was published uncompressed, and retries on failure. Files that
+// repeatedly fail to uncompress are downloaded in their alternate variant
+// if the server offers one.
+func downloadAndUncompressFile(url, destPath string, retries int) (downloadResult, error) {
 	var lastErr error
+	start := time.Now()
+	decodeFailures := 0
 
 	for attempt := 0; attempt <= retries; attempt++ {
 		if attempt > 0 {
@@ -427,82 +891,142 @@ func downloadAndUncompressFile(url, destPath string, retries int) error {
 			time.Sleep(delay)
 		}
 
-		// Create a temporary file for the compressed content
-		tempFile := destPath + ".bz2.tmp"
+		if runExpiry.isExpired() {
+			return downloadResult{}, errRunExpired
+		}
 
-		// Download the compressed file
-		err := downloadFile(url, tempFile)
+		attemptStart := time.Now()
+		result, err := streamFile(url, destPath)
+		if isNotFound(err) && runExpiry.fileNotFound() {
+			return downloadResult{}, errRunExpired
+		}
+		if errors.Is(err, errNoMessages) || errors.Is(err, errEmptyRemote) {
+			return downloadResult{}, err
+		}
 		if err != nil {
 			lastErr = err
 			log.Printf("Download attempt %d failed: %v", attempt+1, err)
-			// Cleanup temp file if it exists
-			os.Remove(tempFile)
+			if isDecodeError(err) {
+				decodeFailures++
+				if alt := alternateURL(url); alt != "" && decodeFailures == alternateAfterDecodeFailures && alternateAvailable(alt) {
+					log.Printf("Warning: %s failed to uncompress %d times, downloading %s instead", url, decodeFailures, alt)
+					url = alt
+					decodeFailures = 0
+				}
+			}
 			continue
 		}
+		result.Timings.Retries = seconds(attemptStart.Sub(start))
+		return result, nil
+	}
 
-		// Open the compressed file
-		compressedFile, err := os.Open(tempFile)
-		if err != nil {
-			lastErr = err
-			log.Printf("Failed to open compressed file: %v", err)
-			os.Remove(tempFile)
-			continue
-		}
+	return downloadResult{}, fmt.Errorf("failed after %d attempts: %v", retries, lastErr)
+}
 
-		// Create the output file
-		outputFile, err := os.Create(destPath)
-		if err != nil {
-			compressedFile.Close()
-			lastErr = err
-			log.Printf("Failed to create output file: %v", err)
-			os.Remove(tempFile)
-			continue
-		}
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
 
-		// Create bzip2 reader
-		bz2Reader := bzip2.NewReader(compressedFile)
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
 
-		// Copy and decompress
-		_, err = io.Copy(outputFile, bz2Reader)
+// streamFile downloads a file and uncompresses it in a single pass, hashing
+// both the compressed and the uncompressed stream on the way. The output is
+// written to a temporary file that replaces destPath only when complete.
+func streamFile(url, destPath string) (downloadResult, error) {
+	if err := injectFault(url); err != nil {
+		return downloadResult{}, err
+	}
 
-		// Close files
-		compressedFile.Close()
-		outputFile.Close()
+	// Only the selected messages are fetched with -messages
+	var body io.ReadCloser
+	var err error
+	var network, reading, writing time.Duration
+	fetchStart := time.Now()
+	if messageFilter != nil {
+		body, err = openMessages(url)
+	} else {
+		body, err = source.Fetch(url)
+	}
+	if err != nil {
+		return downloadResult{}, err
+	}
+	defer body.Close()
+	network = time.Since(fetchStart)
 
-		// Check decompression result
-		if err != nil {
-			lastErr = err
-			log.Printf("Decompression failed: %v", err)
-			os.Remove(tempFile)
-			os.Remove(destPath) // Remove partial output file
-			continue
-		}
+	compressedHash := sha256.New()
+	compressed := &countingWriter{}
+	tee := io.TeeReader(timedReader{faultReader(url, body), &network}, io.MultiWriter(compressedHash, compressed))
 
-		// Cleanup temp file
-		os.Remove(tempFile)
+	// Files published uncompressed are copied as is
+	reader, err := newDecompressor(url, tee)
+	if err != nil {
+		return downloadResult{}, err
+	}
+
+	tempFile := destPath + ".tmp"
+	out, err := os.Create(tempFile)
+	if err != nil {
+		return downloadResult{}, err
+	}
 
-		// If we got here, everything succeeded
-		return nil
+	// Time spent reading beyond waiting for the network is decompression
+	hash := sha256.New()
+	fetched := network
+	size, err := io.Copy(timedWriter{io.MultiWriter(out, hash), &writing}, timedReader{reader, &reading})
+	decompression := max(reading-(network-fetched), 0)
+	if err == nil {
+		// Hash any data the decompressor left unread after the end of the stream
+		_, err = io.Copy(io.Discard, tee)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
 	}
 
-	return fmt.Errorf("failed after %d attempts: %v", retries, lastErr)
+	// Zero-byte placeholders are not retried, they are not yet available
+	if compressed.n == 0 {
+		os.Remove(tempFile)
+		return downloadResult{}, errEmptyRemote
+	}
+	if err != nil {
+		os.Remove(tempFile)
+		return downloadResult{}, err
+	}
+	if err := os.Rename(tempFile, destPath); err != nil {
+		os.Remove(tempFile)
+		return downloadResult{}, err
+	}
+
+	return downloadResult{
+		URL:              url,
+		Compression:      compressionOf(url),
+		CompressedSize:   compressed.n,
+		CompressedSHA256: hex.EncodeToString(compressedHash.Sum(nil)),
+		Size:             size,
+		SHA256:           hex.EncodeToString(hash.Sum(nil)),
+		Timings: FileTimings{
+			Download:      seconds(network),
+			Decompression: seconds(decompression),
+			Write:         seconds(writing),
+		},
+	}, nil
 }
 
-// downloadFile downloads a single file
+// downloadFile downloads a single file from the source as is, without
+// uncompressing it
 func downloadFile(url, destPath string) error {
-	client := &http.Client{
-		Timeout: 10 * time.Minute, // GRIB files can be large
+	if err := injectFault(url); err != nil {
+		return err
 	}
 
-	resp, err := client.Get(url)
+	body, err := source.Fetch(url)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %s", resp.Status)
-	}
+	defer body.Close()
 
 	out, err := os.Create(destPath)
 	if err != nil {
@@ -510,7 +1034,7 @@ func downloadFile(url, destPath string) error {
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	_, err = io.Copy(out, faultReader(url, body))
 	return err
 }
 
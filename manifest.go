@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestFileName is the per-run manifest stored in each run directory
+const manifestFileName = "manifest.json"
+
+// ManifestEntry records how a single output file was produced
+type ManifestEntry struct {
+	Source           string            `json:"source"`                      // URL the file was downloaded from
+	Param            string            `json:"param,omitempty"`             // Parameter of the file
+	SourceName       string            `json:"source_name,omitempty"`       // Output name before -sanitize, if it was changed
+	Compression      string            `json:"compression,omitempty"`       // Compression of the downloaded variant: bzip2, gzip or none
+	CompressedSize   int64             `json:"compressed_size"`             // Size of the file as downloaded
+	CompressedSHA256 string            `json:"compressed_sha256,omitempty"` // SHA-256 of the file as downloaded
+	Size             int64             `json:"size"`                        // Size of the uncompressed file
+	SHA256           string            `json:"sha256"`                      // SHA-256 of the uncompressed file
+	Downloaded       time.Time         `json:"downloaded"`                  // Time the download completed
+	Timings          *FileTimings      `json:"timings,omitempty"`           // Time spent per phase
+	Levels           map[string]string `json:"levels,omitempty"`            // Level files by level label, if the file was split with -split-levels
+	SharedFrom       string            `json:"shared_from,omitempty"`       // File downloaded by another job of the configuration the file was linked or copied from
+}
+
+// Manifest lists the files of a run directory by output file name
+type Manifest struct {
+	Model      string                        `json:"model"`
+	Run        string                        `json:"run"`
+	Downloader BuildInfo                     `json:"downloader"`                 // Build that last wrote the manifest
+	Sanitize   string                        `json:"sanitize,omitempty"`         // -sanitize scheme of the recorded file names
+	Expired    *time.Time                    `json:"expired_upstream,omitempty"` // Time the run was found removed from the server during the download
+	Incomplete map[string]*ParamCompleteness `json:"incomplete,omitempty"`       // Parameters with fewer files than scheduled after the last download
+	Files      map[string]*ManifestEntry     `json:"files"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// runManifest is the manifest of the run currently being downloaded
+var runManifest *Manifest
+
+// loadManifest reads the manifest of a run directory, returning an empty
+// manifest if none exists yet
+func loadManifest(runDir string) (*Manifest, error) {
+	m := &Manifest{
+		Model: selectedModel.Name,
+		Run:   filepath.Base(runDir),
+		Files: make(map[string]*ManifestEntry),
+		path:  filepath.Join(runDir, manifestFileName),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", m.path, err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]*ManifestEntry)
+	}
+	return m, nil
+}
+
+// entry returns the manifest entry of an output file, or nil if there is none
+func (m *Manifest) entry(localPath string) *ManifestEntry {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Files[filepath.Base(localPath)]
+}
+
+// record stores the result of a download in the manifest
+func (m *Manifest) record(f *PlannedFile, result downloadResult) *ManifestEntry {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := filepath.Base(f.LocalPath)
+	sourceName := ""
+	if f.Name != name {
+		sourceName = f.Name
+	}
+	if *sanitizeScheme != sanitizeNone {
+		m.Sanitize = *sanitizeScheme
+	}
+	entry := &ManifestEntry{
+		Source:           result.URL,
+		Param:            f.Param,
+		SourceName:       sourceName,
+		Compression:      result.Compression,
+		CompressedSize:   result.CompressedSize,
+		CompressedSHA256: result.CompressedSHA256,
+		Size:             result.Size,
+		SHA256:           result.SHA256,
+		Downloaded:       time.Now().UTC(),
+		Timings:          &result.Timings,
+		Levels:           result.Levels,
+		SharedFrom:       result.SharedFrom,
+	}
+	m.Files[name] = entry
+	return entry
+}
+
+// restore records the entry of a file downloaded by an interrupted
+// invocation, which did not get to save the manifest
+func (m *Manifest) restore(f *PlannedFile, entry *ManifestEntry) {
+	if m == nil || entry == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if *sanitizeScheme != sanitizeNone {
+		m.Sanitize = *sanitizeScheme
+	}
+	m.Files[filepath.Base(f.LocalPath)] = entry
+}
+
+// markExpired records that the run was removed from the server before it
+// was completely downloaded
+func (m *Manifest) markExpired(t time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Expired = &t
+}
+
+// setIncomplete records the parameters found incomplete after downloading,
+// replacing those of earlier invocations
+func (m *Manifest) setIncomplete(incomplete map[string]*ParamCompleteness) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Incomplete = incomplete
+}
+
+// save writes the manifest atomically
+func (m *Manifest) save() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	m.Downloader = currentBuild()
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
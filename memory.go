@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// memoryCooldown is the time given to a reduction to take effect before the
+// parallelism is reduced again
+const memoryCooldown = 10 * time.Second
+
+// memoryGuard lowers the number of parallel downloads when the resident set
+// size of the process exceeds -max-rss, halving it each time the limit is
+// exceeded again down to sequential downloads
+type memoryGuard struct {
+	mu      sync.Mutex
+	limit   int64 // Limit in bytes, 0 to disable the guard
+	workers int   // Current number of parallel downloads
+	reduced time.Time
+}
+
+// memory is the guard of this invocation, set up from -max-rss and -concurrent
+var memory = &memoryGuard{}
+
+// parallelism returns the number of parallel downloads to use
+func (g *memoryGuard) parallelism() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.workers < 1 {
+		return 1
+	}
+	return g.workers
+}
+
+// throttle checks the resident set size before a download is started. Above
+// the limit it takes slots of the download semaphore out of use, waiting for
+// running downloads to finish, and stops keeping listings in memory.
+func (g *memoryGuard) throttle(semaphore chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit <= 0 || g.workers <= 1 || time.Since(g.reduced) < memoryCooldown {
+		return
+	}
+
+	rss, err := residentSetSize()
+	if err != nil || rss <= g.limit {
+		return
+	}
+
+	reduced := g.workers / 2
+	log.Printf("Warning: memory use of %s exceeds -max-rss %s, reducing parallel downloads from %d to %d",
+		formatBytes(rss), formatBytes(g.limit), g.workers, reduced)
+	for i := reduced; i < g.workers; i++ {
+		semaphore <- struct{}{} // Never released, so the slot stays unused
+	}
+	g.workers, g.reduced = reduced, time.Now()
+
+	disableListingCache()
+	debug.FreeOSMemory()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// messageFilter selects the GRIB messages to download by their .idx
+// inventory line, nil to download whole files
+var messageFilter *regexp.Regexp
+
+// errNoMessages is returned for files without any message matching -messages
+var errNoMessages = errors.New("no GRIB messages match -messages")
+
+// inventoryMessage is a GRIB message listed in an .idx inventory
+type inventoryMessage struct {
+	Line  string // Inventory line, e.g. "5:1234:d=2025031200:TMP:850 mb:anl:"
+	Start int64  // Offset of the first byte of the message
+	End   int64  // Offset of the last byte, -1 for the last message of the file
+}
+
+// byteRange is an inclusive range of bytes, with End -1 for the end of the file
+type byteRange struct {
+	Start, End int64
+}
+
+// inventorySource is implemented by sources publishing an inventory of the
+// messages in each file, which can then be fetched with range requests
+type inventorySource interface {
+	InventoryURL(fileURL string) string
+	FetchRange(url string, r byteRange) (io.ReadCloser, error)
+}
+
+// ecmwfIndexKeys are the fields of an ECMWF .index entry included in the
+// inventory line matched by -messages, in this order
+var ecmwfIndexKeys = []string{"type", "number", "step", "param", "levtype", "levelist"}
+
+// parseInventory parses a wgrib2 style .idx inventory or an ECMWF .index
+// file. Each .idx line holds the message number, byte offset and description
+// separated by colons; a message ends where the next one with a larger
+// offset starts. ECMWF entries are JSON objects with offset and length,
+// turned into lines such as "3:2048:type=fc:step=0:param=t:levtype=pl:levelist=850:".
+func parseInventory(r io.Reader) ([]inventoryMessage, error) {
+	var messages []inventoryMessage
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			m, err := parseIndexEntry(line, len(messages)+1)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, m)
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid inventory line %q", line)
+		}
+		offset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset in inventory line %q", line)
+		}
+		messages = append(messages, inventoryMessage{Line: line, Start: offset, End: -1})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Submessages such as "3.1" and "3.2" share an offset and thus an end
+	for i := range messages {
+		if messages[i].End >= 0 {
+			continue
+		}
+		for j := i + 1; j < len(messages); j++ {
+			if messages[j].Start > messages[i].Start {
+				messages[i].End = messages[j].Start - 1
+				break
+			}
+		}
+	}
+	return messages, nil
+}
+
+// parseIndexEntry parses a JSON entry of an ECMWF .index file
+func parseIndexEntry(line string, number int) (inventoryMessage, error) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return inventoryMessage{}, fmt.Errorf("invalid index entry %q: %v", line, err)
+	}
+	offset, okOffset := entry["_offset"].(float64)
+	length, okLength := entry["_length"].(float64)
+	if !okOffset || !okLength {
+		return inventoryMessage{}, fmt.Errorf("index entry without offset and length: %q", line)
+	}
+
+	desc := fmt.Sprintf("%d:%d:", number, int64(offset))
+	for _, key := range ecmwfIndexKeys {
+		if value, ok := entry[key]; ok {
+			desc += fmt.Sprintf("%s=%v:", key, value)
+		}
+	}
+	return inventoryMessage{Line: desc, Start: int64(offset), End: int64(offset+length) - 1}, nil
+}
+
+// selectRanges returns the byte ranges of the messages matching the filter,
+// merging adjacent messages into a single range
+func selectRanges(messages []inventoryMessage, filter *regexp.Regexp) []byteRange {
+	var ranges []byteRange
+	for _, m := range messages {
+		if !filter.MatchString(m.Line) {
+			continue
+		}
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if last.Start == m.Start || (last.End >= 0 && last.End+1 == m.Start) {
+				last.End = m.End
+				continue
+			}
+		}
+		ranges = append(ranges, byteRange{Start: m.Start, End: m.End})
+	}
+	return ranges
+}
+
+// openMessages opens the messages of a file selected by -messages as a single
+// stream, with one range request per run of adjacent messages
+func openMessages(url string) (io.ReadCloser, error) {
+	fetcher, ok := source.(inventorySource)
+	if !ok {
+		return nil, fmt.Errorf("source has no message inventories")
+	}
+
+	body, err := source.Fetch(fetcher.InventoryURL(url))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch inventory: %v", err)
+	}
+	messages, err := parseInventory(body)
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %v", err)
+	}
+
+	ranges := selectRanges(messages, messageFilter)
+	if len(ranges) == 0 {
+		return nil, errNoMessages
+	}
+	return &rangeReader{fetcher: fetcher, url: url, ranges: ranges}, nil
+}
+
+// rangeReader reads byte ranges of a file one after another, starting each
+// range request when the previous one is exhausted
+type rangeReader struct {
+	fetcher inventorySource
+	url     string
+	ranges  []byteRange
+	current io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.ranges) == 0 {
+				return 0, io.EOF
+			}
+			body, err := r.fetcher.FetchRange(r.url, r.ranges[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.ranges = body, r.ranges[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *rangeReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
+
+// FetchRange starts downloading a byte range of a file
+func (f httpFetcher) FetchRange(url string, r byteRange) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, f.client.HTTPURL(url), nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.End < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.Start))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End))
+	}
+
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("range request failed with status: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minFilesAuto derives the minimum file count from the publication schedule
+const minFilesAuto = -1
+
+// minFiles holds the parsed -min-files flag, keyed by parameter name. The
+// empty key holds the default for all other parameters.
+var minFiles map[string]int
+
+// obtainedFiles counts the files of each parameter that were downloaded or
+// already present and kept
+var obtainedFiles = struct {
+	mu     sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+// parseMinFiles parses a comma-separated list of minimum file counts, e.g.
+// "auto,t_2m=93,hsurf=1". Entries without a parameter name set the default.
+// The count "auto" derives the minimum from the model schedule.
+func parseMinFiles(spec string) (map[string]int, error) {
+	result := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			key, value = "", part
+		}
+		value = strings.TrimSpace(value)
+
+		if value == "auto" {
+			result[strings.TrimSpace(key)] = minFilesAuto
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid minimum file count %q, expected e.g. 50, auto or t_2m=93", part)
+		}
+		result[strings.TrimSpace(key)] = n
+	}
+	return result, nil
+}
+
+// recordObtained counts a file of a parameter as obtained
+func recordObtained(f *PlannedFile) {
+	obtainedFiles.mu.Lock()
+	obtainedFiles.counts[f.Param]++
+	obtainedFiles.mu.Unlock()
+}
+
+// minimumFileCount returns the minimum number of files required for a
+// parameter, and false if no minimum applies. The minimum "auto" is the
+// number of files expected from the schedule, one per scheduled step and
+// level.
+func minimumFileCount(param string, completeness map[string]*ParamCompleteness) (int, bool) {
+	n, ok := minFiles[param]
+	if !ok {
+		n, ok = minFiles[""]
+	}
+	if !ok {
+		return 0, false
+	}
+	if n != minFilesAuto {
+		return n, true
+	}
+
+	c := completeness[param]
+	if c == nil {
+		return 0, false
+	}
+	return c.Expected, true
+}
+
+// failedParameters returns the parameters for which fewer files than the
+// required minimum were obtained, logging each of them
+func failedParameters(params []Parameter, completeness map[string]*ParamCompleteness) []string {
+	var failed []string
+	for _, param := range params {
+		minimum, ok := minimumFileCount(param.Name, completeness)
+		if !ok {
+			continue
+		}
+
+		obtainedFiles.mu.Lock()
+		obtained := obtainedFiles.counts[param.Name]
+		obtainedFiles.mu.Unlock()
+
+		if obtained < minimum {
+			log.Printf("Warning: parameter %s FAILED: obtained %d files, expected at least %d",
+				param.Name, obtained, minimum)
+			failed = append(failed, param.Name)
+		}
+	}
+	return failed
+}
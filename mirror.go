@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// mirrorHealthFileName keeps the mirror health seen by the last invocation
+// in the output directory, so that the next one starts with the mirror
+// found best
+const mirrorHealthFileName = ".mirrors.json"
+
+const (
+	// mirrorAverageWeight is the weight of the latest request in the moving
+	// averages of the success rate and latency
+	mirrorAverageWeight = 0.2
+
+	// mirrorProbeInterval sends every so many requests to the mirror unused
+	// for the longest time, so that the health of all mirrors stays current
+	mirrorProbeInterval = 20
+
+	// mirrorSwitchMargin is how much faster another healthy mirror must be
+	// to take over from the active one
+	mirrorSwitchMargin = 1.25
+)
+
+// mirrorSet fails over between base URLs serving the same tree. Requests
+// are made for URLs below the first base URL and sent to the active mirror
+// instead; when it fails, the others are tried in order of their health and
+// the first that answers becomes the active one. Listings and manifests
+// therefore only ever see the URLs of the first base URL.
+type mirrorSet struct {
+	bases []string          // HTTP(S) base URLs, the first one being the canonical one
+	next  http.RoundTripper // Transport making the actual requests
+
+	mu       sync.Mutex
+	active   int             // Index of the mirror requests are sent to first
+	health   []*MirrorHealth // Health per mirror, in the order of bases
+	requests int             // Requests made, for probing the other mirrors
+}
+
+// MirrorHealth is the health of a mirror observed from its responses
+type MirrorHealth struct {
+	SuccessRate float64       `json:"success_rate"` // Moving average of the requests answered, 1 initially
+	Latency     time.Duration `json:"latency"`      // Moving average of the time to the response headers, 0 if not measured
+	LastFailed  bool          `json:"last_failed"`  // Whether the latest request failed
+	Requests    int           `json:"requests"`     // Requests sent by this invocation
+	Failures    int           `json:"failures"`     // Failed requests of this invocation
+
+	lastUsed int // Value of mirrorSet.requests when last used
+}
+
+// healthy reports whether a mirror answered its latest request and most of
+// the ones before
+func (h *MirrorHealth) healthy() bool {
+	return !h.LastFailed && h.SuccessRate >= 0.5
+}
+
+// String summarises the health for logging
+func (h *MirrorHealth) String() string {
+	latency := "not measured"
+	if h.Latency > 0 {
+		latency = h.Latency.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("%.0f%% answered, latency %s", 100*h.SuccessRate, latency)
+}
+
+// mirrors is the failover set given with several -base-url values, or nil
+var mirrors *mirrorSet
+
+// parseBaseURLs splits the -base-url list and sets up failover if it names
+// more than one mirror. It returns the canonical base URL.
+func parseBaseURLs(spec string) (string, error) {
+	var bases []string
+	for _, base := range strings.Split(spec, ",") {
+		base = strings.TrimSpace(base)
+		if base == "" {
+			continue
+		}
+		if !strings.HasSuffix(base, "/") {
+			base += "/"
+		}
+		bases = append(bases, base)
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("no base URL given")
+	}
+	if len(bases) == 1 {
+		return bases[0], nil
+	}
+
+	for _, base := range bases {
+		if index.IsS3URL(base) {
+			return "", fmt.Errorf("failover between mirrors requires HTTP(S) URLs, not %s", base)
+		}
+	}
+	mirrors = &mirrorSet{bases: bases, next: http.DefaultTransport}
+	for range bases {
+		mirrors.health = append(mirrors.health, &MirrorHealth{SuccessRate: 1})
+	}
+	return bases[0], nil
+}
+
+// httpTransport returns the transport of the HTTP clients talking to the
+// data provider, or nil for the default transport
+func httpTransport() http.RoundTripper {
+	if mirrors == nil {
+		return nil
+	}
+	return mirrors
+}
+
+// RoundTrip sends a request below the canonical base URL to the active
+// mirror, failing over to the others on errors and server errors
+func (m *mirrorSet) RoundTrip(req *http.Request) (*http.Response, error) {
+	canonical := m.bases[0]
+	target := req.URL.String()
+	if !strings.HasPrefix(target, canonical) {
+		return m.next.RoundTrip(req)
+	}
+	path := strings.TrimPrefix(target, canonical)
+
+	var resp *http.Response
+	var err error
+	order := m.order()
+	for i, n := range order {
+		if i > 0 {
+			log.Printf("Warning: mirror %s failed (%s), trying %s", m.bases[order[i-1]], describeFailure(resp, err), m.bases[n])
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+
+		var mirrored *http.Request
+		mirrored, err = rewriteRequest(req, m.bases[n]+path)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		resp, err = m.next.RoundTrip(mirrored)
+		if err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			m.answered(n, time.Since(start))
+			resp.Body = &mirrorBody{ReadCloser: resp.Body, set: m, mirror: n}
+			return resp, nil
+		}
+		m.failed(n)
+
+		// A request canceled or timed out by the client is not retried here,
+		// but the next request starts with the next mirror
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+	}
+	return resp, err
+}
+
+// order returns the mirrors in the order to try them for a request: the
+// active one first unless it failed, then the others by health. Every
+// mirrorProbeInterval requests the mirror unused for the longest time goes
+// first instead, so that a mirror that recovered or became faster is noticed.
+func (m *mirrorSet) order() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+
+	ranked := make([]int, len(m.bases))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return m.better(ranked[i], ranked[j])
+	})
+
+	best, active := m.health[ranked[0]], m.health[m.active]
+	if ranked[0] != m.active && (!active.healthy() || float64(best.Latency)*mirrorSwitchMargin < float64(active.Latency)) {
+		log.Printf("Switching to mirror %s (%s)", m.bases[ranked[0]], best)
+		m.active = ranked[0]
+	}
+	first := m.active
+	if m.requests%mirrorProbeInterval == 0 {
+		for n, h := range m.health {
+			if h.lastUsed < m.health[first].lastUsed {
+				first = n
+			}
+		}
+	}
+
+	order := []int{first}
+	for _, n := range ranked {
+		if n != first {
+			order = append(order, n)
+		}
+	}
+	m.health[first].lastUsed = m.requests
+	return order
+}
+
+// better reports whether mirror a should be tried before mirror b: healthy
+// mirrors first, measured ones by latency, unhealthy ones by success rate
+func (m *mirrorSet) better(a, b int) bool {
+	ha, hb := m.health[a], m.health[b]
+	switch {
+	case ha.healthy() != hb.healthy():
+		return ha.healthy()
+	case !ha.healthy():
+		return ha.SuccessRate > hb.SuccessRate
+	case (ha.Latency == 0) != (hb.Latency == 0):
+		return hb.Latency == 0
+	default:
+		return ha.Latency < hb.Latency
+	}
+}
+
+// rewriteRequest returns a copy of req for another URL
+func rewriteRequest(req *http.Request, target string) (*http.Request, error) {
+	mirrored := req.Clone(req.Context())
+	u, err := req.URL.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	mirrored.URL = u
+	mirrored.Host = ""
+	return mirrored, nil
+}
+
+// describeFailure summarises a failed attempt for logging
+func describeFailure(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// answered records a response of a mirror and the time it took
+func (m *mirrorSet) answered(n int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.health[n]
+	h.Requests++
+	h.LastFailed = false
+	h.SuccessRate += mirrorAverageWeight * (1 - h.SuccessRate)
+	if h.Latency == 0 {
+		h.Latency = latency
+	} else {
+		h.Latency += time.Duration(mirrorAverageWeight * float64(latency-h.Latency))
+	}
+}
+
+// failed records a failed request of a mirror. The next request goes to
+// another mirror.
+func (m *mirrorSet) failed(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.health[n]
+	h.Requests++
+	h.Failures++
+	h.LastFailed = true
+	h.SuccessRate -= mirrorAverageWeight * h.SuccessRate
+}
+
+// loadHealth starts from the mirror health saved in the output directory by
+// the last invocation, if any
+func (m *mirrorSet) loadHealth() {
+	if m == nil {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(*outputDir, mirrorHealthFileName))
+	if err != nil {
+		return
+	}
+	var saved map[string]*MirrorHealth
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Warning: ignoring unreadable %s: %v", mirrorHealthFileName, err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n, base := range m.bases {
+		if h := saved[base]; h != nil {
+			m.health[n] = &MirrorHealth{SuccessRate: h.SuccessRate, Latency: h.Latency, LastFailed: h.LastFailed}
+		}
+	}
+	if *verbose {
+		for n, base := range m.bases {
+			log.Printf("Mirror %s: %s", base, m.health[n])
+		}
+	}
+}
+
+// saveHealth writes the mirror health to the output directory atomically
+func (m *mirrorSet) saveHealth() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	saved := make(map[string]*MirrorHealth)
+	for n, base := range m.bases {
+		h := *m.health[n]
+		saved[base] = &h
+		if *verbose {
+			log.Printf("Mirror %s: %s, %d of %d requests failed", base, &h, h.Failures, h.Requests)
+		}
+	}
+	m.mu.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		log.Printf("Warning: could not save mirror health: %v", err)
+		return
+	}
+	path := filepath.Join(*outputDir, mirrorHealthFileName)
+	tmp, err := os.CreateTemp(*outputDir, mirrorHealthFileName+".*.tmp")
+	if err != nil {
+		log.Printf("Warning: could not save mirror health: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Warning: could not save mirror health: %v", err)
+	}
+}
+
+// mirrorBody reports errors while reading a response, e.g. timeouts in the
+// middle of a download, so that the retry goes to the next mirror
+type mirrorBody struct {
+	io.ReadCloser
+	set    *mirrorSet
+	mirror int
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.set.failed(b.mirror)
+	}
+	return n, err
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Remote model catalog defaults, overridden by -ldflags during release
+// builds, e.g. -X 'main.defaultCatalogURL=https://...' -X 'main.catalogPublicKey=...'
+var (
+	defaultCatalogURL = ""
+	catalogPublicKey  = "" // Base64 encoded Ed25519 public key of the project
+)
+
+// modelCatalogFileName is the verified copy of the remote model catalog,
+// kept in the output directory next to its signature
+const modelCatalogFileName = ".model-catalog.json"
+
+// activeCatalogVersion is the version of the model catalog in use, that of
+// a newer remote catalog once applied
+var activeCatalogVersion = modelCatalogVersion
+
+// providers are the directory layouts newSource knows
+var providers = []string{"", "dwd", "nomads", "ecmwf", "hrrr", "nam", "msc", "mosmix", "radar", "thredds", "knmi"}
+
+// modelCatalog is the JSON form of the model list and publication schedules
+type modelCatalog struct {
+	Version string                  `json:"version"`
+	Models  map[string]catalogModel `json:"models"`
+}
+
+// catalogModel is the JSON form of a Model
+type catalogModel struct {
+	Description string                 `json:"description"`
+	BaseURL     string                 `json:"base_url"`
+	LevelTypes  bool                   `json:"level_types,omitempty"`
+	Grid        string                 `json:"grid,omitempty"`
+	Ensemble    bool                   `json:"ensemble,omitempty"`
+	Provider    string                 `json:"provider,omitempty"`
+	Inventories bool                   `json:"inventories,omitempty"`
+	Archives    bool                   `json:"archives,omitempty"`
+	Schedule    []catalogScheduleEntry `json:"schedule,omitempty"`
+	ParamSets   map[string][]string    `json:"param_sets,omitempty"`
+}
+
+// catalogScheduleEntry is the JSON form of a ScheduleEntry, with the steps
+// in -steps syntax, e.g. "0-78,81-120:3"
+type catalogScheduleEntry struct {
+	RunHours []string `json:"run_hours"`
+	Steps    string   `json:"steps"`
+	Params   []string `json:"params,omitempty"`
+}
+
+// updateModelCatalog replaces the built-in models with those of the signed
+// remote catalog if it is newer. The verified catalog is kept in the output
+// directory and fetched again once it is older than refresh; if the server
+// cannot be reached, the kept copy is used. Problems with the remote
+// catalog are logged, leaving the built-in catalog in use.
+func updateModelCatalog(url, key string, refresh time.Duration) {
+	publicKey, err := parseCatalogKey(key)
+	if err != nil {
+		log.Printf("Warning: not updating the model catalog: %v", err)
+		return
+	}
+
+	path := filepath.Join(*outputDir, modelCatalogFileName)
+	data, cached := loadCachedCatalog(path, publicKey, refresh)
+	if !cached {
+		fetched, err := fetchCatalog(url, publicKey)
+		if err != nil {
+			log.Printf("Warning: failed to fetch the model catalog from %s: %v", url, err)
+			// Fall back to the kept copy, however old
+			if data, cached = loadCachedCatalog(path, publicKey, 0); !cached {
+				return
+			}
+		} else {
+			data = fetched.data
+			if err := saveCatalog(path, fetched); err != nil {
+				log.Printf("Warning: failed to keep the model catalog: %v", err)
+			}
+		}
+	}
+
+	var c modelCatalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		log.Printf("Warning: invalid model catalog: %v", err)
+		return
+	}
+	if err := c.apply(); err != nil {
+		log.Printf("Warning: not using model catalog %s: %v", c.Version, err)
+	}
+}
+
+// parseCatalogKey decodes a base64 encoded Ed25519 public key
+func parseCatalogKey(key string) (ed25519.PublicKey, error) {
+	if key == "" {
+		return nil, fmt.Errorf("no signing key, set -catalog-key")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(decoded) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid -catalog-key, expected a base64 encoded Ed25519 public key")
+	}
+	return ed25519.PublicKey(decoded), nil
+}
+
+// signedCatalog is a catalog as published, with its detached signature
+type signedCatalog struct {
+	data      []byte
+	signature []byte
+}
+
+// verify checks the signature of a catalog
+func (s signedCatalog) verify(publicKey ed25519.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(s.signature)))
+	if err != nil || !ed25519.Verify(publicKey, s.data, signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// fetchCatalog downloads a catalog and its signature from url and url.sig
+func fetchCatalog(url string, publicKey ed25519.PublicKey) (signedCatalog, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	get := func(url string) ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP error: %s", resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	}
+
+	var s signedCatalog
+	var err error
+	if s.data, err = get(url); err != nil {
+		return s, err
+	}
+	if s.signature, err = get(url + ".sig"); err != nil {
+		return s, fmt.Errorf("signature: %v", err)
+	}
+	return s, s.verify(publicKey)
+}
+
+// loadCachedCatalog returns the kept catalog if it is still correctly
+// signed and, unless maxAge is 0, not older than maxAge
+func loadCachedCatalog(path string, publicKey ed25519.PublicKey, maxAge time.Duration) ([]byte, bool) {
+	info, err := os.Stat(path)
+	if err != nil || (maxAge > 0 && time.Since(info.ModTime()) > maxAge) {
+		return nil, false
+	}
+	var s signedCatalog
+	if s.data, err = os.ReadFile(path); err != nil {
+		return nil, false
+	}
+	if s.signature, err = os.ReadFile(path + ".sig"); err != nil {
+		return nil, false
+	}
+	if err := s.verify(publicKey); err != nil {
+		log.Printf("Warning: ignoring %s: %v", path, err)
+		return nil, false
+	}
+	return s.data, true
+}
+
+// saveCatalog keeps a verified catalog and its signature, the signature
+// first so that a catalog is never left without one
+func saveCatalog(path string, s signedCatalog) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{path + ".sig", s.signature}, {path, s.data}} {
+		tmp := file.path + ".tmp"
+		if err := os.WriteFile(tmp, file.data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, file.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply replaces the built-in models with those of a newer catalog. Models
+// of the catalog replace the built-in ones of the same name, other models
+// are added and the remaining built-in ones kept. Nothing is replaced if
+// any model is invalid.
+func (c *modelCatalog) apply() error {
+	remote, err := strconv.Atoi(c.Version)
+	if err != nil {
+		return fmt.Errorf("invalid version %q", c.Version)
+	}
+	builtIn, _ := strconv.Atoi(modelCatalogVersion)
+	if remote <= builtIn {
+		if *verbose {
+			log.Printf("Model catalog %s is not newer than the built-in catalog %s", c.Version, modelCatalogVersion)
+		}
+		return nil
+	}
+
+	converted := make(map[string]Model)
+	for name, def := range c.Models {
+		m, err := def.model(name)
+		if err != nil {
+			return fmt.Errorf("model %s: %v", name, err)
+		}
+		converted[m.Name] = m
+	}
+	for name, m := range converted {
+		models[name] = m
+	}
+	activeCatalogVersion = c.Version
+	log.Printf("Using model catalog %s (built-in %s)", c.Version, modelCatalogVersion)
+	return nil
+}
+
+// model converts a catalog entry into a Model
+func (def catalogModel) model(name string) (Model, error) {
+	name = strings.ToLower(name)
+	if name == "" || strings.ContainsAny(name, " ,/") {
+		return Model{}, fmt.Errorf("invalid name")
+	}
+	if def.BaseURL == "" {
+		return Model{}, fmt.Errorf("no base_url")
+	}
+	known := false
+	for _, p := range providers {
+		known = known || p == def.Provider
+	}
+	if !known {
+		return Model{}, fmt.Errorf("unknown provider %q", def.Provider)
+	}
+
+	m := Model{
+		Name:        name,
+		Description: def.Description,
+		BaseURL:     def.BaseURL,
+		LevelTypes:  def.LevelTypes,
+		Grid:        def.Grid,
+		Ensemble:    def.Ensemble,
+		Provider:    def.Provider,
+		Inventories: def.Inventories,
+		Archives:    def.Archives,
+		ParamSets:   def.ParamSets,
+	}
+	for _, entry := range def.Schedule {
+		for _, hour := range entry.RunHours {
+			if h, err := strconv.Atoi(hour); err != nil || h < 0 || h > 23 || len(hour) != 2 {
+				return Model{}, fmt.Errorf("invalid run hour %q", hour)
+			}
+		}
+		steps, err := parseStepRanges(entry.Steps)
+		if err != nil || len(steps) == 0 {
+			return Model{}, fmt.Errorf("invalid schedule steps %q", entry.Steps)
+		}
+		m.Schedule = append(m.Schedule, ScheduleEntry{RunHours: entry.RunHours, Steps: steps, Params: entry.Params})
+	}
+	return m, nil
+}
+
+// printModelCatalog prints the models in use as a catalog for -dump-catalog,
+// the starting point for publishing an updated catalog
+func printModelCatalog() error {
+	c := modelCatalog{Version: activeCatalogVersion, Models: make(map[string]catalogModel)}
+	for name, m := range models {
+		def := catalogModel{
+			Description: m.Description,
+			BaseURL:     m.BaseURL,
+			LevelTypes:  m.LevelTypes,
+			Grid:        m.Grid,
+			Ensemble:    m.Ensemble,
+			Provider:    m.Provider,
+			Inventories: m.Inventories,
+			Archives:    m.Archives,
+			ParamSets:   m.ParamSets,
+		}
+		for _, entry := range m.Schedule {
+			var steps []string
+			for _, r := range entry.Steps {
+				steps = append(steps, formatStepRange(r))
+			}
+			def.Schedule = append(def.Schedule, catalogScheduleEntry{RunHours: entry.RunHours, Steps: strings.Join(steps, ","), Params: entry.Params})
+		}
+		c.Models[name] = def
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(data))
+	return err
+}
+
+// formatStepRange formats a step range in -steps syntax, e.g. "81-120:3"
+func formatStepRange(r StepRange) string {
+	format := func(step time.Duration) string {
+		if step%time.Hour == 0 {
+			return strconv.Itoa(int(step / time.Hour))
+		}
+		return formatStep(step)
+	}
+	s := format(r.From)
+	if r.To != r.From {
+		s += "-" + format(r.To)
+	}
+	if r.Stride != time.Hour {
+		s += ":" + format(r.Stride)
+	}
+	return s
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Model describes a DWD NWP product published on the open data server
+type Model struct {
+	Name        string // Name used with the -model flag
+	Description string // Human readable description
+	BaseURL     string // URL of the directory containing the run directories
+	LevelTypes  bool   // Whether file names carry a level type (single-level, pressure-level, ...)
+	Grid        string // Grid of the files to download if a directory holds several, e.g. "regular-lat-lon"
+	Ensemble    bool   // Whether each file holds all ensemble members as separate GRIB messages
+	Provider    string // Directory layout of the server: "" for DWD open data, "nomads" for NOAA NOMADS, "ecmwf" for ECMWF open data, "hrrr" for the NOAA HRRR bucket, "nam" for NOAA NAM on NOMADS, "msc" for the MSC Datamart, "knmi" for the KNMI open data API, "thredds" for MET Norway THREDDS catalogs, "mosmix" for DWD MOSMIX
+	Inventories bool   // Whether files have .idx or .index inventories, allowing -messages
+	Archives    bool   // Whether each file is an archive of a whole run, so names carry no forecast step
+
+	// Schedule lists the forecast steps published per run hour, nil if unknown
+	Schedule []ScheduleEntry
+
+	// ParamSets are curated parameter lists selectable as -params @name
+	ParamSets map[string][]string
+}
+
+// models lists the supported products by -model name
+var models = map[string]Model{
+	"icon": {
+		Name:        "icon",
+		Description: "ICON global model (native icosahedral grid, 13 km)",
+		BaseURL:     "https://opendata.dwd.de/weather/nwp/icon/grib/",
+		LevelTypes:  true,
+		Grid:        "icosahedral",
+		Schedule:    iconGlobalSchedule,
+		ParamSets:   map[string][]string{"standard": standardSurfaceSet, "invariant": invariantIcosahedralSet},
+	},
+	"icon-eps": {
+		Name:        "icon-eps",
+		Description: "ICON-EPS global ensemble (native icosahedral grid, 40 members per file)",
+		BaseURL:     "https://opendata.dwd.de/weather/nwp/icon-eps/grib/",
+		LevelTypes:  true,
+		Grid:        "icosahedral",
+		Ensemble:    true,
+		ParamSets:   map[string][]string{"standard": standardEnsembleSet},
+	},
+	"icon-eu": {
+		Name:        "icon-eu",
+		Description: "ICON-EU regional atmosphere model (Europe, 6.5 km)",
+		BaseURL:     "https://opendata.dwd.de/weather/nwp/icon-eu/grib/",
+		LevelTypes:  true,
+		Schedule:    iconEUSchedule,
+		ParamSets:   map[string][]string{"standard": standardSurfaceSet, "invariant": invariantSet},
+	},
+	"icon-d2": {
+		Name:        "icon-d2",
+		Description: "ICON-D2 convection-permitting model (Germany, 2.2 km)",
+		BaseURL:     "https://opendata.dwd.de/weather/nwp/icon-d2/grib/",
+		LevelTypes:  true,
+		Grid:        "regular-lat-lon",
+		Schedule:    iconD2Schedule,
+		ParamSets:   map[string][]string{"standard": standardSurfaceSet, "invariant": invariantSet},
+	},
+	"icon-eu-eps": {
+		Name:        "icon-eu-eps",
+		Description: "ICON-EU-EPS ensemble (Europe, 40 members per file)",
+		BaseURL:     "https://opendata.dwd.de/weather/nwp/icon-eu-eps/grib/",
+		LevelTypes:  true,
+		Grid:        "icosahedral",
+		Ensemble:    true,
+		ParamSets:   map[string][]string{"standard": standardEnsembleSet},
+	},
+	"ewam": {
+		Name:        "ewam",
+		Description: "EWAM European wave model",
+		BaseURL:     "https://opendata.dwd.de/weather/maritime/wave_models/ewam/grib/",
+		ParamSets:   map[string][]string{"standard": standardWaveSet, "swell": swellWaveSet},
+	},
+	"gwam": {
+		Name:        "gwam",
+		Description: "GWAM global wave model",
+		BaseURL:     "https://opendata.dwd.de/weather/maritime/wave_models/gwam/grib/",
+		ParamSets:   map[string][]string{"standard": standardWaveSet, "swell": swellWaveSet},
+	},
+	"cwam": {
+		Name:        "cwam",
+		Description: "CWAM coastal wave model (North Sea and Baltic Sea, 900 m)",
+		BaseURL:     "https://opendata.dwd.de/weather/maritime/wave_models/cwam/grib/",
+		ParamSets:   map[string][]string{"standard": standardWaveSet, "swell": swellWaveSet},
+	},
+	"mosmix-l": {
+		Name:        "mosmix-l",
+		Description: "DWD MOSMIX_L point forecasts (KMZ, about 5400 stations to 240 h, parameters are station IDs or all_stations)",
+		BaseURL:     "https://opendata.dwd.de/weather/local_forecasts/mos/MOSMIX_L/",
+		Provider:    "mosmix",
+		Archives:    true,
+		ParamSets:   map[string][]string{"standard": {mosmixAllStations}},
+	},
+	"mosmix-s": {
+		Name:        "mosmix-s",
+		Description: "DWD MOSMIX_S point forecasts (KMZ, hourly runs to 240 h, all stations in one file)",
+		BaseURL:     "https://opendata.dwd.de/weather/local_forecasts/mos/MOSMIX_S/",
+		Provider:    "mosmix",
+		Archives:    true,
+		ParamSets:   map[string][]string{"standard": {mosmixAllStations}},
+	},
+	"radar": {
+		Name:        "radar",
+		Description: "DWD radar composites (RADOLAN rw and sf, rv nowcasts; runs are the hours of the composite times)",
+		BaseURL:     "https://opendata.dwd.de/weather/radar/",
+		Provider:    "radar",
+		Archives:    true,
+		ParamSets:   map[string][]string{"standard": {"rw", "rv"}},
+	},
+	"gfs": {
+		Name:        "gfs",
+		Description: "NOAA GFS global model from NOMADS (parameters are products such as pgrb2.0p25)",
+		BaseURL:     "https://nomads.ncep.noaa.gov/pub/data/nccf/com/gfs/prod/",
+		Provider:    "nomads",
+		Inventories: true,
+		Schedule:    gfsSchedule,
+		ParamSets:   map[string][]string{"standard": {"pgrb2.0p25"}},
+	},
+	"ifs": {
+		Name:        "ifs",
+		Description: "ECMWF IFS open data (0.25°, parameters are streams such as oper, scda or enfo)",
+		BaseURL:     "https://data.ecmwf.int/forecasts/",
+		Provider:    "ecmwf",
+		Inventories: true,
+		Schedule:    ifsSchedule,
+		ParamSets:   map[string][]string{"standard": {"oper", "scda"}},
+	},
+	"hrrr": {
+		Name:        "hrrr",
+		Description: "NOAA HRRR (CONUS, 3 km, hourly runs; parameters are products such as wrfsfc or wrfsubh)",
+		BaseURL:     "s3://noaa-hrrr-bdp-pds/",
+		Provider:    "hrrr",
+		Inventories: true,
+		Schedule:    hrrrSchedule,
+		ParamSets:   map[string][]string{"standard": {"wrfsfc"}},
+	},
+	"nam": {
+		Name:        "nam",
+		Description: "NOAA NAM from NOMADS (CONUS 12 km and nests, parameters are products such as awphys or conusnest.hiresf)",
+		BaseURL:     "https://nomads.ncep.noaa.gov/pub/data/nccf/com/nam/prod/",
+		Provider:    "nam",
+		Inventories: true,
+		Schedule:    namSchedule,
+		ParamSets:   map[string][]string{"standard": {"awphys"}},
+	},
+	"gdps": {
+		Name:        "gdps",
+		Description: "Environment Canada GEM global model GDPS from the MSC Datamart (0.15°, parameters are variables such as TMP_AGL-2m)",
+		BaseURL:     "https://dd.weather.gc.ca/model_gem_global/15km/grib2/lat_lon/",
+		Provider:    "msc",
+		Schedule:    gdpsSchedule,
+		ParamSets:   map[string][]string{"standard": standardMSCSet},
+	},
+	"hrdps": {
+		Name:        "hrdps",
+		Description: "Environment Canada HRDPS from the MSC Datamart (continental, 2.5 km, parameters are variables such as TMP_AGL-2m)",
+		BaseURL:     "https://dd.weather.gc.ca/model_hrdps/continental/2.5km/",
+		Provider:    "msc",
+		Schedule:    hrdpsSchedule,
+		ParamSets:   map[string][]string{"standard": standardMSCSet},
+	},
+	"harmonie": {
+		Name:        "harmonie",
+		Description: "KNMI Harmonie-Arome Cy43 from the KNMI open data API (parameters are datasets such as p1, one archive per run)",
+		BaseURL:     "https://api.dataplatform.knmi.nl/open-data/v1/datasets/",
+		Provider:    "knmi",
+		Archives:    true,
+		ParamSets:   map[string][]string{"standard": {"p1"}},
+	},
+	"meps": {
+		Name:        "meps",
+		Description: "MET Norway MEPS (Nordic, 2.5 km, NetCDF from THREDDS, parameters are products such as meps_det_2_5km, one file per run)",
+		BaseURL:     "https://thredds.met.no/thredds/catalog/meps25eps/",
+		Provider:    "thredds",
+		Archives:    true,
+		ParamSets:   map[string][]string{"standard": {"meps_det_2_5km"}},
+	},
+	"arome-arctic": {
+		Name:        "arome-arctic",
+		Description: "MET Norway AROME-Arctic (2.5 km, NetCDF from THREDDS, parameters are products such as arome_arctic_det_2_5km, one file per run)",
+		BaseURL:     "https://thredds.met.no/thredds/catalog/aromearcticarchive/",
+		Provider:    "thredds",
+		Archives:    true,
+		ParamSets:   map[string][]string{"standard": {"arome_arctic_det_2_5km"}},
+	},
+}
+
+// defaultModel is used when -model is not given
+const defaultModel = "icon-eu"
+
+// selectedModel is the model chosen with the -model flag
+var selectedModel = models[defaultModel]
+
+// lookupModel returns the model with the given name
+func lookupModel(name string) (Model, bool) {
+	m, ok := models[strings.ToLower(name)]
+	return m, ok
+}
+
+// modelNames returns the names of all supported models in sorted order
+func modelNames() []string {
+	var names []string
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
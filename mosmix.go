@@ -0,0 +1,132 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// mosmixFilePattern matches KMZ files of a run, e.g. "MOSMIX_L_2025031203.kmz",
+// "MOSMIX_S_2025031206_240.kmz" or "MOSMIX_L_2025031203_10637.kmz", capturing
+// the reference time. The LATEST files are copies and do not match.
+var mosmixFilePattern = regexp.MustCompile(`^MOSMIX_[LS]_(\d{10})(?:_[A-Z0-9]+)?\.kmz$`)
+
+// mosmixAllStations is the parameter name of the files holding all stations
+const mosmixAllStations = "all_stations"
+
+// mosmixSource reads the MOSMIX point forecasts of the DWD open data server:
+// KMZ files holding the forecasts of all stations in all_stations/kml/, and
+// for MOSMIX_L of single stations in single_stations/<id>/kml/. Stations take
+// the place of parameters, so -params selects station IDs or all_stations.
+type mosmixSource struct {
+	httpFetcher        // Also lists the directory indexes with its client
+	baseURL     string // URL of the product directory, e.g. ".../mos/MOSMIX_L/"
+}
+
+// newMOSMIXSource creates a source for a MOSMIX product directory
+func newMOSMIXSource(client *index.Client, baseURL string) *mosmixSource {
+	return &mosmixSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+	}
+}
+
+// ListRuns returns the runs found in the all stations directory
+func (s *mosmixSource) ListRuns() ([]ModelRun, error) {
+	files, err := s.listFiles(s.baseURL + mosmixAllStations + "/kml/")
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []ModelRun
+	for _, f := range files {
+		timestamp := f.ModTime.UTC()
+		if timestamp.IsZero() {
+			timestamp = f.ReferenceTime
+		}
+		runs = append(runs, ModelRun{
+			Time:          f.ReferenceTime.Format("15"),
+			URL:           s.baseURL,
+			Timestamp:     timestamp,
+			ReferenceTime: f.ReferenceTime,
+		})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ReferenceTime.Before(runs[j].ReferenceTime) })
+	return runs, nil
+}
+
+// ListParameters returns all_stations and the single station directories
+func (s *mosmixSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	params := []Parameter{{Name: mosmixAllStations, URL: s.baseURL + mosmixAllStations + "/kml/", Run: run.Time}}
+
+	entries, err := s.client.List(s.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Dir && e.Name == "single_stations" {
+			stations, err := s.client.List(e.URL)
+			if err != nil {
+				return nil, err
+			}
+			for _, st := range stations {
+				if st.Dir {
+					params = append(params, Parameter{Name: st.Name, URL: st.URL + "kml/", Run: run.Time})
+				}
+			}
+		}
+	}
+	return params, nil
+}
+
+// ListFiles returns the newest file of the parameter's run hour. The
+// directories keep the runs of several days, of which only the newest is of
+// interest.
+func (s *mosmixSource) ListFiles(param Parameter) ([]index.File, error) {
+	files, err := s.listFiles(param.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var newest []index.File
+	for _, f := range files {
+		if f.ReferenceTime.Format("15") != param.Run {
+			continue
+		}
+		if len(newest) == 0 || f.ReferenceTime.After(newest[0].ReferenceTime) {
+			newest = []index.File{f}
+		}
+	}
+	return newest, nil
+}
+
+// listFiles returns the run files of a kml directory
+func (s *mosmixSource) listFiles(dirURL string) ([]index.File, error) {
+	entries, err := s.client.List(dirURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []index.File
+	for _, e := range entries {
+		match := mosmixFilePattern.FindStringSubmatch(e.Name)
+		if e.Dir || match == nil {
+			continue
+		}
+		ref, err := time.ParseInLocation("2006010215", match[1], time.UTC)
+		if err != nil {
+			continue
+		}
+		files = append(files, index.File{
+			Name:          e.Name,
+			URL:           e.URL,
+			ModTime:       e.ModTime,
+			Size:          e.Size,
+			ReferenceTime: ref,
+			Leadtime:      -1,
+		})
+	}
+	return files, nil
+}
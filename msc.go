@@ -0,0 +1,214 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+var (
+	// mscRunPattern matches the run directories, e.g. "00"
+	mscRunPattern = regexp.MustCompile(`^(\d\d)$`)
+
+	// mscHourPattern matches the forecast hour directories of a run, e.g. "003"
+	mscHourPattern = regexp.MustCompile(`^(\d{3})$`)
+
+	// mscFilePattern matches data files, e.g.
+	// "20250312T00Z_MSC_GDPS_TMP_AGL-2m_LatLon0.15_PT003H.grib2", capturing
+	// the run date and hour, the variable with its level and the step
+	mscFilePattern = regexp.MustCompile(`^(\d{8})T(\d\d)Z_MSC_[A-Za-z]+_(.+)_R?LatLon[0-9.]+_PT(\d{3})H\.grib2$`)
+)
+
+// mscListingTTL is how long hour directory listings are shared between the
+// parameters of a run. Later polls of -wait list the directories again.
+const mscListingTTL = 30 * time.Second
+
+// mscSource reads the MSC Datamart of Environment Canada: run hour
+// directories with one directory per forecast hour, each holding one
+// uncompressed GRIB file per variable and level. Variables such as
+// TMP_AGL-2m take the place of DWD parameter directories, so the files of a
+// parameter are collected from all hour directories of the run.
+type mscSource struct {
+	httpFetcher        // Also lists the directory indexes with its client
+	baseURL     string // URL of the directory containing the run directories
+
+	mu       sync.Mutex
+	listings map[string]mscListing // Hour directory listings by URL
+}
+
+// mscListing is a cached listing of an hour directory
+type mscListing struct {
+	entries []index.Entry
+	listed  time.Time
+}
+
+// newMSCSource creates a source for the run directories under baseURL
+func newMSCSource(client *index.Client, baseURL string) *mscSource {
+	return &mscSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+		listings:    make(map[string]mscListing),
+	}
+}
+
+// ListRuns returns the run directories below the base URL. The run date is
+// not part of the path, so it is taken from the files of the first forecast
+// hour; runs without files yet are left out.
+func (s *mscSource) ListRuns() ([]ModelRun, error) {
+	entries, err := s.client.List(s.baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []ModelRun
+	for _, e := range entries {
+		if !e.Dir || !mscRunPattern.MatchString(e.Name) {
+			continue
+		}
+		ref, err := s.referenceTime(e.URL)
+		if err != nil {
+			return nil, err
+		}
+		if ref.IsZero() {
+			continue
+		}
+		timestamp := e.ModTime.UTC()
+		if timestamp.IsZero() {
+			timestamp = ref
+		}
+		runs = append(runs, ModelRun{
+			Time:          e.Name,
+			URL:           e.URL,
+			Timestamp:     timestamp,
+			ReferenceTime: ref,
+		})
+	}
+	return runs, nil
+}
+
+// referenceTime returns the reference time found in the file names of the
+// first forecast hour directory of a run, or the zero time if there are none
+func (s *mscSource) referenceTime(runURL string) (time.Time, error) {
+	hours, err := s.client.List(runURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Name < hours[j].Name })
+
+	for _, h := range hours {
+		if !h.Dir || !mscHourPattern.MatchString(h.Name) {
+			continue
+		}
+		files, err := s.list(h.URL)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, f := range files {
+			if match := mscFilePattern.FindStringSubmatch(f.Name); match != nil {
+				return time.ParseInLocation("20060102 15", match[1]+" "+match[2], time.UTC)
+			}
+		}
+		break
+	}
+	return time.Time{}, nil
+}
+
+// ListParameters returns the variables published in any forecast hour of a run
+func (s *mscSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	files, err := s.runFiles(run.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var params []Parameter
+	for _, f := range files {
+		name := mscFilePattern.FindStringSubmatch(f.Name)[3]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		params = append(params, Parameter{Name: name, URL: run.URL, Run: run.Time})
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params, nil
+}
+
+// ListFiles returns the files of a variable from all forecast hours of its run
+func (s *mscSource) ListFiles(param Parameter) ([]index.File, error) {
+	entries, err := s.runFiles(param.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []index.File
+	for _, e := range entries {
+		match := mscFilePattern.FindStringSubmatch(e.Name)
+		if match[3] != param.Name {
+			continue
+		}
+		ref, _ := time.ParseInLocation("20060102 15", match[1]+" "+match[2], time.UTC)
+		files = append(files, index.File{
+			Name:          e.Name,
+			URL:           e.URL,
+			ModTime:       e.ModTime,
+			Size:          e.Size,
+			ReferenceTime: ref,
+			Leadtime:      time.Duration(parseInt(match[4])) * time.Hour,
+		})
+	}
+	return files, nil
+}
+
+// runFiles returns the data files in the forecast hour directories of a
+// run. The source is locked while listing, so parameters planned in
+// parallel wait for the first listing instead of repeating it.
+func (s *mscSource) runFiles(runURL string) ([]index.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hours, err := s.client.List(runURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []index.Entry
+	for _, h := range hours {
+		if !h.Dir || !mscHourPattern.MatchString(h.Name) {
+			continue
+		}
+		entries, err := s.listLocked(h.URL)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.Dir && mscFilePattern.MatchString(e.Name) {
+				files = append(files, e)
+			}
+		}
+	}
+	return files, nil
+}
+
+// list returns the listing of an hour directory, reusing a recent one
+func (s *mscSource) list(url string) ([]index.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked(url)
+}
+
+// listLocked is list for callers holding s.mu
+func (s *mscSource) listLocked(url string) ([]index.Entry, error) {
+	if cached, ok := s.listings[url]; ok && time.Since(cached.listed) < mscListingTTL {
+		return cached.entries, nil
+	}
+	entries, err := s.client.List(url)
+	if err != nil {
+		return nil, err
+	}
+	s.listings[url] = mscListing{entries: entries, listed: time.Now()}
+	return entries, nil
+}
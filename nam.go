@@ -0,0 +1,14 @@
+package main
+
+import "regexp"
+
+// namLayout is the NOMADS tree of NOAA NAM: nam.YYYYMMDD directories holding
+// the files of the four runs of a day, one uncompressed GRIB file per product
+// and step with an .idx inventory, e.g. "nam.t06z.awphys12.tm00.grib2" or
+// "nam.t06z.conusnest.hiresf12.tm00.grib2". Products such as awphys or
+// conusnest.hiresf take the place of DWD parameter directories.
+var namLayout = dayFilesLayout{
+	Day:  regexp.MustCompile(`^nam\.(\d{8})$`),
+	File: regexp.MustCompile(`^nam\.t(\d\d)z\.([a-z0-9.]+)(\d\d)\.tm00\.grib2$`),
+	Days: 2,
+}
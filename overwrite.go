@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Overwrite policies for files that already exist in the output directory
+const (
+	policySkipIfNonEmpty   = "skip-if-nonempty"       // Keep any non-empty file
+	policySkipIfSameSize   = "skip-if-same-size"      // Keep files whose sizes match the manifest
+	policySkipIfChecksum   = "skip-if-checksum-match" // Keep files whose SHA-256 matches the manifest
+	policyAlwaysOverwrite  = "always-overwrite"       // Download every file again
+	policyNeverOverwrite   = "never-overwrite"        // Keep every existing file, even empty ones
+	defaultOverwritePolicy = policySkipIfNonEmpty
+)
+
+// overwritePolicies lists the valid values of -overwrite
+var overwritePolicies = []string{
+	policySkipIfNonEmpty,
+	policySkipIfSameSize,
+	policySkipIfChecksum,
+	policyAlwaysOverwrite,
+	policyNeverOverwrite,
+}
+
+// validateOverwritePolicy checks the value of -overwrite
+func validateOverwritePolicy(policy string) error {
+	for _, p := range overwritePolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid overwrite policy '%s'. Valid values are: %s", policy, strings.Join(overwritePolicies, ", "))
+}
+
+// shouldSkip decides according to the -overwrite policy whether an existing
+// output file can be kept. The reason explains the decision either way.
+func shouldSkip(f *PlannedFile) (bool, string) {
+	fileInfo, err := os.Stat(f.LocalPath)
+	if err != nil {
+		// Files split by level are replaced by their level files
+		if n, ok := splitOutputsExist(f); ok && *overwritePolicy != policyAlwaysOverwrite {
+			return true, fmt.Sprintf("split into %d level files", n)
+		}
+		return false, "file does not exist"
+	}
+
+	switch *overwritePolicy {
+	case policyAlwaysOverwrite:
+		return false, "overwrite policy " + policyAlwaysOverwrite
+	case policyNeverOverwrite:
+		return true, "file exists"
+	}
+
+	if fileInfo.Size() == 0 {
+		return false, "file is empty"
+	}
+
+	// A remote file newer than the local copy has been republished upstream
+	if !f.LastModified.IsZero() && f.LastModified.After(fileInfo.ModTime()) {
+		if *verbose {
+			log.Printf("Remote file %s is newer than local copy, downloading again", f.File)
+		}
+		return false, "remote file is newer"
+	}
+
+	switch *overwritePolicy {
+	case policySkipIfSameSize:
+		entry := runManifest.entry(f.LocalPath)
+		if entry == nil {
+			return false, "file not in manifest"
+		}
+		if entry.Size != fileInfo.Size() {
+			return false, "size differs from manifest"
+		}
+		if f.RemoteSize >= 0 && entry.Source == f.URL && entry.CompressedSize != f.RemoteSize {
+			return false, "remote size differs from manifest"
+		}
+		return true, "size matches manifest"
+
+	case policySkipIfChecksum:
+		entry := runManifest.entry(f.LocalPath)
+		if entry == nil || entry.SHA256 == "" {
+			return false, "no checksum in manifest"
+		}
+		sum, err := fileSHA256(f.LocalPath)
+		if err != nil {
+			return false, fmt.Sprintf("cannot compute checksum: %v", err)
+		}
+		if sum != entry.SHA256 {
+			return false, "checksum differs from manifest"
+		}
+		return true, "checksum matches manifest"
+	}
+
+	return true, "non-empty file exists"
+}
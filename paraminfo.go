@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ParamInfo describes a parameter published on the DWD open data server
+type ParamInfo struct {
+	Name      string // DWD directory name, e.g. "t_2m"
+	LongName  string // Descriptive name
+	Units     string // Units of the GRIB field
+	ShortName string // ecCodes GRIB shortName, empty if there is no standard one
+}
+
+// paramInfos maps DWD parameter directory names to their descriptions
+var paramInfos = map[string]ParamInfo{}
+
+func init() {
+	for _, p := range []ParamInfo{
+		{"alb_rad", "Surface albedo (diffuse shortwave)", "%", "al"},
+		{"alhfl_s", "Latent heat net flux at surface (time average)", "W m-2", ""},
+		{"ashfl_s", "Sensible heat net flux at surface (time average)", "W m-2", ""},
+		{"asob_s", "Net shortwave radiation flux at surface (time average)", "W m-2", ""},
+		{"asob_t", "Net shortwave radiation flux at top of atmosphere (time average)", "W m-2", ""},
+		{"aswdifd_s", "Downward diffuse shortwave radiation flux at surface (time average)", "W m-2", ""},
+		{"aswdifu_s", "Upward diffuse shortwave radiation flux at surface (time average)", "W m-2", ""},
+		{"aswdir_s", "Downward direct shortwave radiation flux at surface (time average)", "W m-2", ""},
+		{"athb_s", "Net longwave radiation flux at surface (time average)", "W m-2", ""},
+		{"athb_t", "Net longwave radiation flux at top of atmosphere (time average)", "W m-2", ""},
+		{"aumfl_s", "U-momentum flux at surface (time average)", "N m-2", ""},
+		{"avmfl_s", "V-momentum flux at surface (time average)", "N m-2", ""},
+		{"cape_con", "Convective available potential energy", "J kg-1", ""},
+		{"cape_ml", "Convective available potential energy of mean surface layer parcel", "J kg-1", ""},
+		{"cin_ml", "Convective inhibition of mean surface layer parcel", "J kg-1", ""},
+		{"clc", "Cloud cover", "%", "ccl"},
+		{"clch", "High level cloud cover", "%", "hcc"},
+		{"clcl", "Low level cloud cover", "%", "lcc"},
+		{"clcm", "Medium level cloud cover", "%", "mcc"},
+		{"clct", "Total cloud cover", "%", "tcc"},
+		{"clct_mod", "Modified total cloud cover for media", "1", ""},
+		{"cldepth", "Modified cloud depth for media", "1", ""},
+		{"fi", "Geopotential", "m2 s-2", "z"},
+		{"fr_land", "Land-sea fraction", "1", "lsm"},
+		{"h_snow", "Snow depth", "m", "sde"},
+		{"hbas_con", "Height of convective cloud base above mean sea level", "m", ""},
+		{"htop_con", "Height of convective cloud top above mean sea level", "m", ""},
+		{"htop_dc", "Height of top of dry convection above mean sea level", "m", ""},
+		{"hsurf", "Geometric height of the earth's surface above mean sea level", "m", "h"},
+		{"hzerocl", "Height of the 0 degree C isotherm above mean sea level", "m", ""},
+		{"lai", "Leaf area index", "1", ""},
+		{"mh", "Mixed layer height", "m", ""},
+		{"omega", "Vertical velocity (pressure)", "Pa s-1", "w"},
+		{"p", "Pressure", "Pa", "pres"},
+		{"plcov", "Plant cover", "%", ""},
+		{"pmsl", "Pressure reduced to mean sea level", "Pa", "prmsl"},
+		{"ps", "Surface pressure", "Pa", "sp"},
+		{"qc", "Cloud liquid water mixing ratio", "kg kg-1", "clwmr"},
+		{"qi", "Cloud ice mixing ratio", "kg kg-1", ""},
+		{"qv", "Specific humidity", "kg kg-1", "q"},
+		{"qv_s", "Specific humidity at the surface", "kg kg-1", ""},
+		{"rain_con", "Convective rain (accumulated)", "kg m-2", ""},
+		{"rain_gsp", "Large scale rain (accumulated)", "kg m-2", ""},
+		{"relhum", "Relative humidity", "%", "r"},
+		{"relhum_2m", "Relative humidity at 2 m", "%", "2r"},
+		{"rho_snow", "Snow density", "kg m-3", "rsn"},
+		{"rootdp", "Root depth of vegetation", "m", ""},
+		{"runoff_g", "Soil water runoff (accumulated)", "kg m-2", ""},
+		{"runoff_s", "Surface water runoff (accumulated)", "kg m-2", ""},
+		{"smi", "Soil moisture index (multi-layer)", "1", ""},
+		{"snow_con", "Convective snowfall water equivalent (accumulated)", "kg m-2", ""},
+		{"snow_gsp", "Large scale snowfall water equivalent (accumulated)", "kg m-2", ""},
+		{"snowlmt", "Height of the snow fall limit above mean sea level", "m", ""},
+		{"soiltyp", "Soil type", "1", "slt"},
+		{"t", "Temperature", "K", "t"},
+		{"t_2m", "Temperature at 2 m", "K", "2t"},
+		{"t_g", "Ground surface temperature", "K", ""},
+		{"t_snow", "Temperature of the snow surface", "K", ""},
+		{"t_so", "Soil temperature (multi-layer)", "K", ""},
+		{"td_2m", "Dew point temperature at 2 m", "K", "2d"},
+		{"tke", "Turbulent kinetic energy", "m2 s-2", "tke"},
+		{"tmax_2m", "Maximum temperature at 2 m", "K", "mx2t"},
+		{"tmin_2m", "Minimum temperature at 2 m", "K", "mn2t"},
+		{"tot_prec", "Total precipitation (accumulated)", "kg m-2", "tp"},
+		{"tqc", "Total column integrated cloud water", "kg m-2", "tclw"},
+		{"tqi", "Total column integrated cloud ice", "kg m-2", "tciw"},
+		{"tqv", "Total column integrated water vapour", "kg m-2", "tcwv"},
+		{"u", "U-component of wind", "m s-1", "u"},
+		{"u_10m", "U-component of wind at 10 m", "m s-1", "10u"},
+		{"v", "V-component of wind", "m s-1", "v"},
+		{"v_10m", "V-component of wind at 10 m", "m s-1", "10v"},
+		{"vmax_10m", "Maximum wind speed (gust) at 10 m", "m s-1", "10fg"},
+		{"w", "Vertical velocity (geometric)", "m s-1", "wz"},
+		{"w_snow", "Snow depth water equivalent", "kg m-2", "sd"},
+		{"w_so", "Soil moisture content (multi-layer)", "kg m-2", ""},
+		{"ww", "Significant weather (WMO code)", "1", ""},
+		{"z0", "Surface roughness length", "m", "fsr"},
+
+		// Wave models
+		{"mdts", "Mean direction of total swell", "degree", "mdts"},
+		{"mdww", "Mean direction of wind waves", "degree", "mdww"},
+		{"mpts", "Mean period of total swell", "s", "mpts"},
+		{"mpww", "Mean period of wind waves", "s", "mpww"},
+		{"mwd", "Mean wave direction", "degree", "mwd"},
+		{"mwp", "Mean wave period", "s", "mwp"},
+		{"pp1d", "Peak wave period", "s", "pp1d"},
+		{"shts", "Significant height of total swell", "m", "shts"},
+		{"shww", "Significant height of wind waves", "m", "shww"},
+		{"swh", "Significant height of combined wind waves and swell", "m", "swh"},
+	} {
+		paramInfos[p.Name] = p
+	}
+}
+
+// describeParameters prints the catalog entries of the given parameters, or
+// of all known parameters if the list is "all"
+func describeParameters(list string) {
+	var names []string
+	if list == "all" {
+		for name := range paramInfos {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PARAMETER\tSHORTNAME\tUNITS\tDESCRIPTION")
+	for _, name := range names {
+		info, ok := paramInfos[strings.ToLower(name)]
+		if !ok {
+			fmt.Fprintf(w, "%s\t-\t-\tNo description available\n", name)
+			continue
+		}
+		shortName := info.ShortName
+		if shortName == "" {
+			shortName = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", info.Name, shortName, info.Units, info.LongName)
+	}
+	w.Flush()
+}
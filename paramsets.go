@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// standardSurfaceSet is a common set of near-surface fields of the deterministic ICON models
+var standardSurfaceSet = []string{
+	"t_2m", "td_2m", "relhum_2m", "pmsl", "ps",
+	"u_10m", "v_10m", "vmax_10m",
+	"tot_prec", "ww", "h_snow", "t_g",
+	"clct", "clcl", "clcm", "clch",
+	"asob_s", "athb_s",
+}
+
+// standardEnsembleSet is a common set of near-surface fields of the ensembles
+var standardEnsembleSet = []string{
+	"t_2m", "td_2m", "pmsl", "u_10m", "v_10m", "vmax_10m", "tot_prec", "clct",
+}
+
+// invariantSet are the time-invariant fields commonly needed for
+// regridding and postprocessing, fetched with -invariant
+var invariantSet = []string{"hsurf", "fr_land", "fr_lake", "soiltyp"}
+
+// invariantIcosahedralSet adds the cell coordinates needed to use the
+// native icosahedral grid
+var invariantIcosahedralSet = []string{"hsurf", "fr_land", "fr_lake", "soiltyp", "clat", "clon"}
+
+// standardWaveSet is a common set of integrated wave parameters
+var standardWaveSet = []string{"swh", "mwd", "mwp", "pp1d"}
+
+// swellWaveSet splits the sea state into total swell and wind waves
+var swellWaveSet = []string{"shts", "mdts", "mpts", "shww", "mdww", "mpww"}
+
+// standardMSCSet is a common set of near-surface variables of the MSC models
+var standardMSCSet = []string{
+	"TMP_AGL-2m", "DPT_AGL-2m", "PRMSL_MSL", "UGRD_AGL-10m", "VGRD_AGL-10m", "APCP_Sfc", "TCDC_Sfc",
+}
+
+// expandParamSet returns the parameters of a named set of the selected
+// model, given as "@name" in -params
+func expandParamSet(name string) ([]string, error) {
+	set, ok := selectedModel.ParamSets[strings.TrimPrefix(name, "@")]
+	if ok {
+		return set, nil
+	}
+
+	var names []string
+	for n := range selectedModel.ParamSets {
+		names = append(names, "@"+n)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("model %s has no parameter sets", selectedModel.Name)
+	}
+	return nil, fmt.Errorf("unknown parameter set %s for model %s, available: %s",
+		name, selectedModel.Name, strings.Join(names, ", "))
+}
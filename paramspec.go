@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// paramLevels holds the levels requested per parameter with the name@level
+// syntax of -params; parameters without an entry are downloaded on all levels
+var paramLevels = make(map[string]map[string]bool)
+
+// levelSelections holds the levels selected with -model-levels and
+// -soil-levels by level type, applied to parameters without levels of their own
+var levelSelections = make(map[string]map[string]bool)
+
+// parseParamList parses the -params flag, a comma or space separated list of
+// parameter names, each optionally followed by @ and levels or level ranges,
+// e.g. "t@850,500 fi@500,t_2m u@40-65", or aliases of them such as 2t.
+// Numbers and ranges following a name@level entry are further levels of the
+// same parameter. Entries such as "@standard" expand to a parameter set of
+// the selected model, and entries such as "group:surface" to a parameter
+// group.
+func parseParamList(spec string) ([]string, map[string]map[string]bool, error) {
+	var names []string
+	levels := make(map[string]map[string]bool)
+	seen := make(map[string]bool) // Parameters may be listed again, e.g. in several groups
+	last := ""
+
+	fields, err := expandParamGroups(splitParamFields(spec))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, field := range fields {
+		// A parameter set of the model, e.g. @standard
+		if strings.HasPrefix(field, "@") {
+			set, err := expandParamSet(field)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, name := range set {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+			last = ""
+			continue
+		}
+
+		name, level, hasLevel := strings.Cut(field, "@")
+
+		// A bare number or range continues the level list of the previous parameter
+		if !hasLevel && last != "" && isLevel(field) {
+			if err := addLevels(levels[last], field); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if name == "" {
+			return nil, nil, fmt.Errorf("missing parameter name in %q", field)
+		}
+		name = resolveParamAlias(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		last = ""
+
+		if hasLevel {
+			if !isLevel(level) {
+				return nil, nil, fmt.Errorf("invalid level %q for parameter %s", level, name)
+			}
+			if levels[name] == nil {
+				levels[name] = make(map[string]bool)
+			}
+			if err := addLevels(levels[name], level); err != nil {
+				return nil, nil, err
+			}
+			last = name
+		}
+	}
+	return names, levels, nil
+}
+
+// stdinParams is the -params and -params-file value reading the parameter
+// list from stdin
+const stdinParams = "-"
+
+// stdinParamData holds the parameter list read from stdin, which is read
+// only once but also passed on to child processes
+var stdinParamData struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// readStdinParams returns the parameter list read from stdin
+func readStdinParams() ([]byte, error) {
+	stdinParamData.once.Do(func() {
+		stdinParamData.data, stdinParamData.err = io.ReadAll(os.Stdin)
+	})
+	return stdinParamData.data, stdinParamData.err
+}
+
+// readParamsFile reads a -params-file, holding one -params entry per line,
+// e.g. "t@850,500", with comments starting with #, or stdin for "-". It
+// returns the entries as a -params list.
+func readParamsFile(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == stdinParams {
+		path = "stdin"
+		data, err = readStdinParams()
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	var entries []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, _, err := parseParamList(line); err != nil {
+			return "", fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		entries = append(entries, line)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%s lists no parameters", path)
+	}
+	return strings.Join(entries, " "), nil
+}
+
+// excludedParams holds the patterns of the -exclude-params flag
+var excludedParams []string
+
+// parseExcludeParams parses the -exclude-params flag, a comma or space
+// separated list of parameter names and glob patterns such as "*_so".
+// Parameter sets and groups exclude all their parameters.
+func parseExcludeParams(spec string) ([]string, error) {
+	fields, err := expandParamGroups(splitParamFields(spec))
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, field := range fields {
+		if strings.HasPrefix(field, "@") {
+			set, err := expandParamSet(field)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, set...)
+			continue
+		}
+		// Levels of grouped parameters are irrelevant, the whole parameter is excluded
+		name, _, _ := strings.Cut(field, "@")
+		if name == "" || isLevel(field) {
+			continue
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", name)
+		}
+		patterns = append(patterns, strings.ToLower(resolveParamAlias(name)))
+	}
+	return patterns, nil
+}
+
+// isExcludedParam reports whether a parameter matches -exclude-params
+func isExcludedParam(name string) bool {
+	for _, pattern := range excludedParams {
+		if ok, _ := path.Match(pattern, strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLevelRanges parses a comma-separated list of levels and level ranges,
+// e.g. "40-65" or "1,3,60-65"
+func parseLevelRanges(spec string) (map[string]bool, error) {
+	levels := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if err := addLevels(levels, part); err != nil {
+			return nil, err
+		}
+	}
+	return levels, nil
+}
+
+// maxLevelRange is the largest number of levels a level range may span.
+// Ranges are expanded to their levels, so a mistyped or hostile range such as
+// 0-2000000000 is rejected instead of filling memory.
+const maxLevelRange = 10000
+
+// addLevels adds a level or an inclusive level range such as "40-65" to a set
+func addLevels(levels map[string]bool, s string) error {
+	fromStr, toStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		toStr = fromStr
+	}
+	from, errFrom := strconv.Atoi(fromStr)
+	to, errTo := strconv.Atoi(toStr)
+	if errFrom != nil || errTo != nil || from < 0 || to < from {
+		return fmt.Errorf("invalid level %q, expected e.g. 850 or 40-65", s)
+	}
+	if to-from >= maxLevelRange {
+		return fmt.Errorf("level range %q spans more than %d levels", s, maxLevelRange)
+	}
+	for level := from; level <= to; level++ {
+		levels[strconv.Itoa(level)] = true
+	}
+	return nil
+}
+
+// isLevel reports whether s looks like a level number or level range
+func isLevel(s string) bool {
+	fromStr, toStr, isRange := strings.Cut(s, "-")
+	if _, err := strconv.Atoi(fromStr); err != nil {
+		return false
+	}
+	if isRange {
+		_, err := strconv.Atoi(toStr)
+		return err == nil
+	}
+	return true
+}
+
+// filterByLevels returns the files of a parameter on the levels requested
+// with the name@level syntax. Parameters without levels of their own keep
+// their model-level and soil-level files on the -model-levels and
+// -soil-levels, and all other files.
+func filterByLevels(param string, files []index.File) []index.File {
+	levels, ok := paramLevels[param]
+	if !ok && len(levelSelections) == 0 {
+		return files
+	}
+
+	var filtered []index.File
+	for _, file := range files {
+		selected := levels
+		if !ok {
+			selected = levelSelections[file.LevelType]
+			if selected == nil {
+				filtered = append(filtered, file)
+				continue
+			}
+		}
+		if selected[file.Level] {
+			filtered = append(filtered, file)
+		}
+	}
+
+	if *verbose && len(filtered) != len(files) {
+		log.Printf("Filtered %d files of parameter %s down to %d by level", len(files), param, len(filtered))
+	}
+	return filtered
+}
+
+// selectParams returns the available parameters requested with -params.
+// Names match regardless of case, so T_2M selects t_2m; the levels
+// requested for a name are moved to the parameter it matched. Requested
+// parameters that are not available are skipped with a warning suggesting
+// the closest available names.
+func selectParams(requested []string, available []Parameter) []Parameter {
+	var selected []Parameter
+	chosen := make(map[string]bool)
+	for _, name := range requested {
+		found := false
+		for _, p := range available {
+			if !strings.EqualFold(p.Name, name) {
+				continue
+			}
+			found = true
+			if levels, ok := paramLevels[name]; ok && p.Name != name {
+				delete(paramLevels, name)
+				if paramLevels[p.Name] == nil {
+					paramLevels[p.Name] = levels
+				} else {
+					for level := range levels {
+						paramLevels[p.Name][level] = true
+					}
+				}
+			}
+			if !chosen[p.Name] {
+				chosen[p.Name] = true
+				selected = append(selected, p)
+			}
+			break
+		}
+		if found {
+			continue
+		}
+		if suggestions := closestParams(name, available, 3); len(suggestions) > 0 {
+			log.Printf("Warning: Parameter %s not found and will be skipped, did you mean %s?", name, strings.Join(suggestions, ", "))
+		} else {
+			log.Printf("Warning: Parameter %s not found and will be skipped", name)
+		}
+	}
+	return selected
+}
+
+// closestParams returns up to n available parameter names within a small
+// edit distance of name, closest first
+func closestParams(name string, available []Parameter, n int) []string {
+	name = strings.ToLower(name)
+	limit := max(2, len(name)/3)
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, p := range available {
+		if d := editDistance(name, strings.ToLower(p.Name)); d <= limit {
+			candidates = append(candidates, candidate{p.Name, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	var names []string
+	for _, c := range candidates[:min(n, len(candidates))] {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseParamList(t *testing.T) {
+	saved := selectedModel
+	defer func() { selectedModel = saved }()
+	selectedModel = Model{Name: "test", ParamSets: map[string][]string{"pair": {"t_2m", "pmsl"}}}
+	userParamGroups["test-levels"] = []string{"t@850", "u"}
+	defer delete(userParamGroups, "test-levels")
+
+	tests := []struct {
+		spec   string
+		names  []string
+		levels map[string]string // Sorted levels by parameter, comma-separated
+		err    string
+	}{
+		{"", nil, nil, ""},
+		{"t_2m", []string{"t_2m"}, nil, ""},
+		{"t_2m,clct pmsl", []string{"t_2m", "clct", "pmsl"}, nil, ""},
+		{"t_2m,,clct", []string{"t_2m", "clct"}, nil, ""},
+		{"t@850", []string{"t"}, map[string]string{"t": "850"}, ""},
+		{"t@850,500,fi@500", []string{"t", "fi"}, map[string]string{"t": "500,850", "fi": "500"}, ""},
+		{"u@40-43 v", []string{"u", "v"}, map[string]string{"u": "40,41,42,43"}, ""},
+		{"u@1,3-4,6", []string{"u"}, map[string]string{"u": "1,3,4,6"}, ""},
+		{"t@850 t@500", []string{"t"}, map[string]string{"t": "500,850"}, ""},
+		{"t_2m,850", []string{"t_2m", "850"}, nil, ""},
+
+		// Aliases, parameter sets and groups
+		{"2t,MSL", []string{"t_2m", "pmsl"}, nil, ""},
+		{"@pair,t_2m,clct", []string{"t_2m", "pmsl", "clct"}, nil, ""},
+		{"t@850,@pair,500", []string{"t", "t_2m", "pmsl", "500"}, map[string]string{"t": "850"}, ""},
+		{"group:test-levels,500", []string{"t", "u", "500"}, map[string]string{"t": "850"}, ""},
+		{"t@500,group:test-levels", []string{"t", "u"}, map[string]string{"t": "500,850"}, ""},
+
+		// Errors
+		{"@none", nil, nil, "unknown parameter set @none"},
+		{"group:none", nil, nil, "unknown parameter group group:none"},
+		{"@850", nil, nil, "unknown parameter set @850"},
+		{"t@", nil, nil, `invalid level "" for parameter t`},
+		{"t@high", nil, nil, `invalid level "high" for parameter t`},
+		{"t@500-400", nil, nil, `invalid level "500-400"`},
+		{"u@1-20000", nil, nil, "spans more than 10000 levels"},
+		{"t@850,900-800", nil, nil, `invalid level "900-800"`},
+	}
+	for _, tt := range tests {
+		names, levels, err := parseParamList(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseParamList(%q) error = %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseParamList(%q): %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("parseParamList(%q) names = %q, want %q", tt.spec, names, tt.names)
+		}
+		got := make(map[string]string)
+		for name, set := range levels {
+			var list []string
+			for level := range set {
+				list = append(list, level)
+			}
+			sort.Slice(list, func(i, j int) bool {
+				return len(list[i]) < len(list[j]) || len(list[i]) == len(list[j]) && list[i] < list[j]
+			})
+			got[name] = strings.Join(list, ",")
+		}
+		if tt.levels == nil {
+			tt.levels = map[string]string{}
+		}
+		if !reflect.DeepEqual(got, tt.levels) {
+			t.Errorf("parseParamList(%q) levels = %v, want %v", tt.spec, got, tt.levels)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package index lists the directory indexes of the DWD open data server and
+// mirrors keeping its layout.
+//
+// The server publishes NWP data as a tree of automatically generated index
+// pages: a model directory contains one directory per run hour ("00/",
+// "03/", ...), each run directory one directory per parameter ("t_2m/"), and
+// each parameter directory the compressed GRIB files of that run:
+//
+//	https://opendata.dwd.de/weather/nwp/icon-eu/grib/00/t_2m/icon-eu_europe_regular-lat-lon_single-level_2025031200_000_T_2M.grib2.bz2
+//
+// Parse turns a single index page into entries with their modification
+// times and sizes, and Client builds runs, parameters and files on top of
+// it. Besides the HTML indexes of nginx, Apache and opendata.dwd.de, the JSON
+// and XML autoindex formats of nginx are understood (see ParseAs), and
+// directories given as s3://bucket/prefix/ URLs are listed with the
+// anonymous S3 ListObjectsV2 API.
+package index
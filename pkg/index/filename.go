@@ -0,0 +1,101 @@
+package index
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileNamePattern matches the reference time, forecast step and optional
+// level of DWD file names, e.g. "_2025031206_000_850_T.grib2.bz2". Sub-hourly
+// steps carry the minutes after the hours, e.g. "_2025031206_00215_" for 2h15m.
+var fileNamePattern = regexp.MustCompile(`_(\d{10})_(?:(\d{3})(\d{2})|(\d{3,4}))(?:_(\d+))?_`)
+
+// invariantPattern matches the reference time of time-invariant fields,
+// which carry no forecast step, e.g. "_time-invariant_2025031206_HSURF"
+var invariantPattern = regexp.MustCompile(`_(\d{10})(?:_(\d+))?_[A-Za-z]`)
+
+// LevelTypes are the level categories encoded in DWD file names
+var LevelTypes = []string{"single-level", "pressure-level", "model-level", "soil-level", "time-invariant"}
+
+// ParseFileName parses the structured fields of a DWD GRIB file name. Fields
+// missing from the name are left at their zero value, with Leadtime -1.
+func ParseFileName(name string) File {
+	f := File{Name: name, Size: -1, Leadtime: -1}
+
+	for _, lt := range LevelTypes {
+		if strings.Contains(name, "_"+lt+"_") {
+			f.LevelType = lt
+			break
+		}
+	}
+
+	if match := fileNamePattern.FindStringSubmatch(name); match != nil {
+		f.ReferenceTime = parseReferenceTime(match[1])
+		if match[2] != "" {
+			hours, _ := strconv.Atoi(match[2])
+			minutes, _ := strconv.Atoi(match[3])
+			f.Leadtime = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+		} else {
+			hours, _ := strconv.Atoi(match[4])
+			f.Leadtime = time.Duration(hours) * time.Hour
+		}
+		f.Level = match[5]
+	} else if match := invariantPattern.FindStringSubmatch(name); match != nil && f.LevelType == "time-invariant" {
+		f.ReferenceTime = parseReferenceTime(match[1])
+		f.Level = match[2]
+	}
+
+	// Single-level fields have no level number
+	if f.LevelType == "single-level" {
+		f.Level = ""
+	}
+	return f
+}
+
+// parseReferenceTime parses a YYYYMMDDHH reference time in UTC
+func parseReferenceTime(s string) time.Time {
+	t, err := time.ParseInLocation("2006010215", s, time.UTC)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// HasLeadtime reports whether the file name carries a forecast step
+func (f File) HasLeadtime() bool {
+	return f.Leadtime >= 0
+}
+
+// ValidTime returns the reference time plus the lead time, or false if
+// either is unknown
+func (f File) ValidTime() (time.Time, bool) {
+	if f.ReferenceTime.IsZero() || !f.HasLeadtime() {
+		return time.Time{}, false
+	}
+	return f.ReferenceTime.Add(f.Leadtime), true
+}
+
+// Less orders files by reference time, lead time, level type and level, so
+// that the early forecast steps of a run come first
+func (f File) Less(g File) bool {
+	if !f.ReferenceTime.Equal(g.ReferenceTime) {
+		return f.ReferenceTime.Before(g.ReferenceTime)
+	}
+	if f.Leadtime != g.Leadtime {
+		return f.Leadtime < g.Leadtime
+	}
+	if f.LevelType != g.LevelType {
+		return f.LevelType < g.LevelType
+	}
+	if f.Level != g.Level {
+		a, errA := strconv.Atoi(f.Level)
+		b, errB := strconv.Atoi(g.Level)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return f.Level < g.Level
+	}
+	return f.Name < g.Name
+}
@@ -0,0 +1,104 @@
+package index
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFileName(t *testing.T) {
+	ref := time.Date(2025, 3, 12, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		ref       time.Time
+		leadtime  time.Duration
+		levelType string
+		level     string
+	}{
+		{"icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2", ref, 0, "single-level", ""},
+		{"icon-eu_europe_regular-lat-lon_single-level_2025031206_078_TOT_PREC.grib2.bz2", ref, 78 * time.Hour, "single-level", ""},
+		{"icon-eu_europe_regular-lat-lon_pressure-level_2025031206_005_850_T.grib2.bz2", ref, 5 * time.Hour, "pressure-level", "850"},
+		{"icon-eu_europe_regular-lat-lon_model-level_2025031206_120_58_U.grib2.bz2", ref, 120 * time.Hour, "model-level", "58"},
+		{"icon-eu_europe_regular-lat-lon_soil-level_2025031206_001_3_T_SO.grib2.bz2", ref, time.Hour, "soil-level", "3"},
+		{"icon_global_icosahedral_single-level_2025031206_0180_T_2M.grib2.bz2", ref, 180 * time.Hour, "single-level", ""},
+		{"icon_global_icosahedral_pressure-level_2025031206_0120_1000_T.grib2.bz2", ref, 120 * time.Hour, "pressure-level", "1000"},
+		{"icon-d2_germany_regular-lat-lon_single-level_2025031206_00215_2d_ww.grib2.bz2", ref, 2*time.Hour + 15*time.Minute, "single-level", ""},
+		{"icon-d2_germany_regular-lat-lon_model-level_2025031206_00045_65_w.grib2.bz2", ref, 45 * time.Minute, "model-level", "65"},
+		{"icon-eu_europe_regular-lat-lon_time-invariant_2025031206_HSURF.grib2.bz2", ref, -1, "time-invariant", ""},
+		{"icon-eu_europe_regular-lat-lon_time-invariant_2025031206_58_HHL.grib2.bz2", ref, -1, "time-invariant", "58"},
+		{"icon-eu_europe_regular-lat-lon_single-level_2025031299_000_T_2M.grib2.bz2", time.Time{}, 0, "single-level", ""},
+		{"README.txt", time.Time{}, -1, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := ParseFileName(tt.name)
+			if f.Name != tt.name || f.Size != -1 {
+				t.Errorf("Name, Size = %q, %d, want %q, -1", f.Name, f.Size, tt.name)
+			}
+			if !f.ReferenceTime.Equal(tt.ref) {
+				t.Errorf("ReferenceTime = %v, want %v", f.ReferenceTime, tt.ref)
+			}
+			if !f.ReferenceTime.IsZero() && f.ReferenceTime.Location() != time.UTC {
+				t.Errorf("ReferenceTime %v is not in UTC", f.ReferenceTime)
+			}
+			if f.Leadtime != tt.leadtime {
+				t.Errorf("Leadtime = %v, want %v", f.Leadtime, tt.leadtime)
+			}
+			if f.LevelType != tt.levelType {
+				t.Errorf("LevelType = %q, want %q", f.LevelType, tt.levelType)
+			}
+			if f.Level != tt.level {
+				t.Errorf("Level = %q, want %q", f.Level, tt.level)
+			}
+		})
+	}
+}
+
+func TestValidTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  time.Time
+		valid bool
+	}{
+		{"icon-eu_europe_regular-lat-lon_single-level_2025031218_009_T_2M.grib2.bz2", time.Date(2025, 3, 13, 3, 0, 0, 0, time.UTC), true},
+		{"icon-d2_germany_regular-lat-lon_single-level_2025031206_00215_2d_ww.grib2.bz2", time.Date(2025, 3, 12, 8, 15, 0, 0, time.UTC), true},
+		{"icon-eu_europe_regular-lat-lon_time-invariant_2025031206_HSURF.grib2.bz2", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseFileName(tt.name).ValidTime()
+			if ok != tt.valid || !got.Equal(tt.want) {
+				t.Errorf("ValidTime = %v, %v, want %v, %v", got, ok, tt.want, tt.valid)
+			}
+		})
+	}
+}
+
+func TestFileLess(t *testing.T) {
+	names := []string{
+		"icon-eu_europe_regular-lat-lon_pressure-level_2025031206_000_500_T.grib2.bz2",
+		"icon-eu_europe_regular-lat-lon_pressure-level_2025031206_000_1000_T.grib2.bz2",
+		"icon-eu_europe_regular-lat-lon_pressure-level_2025031206_001_500_T.grib2.bz2",
+		"icon-eu_europe_regular-lat-lon_single-level_2025031206_002_T_2M.grib2.bz2",
+		"icon-eu_europe_regular-lat-lon_single-level_2025031212_000_T_2M.grib2.bz2",
+	}
+	tests := []struct {
+		a, b int
+		want bool
+	}{
+		{0, 1, true},  // Levels compare numerically
+		{1, 0, false}, // 1000 hPa after 500 hPa
+		{1, 2, true},  // Lead time before level
+		{2, 3, true},  // Lead time before level type
+		{3, 4, true},  // Reference time first
+		{4, 0, false},
+	}
+
+	for _, tt := range tests {
+		a, b := ParseFileName(names[tt.a]), ParseFileName(names[tt.b])
+		if got := a.Less(b); got != tt.want {
+			t.Errorf("%s < %s = %v, want %v", names[tt.a], names[tt.b], got, tt.want)
+		}
+	}
+}
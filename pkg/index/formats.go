@@ -0,0 +1,156 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Format is the format of a directory index
+type Format string
+
+// Supported index formats
+const (
+	FormatAuto Format = "auto" // Detect from the response
+	FormatHTML Format = "html" // HTML autoindex of nginx, Apache or opendata.dwd.de
+	FormatJSON Format = "json" // nginx "autoindex_format json"
+	FormatXML  Format = "xml"  // nginx "autoindex_format xml"
+)
+
+// ParseFormat validates a format name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case FormatAuto, FormatHTML, FormatJSON, FormatXML:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown index format %q, expected auto, html, json or xml", name)
+}
+
+// ParseAs parses an index page of the given format. FormatAuto detects the
+// format from the content.
+func ParseAs(r io.Reader, dirURL string, format Format) ([]Entry, error) {
+	if format == FormatAuto {
+		br := bufio.NewReader(r)
+		format = detectFormat(br, "")
+		r = br
+	}
+
+	switch format {
+	case FormatJSON:
+		return ParseJSON(r, dirURL)
+	case FormatXML:
+		return ParseXML(r, dirURL)
+	default:
+		return Parse(r, dirURL)
+	}
+}
+
+// detectFormat determines the index format from the content type or, if that
+// is inconclusive, from the first bytes of the content
+func detectFormat(br *bufio.Reader, contentType string) Format {
+	switch {
+	case strings.Contains(contentType, "json"):
+		return FormatJSON
+	case strings.Contains(contentType, "xml") && !strings.Contains(contentType, "html"):
+		return FormatXML
+	}
+
+	head, _ := br.Peek(512)
+	head = bytes.TrimLeft(head, " \t\r\n\ufeff")
+	switch {
+	case bytes.HasPrefix(head, []byte("[")):
+		return FormatJSON
+	case bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<list")):
+		return FormatXML
+	}
+	return FormatHTML
+}
+
+// jsonEntry is an entry of an nginx JSON autoindex
+type jsonEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	MTime string `json:"mtime"`
+	Size  *int64 `json:"size"`
+}
+
+// ParseJSON parses an nginx JSON autoindex, e.g.
+// [{"name":"00","type":"directory","mtime":"Wed, 12 Mar 2025 02:39:00 GMT"}]
+func ParseJSON(r io.Reader, dirURL string) ([]Entry, error) {
+	var items []jsonEntry
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, item := range items {
+		e := structuredEntry(item.Name, item.Type == "directory", dirURL)
+		if e == nil {
+			continue
+		}
+		if t, err := http.ParseTime(item.MTime); err == nil {
+			e.ModTime = t.UTC()
+		}
+		if item.Size != nil {
+			e.Size = *item.Size
+		}
+		entries = append(entries, *e)
+	}
+	return entries, nil
+}
+
+// xmlList is an nginx XML autoindex
+type xmlList struct {
+	Items []struct {
+		XMLName xml.Name
+		Name    string `xml:",chardata"`
+		MTime   string `xml:"mtime,attr"`
+		Size    *int64 `xml:"size,attr"`
+	} `xml:",any"`
+}
+
+// ParseXML parses an nginx XML autoindex, e.g.
+// <list><directory mtime="2025-03-12T02:39:00Z">00</directory></list>
+func ParseXML(r io.Reader, dirURL string) ([]Entry, error) {
+	var list xmlList
+	if err := xml.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, item := range list.Items {
+		e := structuredEntry(item.Name, item.XMLName.Local == "directory", dirURL)
+		if e == nil {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, item.MTime); err == nil {
+			e.ModTime = t.UTC()
+		}
+		if item.Size != nil {
+			e.Size = *item.Size
+		}
+		entries = append(entries, *e)
+	}
+	return entries, nil
+}
+
+// structuredEntry creates an entry from a name of a JSON or XML index
+func structuredEntry(name string, dir bool, dirURL string) *Entry {
+	name = strings.TrimSuffix(strings.TrimSpace(name), "/")
+	if name == "" || name == ".." || name == "." || strings.Contains(name, "/") {
+		return nil
+	}
+
+	u := dirURL + url.PathEscape(name)
+	if dir {
+		u += "/"
+	}
+	return &Entry{Name: name, URL: u, Dir: dir, Size: -1}
+}
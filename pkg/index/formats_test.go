@@ -0,0 +1,135 @@
+package index
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJSON(t *testing.T) {
+	page := `[
+{ "name":"00", "type":"directory", "mtime":"Wed, 12 Mar 2025 02:39:00 GMT" },
+{ "name":"icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2", "type":"file", "mtime":"Wed, 12 Mar 2025 08:05:00 GMT", "size":1234567 },
+{ "name":"a b.grib2", "type":"file", "mtime":"not a time" },
+{ "name":"..", "type":"directory", "mtime":"Wed, 12 Mar 2025 02:39:00 GMT" }
+]`
+	want := []Entry{
+		{Name: "00", URL: testDirURL + "00/", Dir: true, ModTime: time.Date(2025, 3, 12, 2, 39, 0, 0, time.UTC), Size: -1},
+		{
+			Name:    "icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2",
+			URL:     testDirURL + "icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2",
+			ModTime: time.Date(2025, 3, 12, 8, 5, 0, 0, time.UTC),
+			Size:    1234567,
+		},
+		{Name: "a b.grib2", URL: testDirURL + "a%20b.grib2", Size: -1},
+	}
+
+	got, err := ParseJSON(strings.NewReader(page), testDirURL)
+	if err != nil {
+		t.Fatalf("ParseJSON: %v", err)
+	}
+	assertEntries(t, got, want)
+
+	if _, err := ParseJSON(strings.NewReader(`{"name":"00"}`), testDirURL); err == nil {
+		t.Error("ParseJSON accepted an object instead of a list")
+	}
+}
+
+func TestParseXML(t *testing.T) {
+	page := `<?xml version="1.0"?>
+<list>
+<directory mtime="2025-03-12T02:39:00Z">00</directory>
+<file mtime="2025-03-12T10:05:00+02:00" size="1234567">t_2m.grib2.bz2</file>
+<file size="42">no-time.grib2</file>
+<directory mtime="2025-03-12T02:39:00Z">..</directory>
+</list>`
+	want := []Entry{
+		{Name: "00", URL: testDirURL + "00/", Dir: true, ModTime: time.Date(2025, 3, 12, 2, 39, 0, 0, time.UTC), Size: -1},
+		{Name: "t_2m.grib2.bz2", URL: testDirURL + "t_2m.grib2.bz2", ModTime: time.Date(2025, 3, 12, 8, 5, 0, 0, time.UTC), Size: 1234567},
+		{Name: "no-time.grib2", URL: testDirURL + "no-time.grib2", Size: 42},
+	}
+
+	got, err := ParseXML(strings.NewReader(page), testDirURL)
+	if err != nil {
+		t.Fatalf("ParseXML: %v", err)
+	}
+	assertEntries(t, got, want)
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		content     string
+		want        Format
+	}{
+		{"JSON content type", "application/json", "<html>", FormatJSON},
+		{"XML content type", "text/xml; charset=utf-8", "[", FormatXML},
+		{"XHTML is HTML", "application/xhtml+xml", "<html>", FormatHTML},
+		{"JSON content", "text/plain", "\n  [{\"name\":\"00\"}]", FormatJSON},
+		{"JSON content with BOM", "", "\ufeff[]", FormatJSON},
+		{"XML declaration", "", "<?xml version=\"1.0\"?><list></list>", FormatXML},
+		{"XML list", "", "<list><directory>00</directory></list>", FormatXML},
+		{"HTML content", "text/html", "<html><body><pre></pre></body></html>", FormatHTML},
+		{"empty content", "", "", FormatHTML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.content))
+			if got := detectFormat(br, tt.contentType); got != tt.want {
+				t.Errorf("detectFormat = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAs(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		page   string
+	}{
+		{"auto HTML", FormatAuto, `<pre><a href="00/">00/</a> 12-Mar-2025 02:39 -</pre>`},
+		{"auto JSON", FormatAuto, `[{"name":"00","type":"directory","mtime":"Wed, 12 Mar 2025 02:39:00 GMT"}]`},
+		{"auto XML", FormatAuto, `<list><directory mtime="2025-03-12T02:39:00Z">00</directory></list>`},
+		{"explicit HTML", FormatHTML, `<pre><a href="00/">00/</a> 12-Mar-2025 02:39 -</pre>`},
+		{"explicit JSON", FormatJSON, `[{"name":"00","type":"directory","mtime":"Wed, 12 Mar 2025 02:39:00 GMT"}]`},
+		{"explicit XML", FormatXML, `<list><directory mtime="2025-03-12T02:39:00Z">00</directory></list>`},
+	}
+	want := []Entry{{Name: "00", URL: testDirURL + "00/", Dir: true, ModTime: time.Date(2025, 3, 12, 2, 39, 0, 0, time.UTC), Size: -1}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAs(strings.NewReader(tt.page), testDirURL, tt.format)
+			if err != nil {
+				t.Fatalf("ParseAs: %v", err)
+			}
+			assertEntries(t, got, want)
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Format
+		wantErr bool
+	}{
+		{"auto", FormatAuto, false},
+		{"HTML", FormatHTML, false},
+		{"json", FormatJSON, false},
+		{"xml", FormatXML, false},
+		{"yaml", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFormat(tt.name)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseFormat(%q) = %q, %v, want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
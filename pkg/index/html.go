@@ -0,0 +1,168 @@
+package index
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// indexTimeFormats are the modification time formats found in autoindex
+// pages: nginx and Apache "12-Mar-2025 02:39" and Apache fancy indexes
+// "2025-03-12 02:39", each optionally with seconds. The times carry no zone;
+// opendata.dwd.de and nginx print UTC, so they are always parsed as UTC.
+var indexTimeFormats = []struct {
+	pattern *regexp.Regexp
+	layouts []string
+}{
+	{
+		regexp.MustCompile(`\d\d-[A-Za-z]{3}-\d{4} \d\d:\d\d(:\d\d)?`),
+		[]string{"02-Jan-2006 15:04", "02-Jan-2006 15:04:05"},
+	},
+	{
+		regexp.MustCompile(`\d{4}-\d\d-\d\d \d\d:\d\d(:\d\d)?`),
+		[]string{"2006-01-02 15:04", "2006-01-02 15:04:05"},
+	},
+}
+
+// sizePattern matches exact sizes ("12345"), human readable sizes ("1.2M")
+// and the "-" printed for directories
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)([KMGT]?)$|^-$`)
+
+// Parse parses an HTML autoindex page as produced by nginx, Apache (plain
+// and fancy table indexes) and opendata.dwd.de. Link targets are resolved
+// against dirURL, the URL of the listed directory. Links to parent
+// directories, sort links and links leaving the directory are ignored.
+func Parse(r io.Reader, dirURL string) ([]Entry, error) {
+	var entries []Entry
+	var current *Entry
+	var trailing strings.Builder
+
+	// finish completes the current entry from the text following its link
+	finish := func() {
+		if current != nil {
+			parseTrailingColumns(current, trailing.String())
+			entries = append(entries, *current)
+		}
+		current = nil
+		trailing.Reset()
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				finish()
+				return entries, nil
+			}
+			return nil, z.Err()
+
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			finish()
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					current = newEntry(string(val), dirURL)
+				}
+			}
+
+		case html.EndTagToken:
+			// Table cells and rows separate the trailing columns
+			if current != nil {
+				trailing.WriteByte(' ')
+			}
+
+		case html.TextToken:
+			if current != nil {
+				trailing.Write(z.Text())
+			}
+		}
+	}
+}
+
+// parseTrailingColumns sets the modification time and size of an entry from
+// the text following its link, e.g. "  12-Mar-2025 02:39    12345"
+func parseTrailingColumns(e *Entry, text string) {
+	rest := text
+	for _, format := range indexTimeFormats {
+		loc := format.pattern.FindStringIndex(text)
+		if loc == nil {
+			continue
+		}
+		for _, layout := range format.layouts {
+			if t, err := time.ParseInLocation(layout, text[loc[0]:loc[1]], time.UTC); err == nil {
+				e.ModTime = t
+				break
+			}
+		}
+		rest = text[loc[1]:]
+		break
+	}
+
+	// The size is the first size-like column after the time
+	for _, field := range strings.Fields(rest) {
+		match := sizePattern.FindStringSubmatch(field)
+		if match == nil {
+			continue
+		}
+		if field != "-" {
+			e.Size = parseSize(match[1], match[2])
+		}
+		return
+	}
+}
+
+// parseSize converts a size column to bytes. Human readable sizes are approximate.
+func parseSize(number, unit string) int64 {
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return -1
+	}
+	switch unit {
+	case "K":
+		value *= 1 << 10
+	case "M":
+		value *= 1 << 20
+	case "G":
+		value *= 1 << 30
+	case "T":
+		value *= 1 << 40
+	}
+	return int64(value)
+}
+
+// newEntry creates an entry for a link, or returns nil if the link does not
+// point to a child of the directory
+func newEntry(href, dirURL string) *Entry {
+	if href == "" || href == "../" || strings.HasPrefix(href, "?") ||
+		strings.HasPrefix(href, "/") || strings.Contains(href, "://") {
+		return nil
+	}
+
+	name, err := url.PathUnescape(href)
+	if err != nil {
+		name = href
+	}
+	dir := strings.HasSuffix(name, "/")
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || strings.Contains(name, "/") {
+		return nil
+	}
+
+	return &Entry{
+		Name: name,
+		URL:  dirURL + href,
+		Dir:  dir,
+		Size: -1,
+	}
+}
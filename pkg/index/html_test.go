@@ -0,0 +1,139 @@
+package index
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDirURL = "https://opendata.dwd.de/weather/nwp/icon-eu/grib/06/t_2m/"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want []Entry
+	}{
+		{
+			name: "opendata.dwd.de",
+			page: `<html><head><title>Index of /weather/nwp/icon-eu/grib/06/t_2m/</title></head>
+<body><h1>Index of /weather/nwp/icon-eu/grib/06/t_2m/</h1><hr><pre><a href="../">../</a>
+<a href="icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2">icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2</a> 12-Mar-2025 08:05             1234567
+<a href="icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.bz2">icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.bz2</a> 12-Mar-2025 08:06:30         7654321
+</pre><hr></body></html>`,
+			want: []Entry{
+				{
+					Name:    "icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2",
+					URL:     testDirURL + "icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2",
+					ModTime: time.Date(2025, 3, 12, 8, 5, 0, 0, time.UTC),
+					Size:    1234567,
+				},
+				{
+					Name:    "icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.bz2",
+					URL:     testDirURL + "icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.bz2",
+					ModTime: time.Date(2025, 3, 12, 8, 6, 30, 0, time.UTC),
+					Size:    7654321,
+				},
+			},
+		},
+		{
+			name: "directories",
+			page: `<pre><a href="../">../</a>
+<a href="00/">00/</a>                                                12-Mar-2025 02:39                   -
+<a href="06/">06/</a>                                                12-Mar-2025 08:39                   -
+</pre>`,
+			want: []Entry{
+				{Name: "00", URL: testDirURL + "00/", Dir: true, ModTime: time.Date(2025, 3, 12, 2, 39, 0, 0, time.UTC), Size: -1},
+				{Name: "06", URL: testDirURL + "06/", Dir: true, ModTime: time.Date(2025, 3, 12, 8, 39, 0, 0, time.UTC), Size: -1},
+			},
+		},
+		{
+			name: "Apache fancy index",
+			page: `<table>
+<tr><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th><th><a href="?C=S;O=A">Size</a></th></tr>
+<tr><td><a href="/weather/nwp/icon-eu/grib/06/">Parent Directory</a></td><td>&nbsp;</td><td align="right">  - </td></tr>
+<tr><td><a href="t_2m%2Bextra.grib2.bz2">t_2m+extra.grib2.bz2</a></td><td align="right">2025-03-12 08:05  </td><td align="right">1.5M</td></tr>
+<tr><td><a href="sub/">sub/</a></td><td align="right">2025-03-12 08:04:59  </td><td align="right">  - </td></tr>
+</table>`,
+			want: []Entry{
+				{
+					Name:    "t_2m+extra.grib2.bz2",
+					URL:     testDirURL + "t_2m%2Bextra.grib2.bz2",
+					ModTime: time.Date(2025, 3, 12, 8, 5, 0, 0, time.UTC),
+					Size:    1572864,
+				},
+				{Name: "sub", URL: testDirURL + "sub/", Dir: true, ModTime: time.Date(2025, 3, 12, 8, 4, 59, 0, time.UTC), Size: -1},
+			},
+		},
+		{
+			name: "links leaving the directory",
+			page: `<a href="https://example.org/file.grib2">external</a>
+<a href="/absolute/file.grib2">absolute</a>
+<a href="nested/file.grib2">nested</a>
+<a href="">empty</a>
+<a href="file.grib2">file.grib2</a>`,
+			want: []Entry{
+				{Name: "file.grib2", URL: testDirURL + "file.grib2", Size: -1},
+			},
+		},
+		{
+			name: "no entries",
+			page: `<html><body><h1>Index of /</h1></body></html>`,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.page), testDirURL)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			assertEntries(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseTrailingColumns(t *testing.T) {
+	tests := []struct {
+		text    string
+		modTime time.Time
+		size    int64
+	}{
+		{"  12-Mar-2025 02:39    12345", time.Date(2025, 3, 12, 2, 39, 0, 0, time.UTC), 12345},
+		{" 2025-03-12 23:59:59 2K", time.Date(2025, 3, 12, 23, 59, 59, 0, time.UTC), 2048},
+		{" 01-Jan-2025 00:00 1.5G", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), 1610612736},
+		{" 12-Mar-2025 02:39 -", time.Date(2025, 3, 12, 2, 39, 0, 0, time.UTC), -1},
+		{" no columns", time.Time{}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			e := Entry{Size: -1}
+			parseTrailingColumns(&e, tt.text)
+			if !e.ModTime.Equal(tt.modTime) || e.ModTime.Location() != time.UTC {
+				t.Errorf("ModTime = %v, want %v in UTC", e.ModTime, tt.modTime)
+			}
+			if e.Size != tt.size {
+				t.Errorf("Size = %d, want %d", e.Size, tt.size)
+			}
+		})
+	}
+}
+
+// assertEntries compares parsed entries field by field
+func assertEntries(t *testing.T, got, want []Entry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Name != w.Name || g.URL != w.URL || g.Dir != w.Dir || g.Size != w.Size || !g.ModTime.Equal(w.ModTime) {
+			t.Errorf("entry %d = %+v, want %+v", i, g, w)
+		}
+		if !g.ModTime.IsZero() && g.ModTime.Location() != time.UTC {
+			t.Errorf("entry %d time %v is not in UTC", i, g.ModTime)
+		}
+	}
+}
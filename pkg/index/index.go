@@ -0,0 +1,237 @@
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a single file or directory in an index listing
+type Entry struct {
+	Name    string    // Name without trailing slash
+	URL     string    // Absolute URL of the entry, with a trailing slash for directories
+	Dir     bool      // Whether the entry is a directory
+	ModTime time.Time // Modification time, zero if the listing does not provide one
+	Size    int64     // Size in bytes, -1 for directories and unknown sizes
+}
+
+// Run is a model run directory
+type Run struct {
+	Hour          string    // Run hour, e.g. "00"
+	URL           string    // URL of the run directory
+	Timestamp     time.Time // Modification time of the run directory, in UTC
+	ReferenceTime time.Time // Nominal run time in UTC, e.g. 2025-03-12 00:00 for run 00
+}
+
+// Parameter is a parameter directory of a run
+type Parameter struct {
+	Name string // Parameter name, e.g. "t_2m"
+	URL  string // URL of the parameter directory
+}
+
+// File is a data file in a parameter directory
+type File struct {
+	Name    string    // File name
+	URL     string    // URL of the file
+	ModTime time.Time // Modification time, zero if unknown
+	Size    int64     // Size in bytes, -1 if unknown
+
+	// Parsed from the file name, see ParseFileName
+	ReferenceTime time.Time     // Run reference time in UTC, zero if unknown
+	Leadtime      time.Duration // Forecast step, e.g. 2h15m for sub-hourly output, -1 for files without a step
+	LevelType     string        // Level category, e.g. "pressure-level", "" if unknown
+	Level         string        // Level number: "850" hPa, model level "58" or soil depth "3" cm; "" for single-level fields
+}
+
+// Client lists index pages over HTTP
+type Client struct {
+	// HTTPClient is used for all requests; http.DefaultClient if nil
+	HTTPClient *http.Client
+
+	// S3Endpoint is the endpoint used for s3:// URLs with path-style
+	// access; AWS virtual-hosted buckets are used if empty
+	S3Endpoint string
+
+	// Format of the index pages; detected from each response if empty or FormatAuto
+	Format Format
+}
+
+// runDirPattern matches run hour directory names
+var runDirPattern = regexp.MustCompile(`^\d\d$`)
+
+// get performs a GET request and returns the body of a successful response
+func (c *Client) get(u string) (io.ReadCloser, error) {
+	resp, err := c.getResponse(u)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// getResponse performs a GET request and returns a successful response
+func (c *Client) getResponse(u string) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("status: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// List returns the entries of a directory, given as an HTTP(S) URL of an
+// autoindex page in any supported format or as an s3://bucket/prefix/ URL
+func (c *Client) List(dirURL string) ([]Entry, error) {
+	if !strings.HasSuffix(dirURL, "/") {
+		dirURL += "/"
+	}
+	if IsS3URL(dirURL) {
+		return c.listS3(dirURL)
+	}
+
+	resp, err := c.getResponse(dirURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", dirURL, err)
+	}
+	defer resp.Body.Close()
+
+	format := c.Format
+	var body io.Reader = resp.Body
+	if format == "" || format == FormatAuto {
+		br := bufio.NewReader(resp.Body)
+		format = detectFormat(br, resp.Header.Get("Content-Type"))
+		body = br
+	}
+
+	entries, err := ParseAs(body, dirURL, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse listing of %s: %v", dirURL, err)
+	}
+	return entries, nil
+}
+
+// Runs returns the run directories below a model base URL. Listings without
+// directory timestamps, such as S3, get the newest file time of the first
+// parameter directory of each run instead.
+func (c *Client) Runs(baseURL string) ([]Run, error) {
+	entries, err := c.List(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []Run
+	for _, e := range entries {
+		if !e.Dir || !runDirPattern.MatchString(e.Name) {
+			continue
+		}
+
+		timestamp := e.ModTime
+		if timestamp.IsZero() {
+			if timestamp, err = c.estimateRunTime(e.URL); err != nil {
+				return nil, fmt.Errorf("cannot determine timestamp of run %s: %v", e.Name, err)
+			}
+		}
+
+		runs = append(runs, Run{
+			Hour:          e.Name,
+			URL:           e.URL,
+			Timestamp:     timestamp.UTC(),
+			ReferenceTime: ReferenceTime(e.Name, timestamp),
+		})
+	}
+	return runs, nil
+}
+
+// ReferenceTime returns the nominal time of the run with the given hour
+// whose directory was last modified at timestamp: the latest time at that
+// UTC hour not after the timestamp. Runs are published within a day, so a
+// run 18 directory modified at 01:30 belongs to 18 UTC of the previous day.
+// The zero time is returned if hour is not a valid run hour.
+func ReferenceTime(hour string, timestamp time.Time) time.Time {
+	h, err := strconv.Atoi(hour)
+	if err != nil || h < 0 || h > 23 || timestamp.IsZero() {
+		return time.Time{}
+	}
+	timestamp = timestamp.UTC()
+	ref := time.Date(timestamp.Year(), timestamp.Month(), timestamp.Day(), h, 0, 0, 0, time.UTC)
+	if ref.After(timestamp) {
+		ref = ref.AddDate(0, 0, -1)
+	}
+	return ref
+}
+
+// estimateRunTime returns the newest file time in the first parameter directory of a run
+func (c *Client) estimateRunTime(runURL string) (time.Time, error) {
+	params, err := c.Parameters(runURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(params) == 0 {
+		return time.Time{}, fmt.Errorf("no parameters in %s", runURL)
+	}
+
+	files, err := c.Files(params[0].URL, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var newest time.Time
+	for _, f := range files {
+		if f.ModTime.After(newest) {
+			newest = f.ModTime
+		}
+	}
+	if newest.IsZero() {
+		return time.Time{}, fmt.Errorf("no file times in %s", params[0].URL)
+	}
+	return newest, nil
+}
+
+// Parameters returns the parameter directories of a run
+func (c *Client) Parameters(runURL string) ([]Parameter, error) {
+	entries, err := c.List(runURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var params []Parameter
+	for _, e := range entries {
+		if e.Dir {
+			params = append(params, Parameter{Name: e.Name, URL: e.URL})
+		}
+	}
+	return params, nil
+}
+
+// Files returns the files of a parameter directory whose names end with
+// suffix, e.g. ".grib2.bz2", with the fields parsed from their names. All
+// files are returned if suffix is empty.
+func (c *Client) Files(paramURL, suffix string) ([]File, error) {
+	entries, err := c.List(paramURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, e := range entries {
+		if e.Dir || !strings.HasSuffix(e.Name, suffix) {
+			continue
+		}
+		f := ParseFileName(e.Name)
+		f.URL, f.ModTime, f.Size = e.URL, e.ModTime, e.Size
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,153 @@
+package index
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReferenceTime(t *testing.T) {
+	tests := []struct {
+		hour      string
+		timestamp time.Time
+		want      time.Time
+	}{
+		{"06", time.Date(2025, 3, 12, 8, 39, 0, 0, time.UTC), time.Date(2025, 3, 12, 6, 0, 0, 0, time.UTC)},
+		{"06", time.Date(2025, 3, 12, 6, 0, 0, 0, time.UTC), time.Date(2025, 3, 12, 6, 0, 0, 0, time.UTC)},
+		{"18", time.Date(2025, 3, 13, 1, 30, 0, 0, time.UTC), time.Date(2025, 3, 12, 18, 0, 0, 0, time.UTC)},
+		{"00", time.Date(2025, 3, 1, 3, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// Timestamps in other zones are compared in UTC
+		{"06", time.Date(2025, 3, 12, 9, 30, 0, 0, time.FixedZone("EET", 2*3600)), time.Date(2025, 3, 12, 6, 0, 0, 0, time.UTC)},
+		{"23", time.Date(2025, 3, 13, 0, 30, 0, 0, time.FixedZone("CET", 3600)), time.Date(2025, 3, 12, 23, 0, 0, 0, time.UTC)},
+		{"24", time.Date(2025, 3, 12, 8, 0, 0, 0, time.UTC), time.Time{}},
+		{"xx", time.Date(2025, 3, 12, 8, 0, 0, 0, time.UTC), time.Time{}},
+		{"06", time.Time{}, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hour+" "+tt.timestamp.String(), func(t *testing.T) {
+			got := ReferenceTime(tt.hour, tt.timestamp)
+			if !got.Equal(tt.want) {
+				t.Errorf("ReferenceTime = %v, want %v", got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != time.UTC {
+				t.Errorf("ReferenceTime %v is not in UTC", got)
+			}
+		})
+	}
+}
+
+// testServer serves a model directory tree in the given index format
+func testServer(t *testing.T, format Format) *httptest.Server {
+	t.Helper()
+	pages := map[Format]map[string]string{
+		FormatHTML: {
+			"/icon-eu/": `<pre><a href="../">../</a>
+<a href="00/">00/</a> 12-Mar-2025 02:39 -
+<a href="06/">06/</a> 12-Mar-2025 08:39 -
+<a href="latest/">latest/</a> 12-Mar-2025 08:39 -
+<a href="README">README</a> 12-Mar-2025 08:39 42
+</pre>`,
+			"/icon-eu/06/": `<pre><a href="t_2m/">t_2m/</a> 12-Mar-2025 08:39 -
+<a href="index.html">index.html</a> 12-Mar-2025 08:39 42
+</pre>`,
+			"/icon-eu/06/t_2m/": `<pre><a href="icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2">x</a> 12-Mar-2025 08:05 100
+<a href="icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.bz2">x</a> 12-Mar-2025 08:06 200
+<a href="icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.idx">x</a> 12-Mar-2025 08:06 10
+</pre>`,
+		},
+		FormatJSON: {
+			"/icon-eu/": `[{"name":"00","type":"directory","mtime":"Wed, 12 Mar 2025 02:39:00 GMT"},
+{"name":"06","type":"directory","mtime":"Wed, 12 Mar 2025 08:39:00 GMT"}]`,
+			"/icon-eu/06/": `[{"name":"t_2m","type":"directory","mtime":"Wed, 12 Mar 2025 08:39:00 GMT"}]`,
+			"/icon-eu/06/t_2m/": `[{"name":"icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2","type":"file","mtime":"Wed, 12 Mar 2025 08:05:00 GMT","size":100},
+{"name":"icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.bz2","type":"file","mtime":"Wed, 12 Mar 2025 08:06:00 GMT","size":200}]`,
+		},
+		FormatXML: {
+			"/icon-eu/": `<?xml version="1.0"?><list>
+<directory mtime="2025-03-12T02:39:00Z">00</directory>
+<directory mtime="2025-03-12T08:39:00Z">06</directory></list>`,
+			"/icon-eu/06/": `<?xml version="1.0"?><list><directory mtime="2025-03-12T08:39:00Z">t_2m</directory></list>`,
+			"/icon-eu/06/t_2m/": `<?xml version="1.0"?><list>
+<file mtime="2025-03-12T08:05:00Z" size="100">icon-eu_europe_regular-lat-lon_single-level_2025031206_000_T_2M.grib2.bz2</file>
+<file mtime="2025-03-12T08:06:00Z" size="200">icon-eu_europe_regular-lat-lon_single-level_2025031206_001_T_2M.grib2.bz2</file></list>`,
+		},
+	}[format]
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// No content type, so that the format is detected from the content
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, page)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	for _, format := range []Format{FormatHTML, FormatJSON, FormatXML} {
+		t.Run(string(format), func(t *testing.T) {
+			server := testServer(t, format)
+			c := &Client{}
+
+			runs, err := c.Runs(server.URL + "/icon-eu")
+			if err != nil {
+				t.Fatalf("Runs: %v", err)
+			}
+			wantRuns := []Run{
+				{Hour: "00", URL: server.URL + "/icon-eu/00/", Timestamp: time.Date(2025, 3, 12, 2, 39, 0, 0, time.UTC), ReferenceTime: time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)},
+				{Hour: "06", URL: server.URL + "/icon-eu/06/", Timestamp: time.Date(2025, 3, 12, 8, 39, 0, 0, time.UTC), ReferenceTime: time.Date(2025, 3, 12, 6, 0, 0, 0, time.UTC)},
+			}
+			if len(runs) != len(wantRuns) {
+				t.Fatalf("Runs = %+v, want %+v", runs, wantRuns)
+			}
+			for i, want := range wantRuns {
+				got := runs[i]
+				if got.Hour != want.Hour || got.URL != want.URL || !got.Timestamp.Equal(want.Timestamp) || !got.ReferenceTime.Equal(want.ReferenceTime) {
+					t.Errorf("run %d = %+v, want %+v", i, got, want)
+				}
+			}
+
+			params, err := c.Parameters(runs[1].URL)
+			if err != nil {
+				t.Fatalf("Parameters: %v", err)
+			}
+			if len(params) != 1 || params[0].Name != "t_2m" || params[0].URL != server.URL+"/icon-eu/06/t_2m/" {
+				t.Fatalf("Parameters = %+v, want t_2m", params)
+			}
+
+			files, err := c.Files(params[0].URL, ".grib2.bz2")
+			if err != nil {
+				t.Fatalf("Files: %v", err)
+			}
+			if len(files) != 2 {
+				t.Fatalf("Files = %+v, want 2 files", files)
+			}
+			for i, f := range files {
+				if f.Leadtime != time.Duration(i)*time.Hour || f.LevelType != "single-level" || f.Size != int64(100*(i+1)) {
+					t.Errorf("file %d = %+v", i, f)
+				}
+				if f.ModTime.Location() != time.UTC || f.ReferenceTime.Location() != time.UTC {
+					t.Errorf("file %d times %v, %v are not in UTC", i, f.ModTime, f.ReferenceTime)
+				}
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := testServer(t, FormatHTML)
+	c := &Client{}
+	if _, err := c.List(server.URL + "/missing/"); err == nil {
+		t.Error("List of a missing directory succeeded")
+	}
+	if _, err := c.Runs(server.URL + "/missing"); err == nil {
+		t.Error("Runs of a missing directory succeeded")
+	}
+}
@@ -0,0 +1,102 @@
+package index
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// s3ListResult is the response of the S3 ListObjectsV2 API
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// IsS3URL reports whether a URL refers to an S3 bucket (s3://bucket/prefix/)
+func IsS3URL(u string) bool {
+	return strings.HasPrefix(u, "s3://")
+}
+
+// splitS3URL splits an s3://bucket/prefix URL into bucket and key prefix
+func splitS3URL(u string) (bucket, key string) {
+	rest := strings.TrimPrefix(u, "s3://")
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i], rest[i+1:]
+	}
+	return rest, ""
+}
+
+// bucketURL returns the HTTP URL of a bucket, using path-style access if an
+// S3 endpoint is configured and the AWS virtual-hosted style otherwise
+func (c *Client) bucketURL(bucket string) string {
+	if c.S3Endpoint != "" {
+		return strings.TrimSuffix(c.S3Endpoint, "/") + "/" + bucket
+	}
+	return fmt.Sprintf("https://%s.s3.amazonaws.com", bucket)
+}
+
+// HTTPURL converts s3:// URLs to anonymous HTTPS object URLs and returns other URLs unchanged
+func (c *Client) HTTPURL(u string) string {
+	if !IsS3URL(u) {
+		return u
+	}
+	bucket, key := splitS3URL(u)
+	return c.bucketURL(bucket) + "/" + key
+}
+
+// listS3 lists the common prefixes and objects directly below an s3://bucket/prefix/ URL
+func (c *Client) listS3(dirURL string) ([]Entry, error) {
+	bucket, prefix := splitS3URL(dirURL)
+
+	var entries []Entry
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("delimiter", "/")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, err := c.get(c.bucketURL(bucket) + "/?" + query.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", dirURL, err)
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse S3 listing of %s: %v", dirURL, err)
+		}
+
+		for _, p := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			if name != "" {
+				entries = append(entries, Entry{Name: name, URL: dirURL + name + "/", Dir: true, Size: -1})
+			}
+		}
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, prefix)
+			if name != "" {
+				entries = append(entries, Entry{Name: name, URL: dirURL + name, ModTime: obj.LastModified, Size: obj.Size})
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return entries, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
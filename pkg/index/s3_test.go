@@ -0,0 +1,88 @@
+package index
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSplitS3URL(t *testing.T) {
+	tests := []struct {
+		url, bucket, key string
+	}{
+		{"s3://noaa-hrrr-bdp-pds/hrrr.20250312/conus/", "noaa-hrrr-bdp-pds", "hrrr.20250312/conus/"},
+		{"s3://bucket/", "bucket", ""},
+		{"s3://bucket", "bucket", ""},
+	}
+
+	for _, tt := range tests {
+		bucket, key := splitS3URL(tt.url)
+		if bucket != tt.bucket || key != tt.key {
+			t.Errorf("splitS3URL(%q) = %q, %q, want %q, %q", tt.url, bucket, key, tt.bucket, tt.key)
+		}
+	}
+}
+
+func TestHTTPURL(t *testing.T) {
+	tests := []struct {
+		endpoint, url, want string
+	}{
+		{"", "s3://bucket/icon-eu/06/t_2m/file.grib2.bz2", "https://bucket.s3.amazonaws.com/icon-eu/06/t_2m/file.grib2.bz2"},
+		{"https://minio.example.org/", "s3://bucket/icon-eu/file.grib2.bz2", "https://minio.example.org/bucket/icon-eu/file.grib2.bz2"},
+		{"", "https://opendata.dwd.de/file.grib2.bz2", "https://opendata.dwd.de/file.grib2.bz2"},
+	}
+
+	for _, tt := range tests {
+		c := &Client{S3Endpoint: tt.endpoint}
+		if got := c.HTTPURL(tt.url); got != tt.want {
+			t.Errorf("HTTPURL(%q) with endpoint %q = %q, want %q", tt.url, tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestListS3(t *testing.T) {
+	// Two pages, the first one truncated
+	pages := map[string]string{
+		"": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Name>bucket</Name><Prefix>icon-eu/</Prefix>
+<IsTruncated>true</IsTruncated><NextContinuationToken>page2</NextContinuationToken>
+<CommonPrefixes><Prefix>icon-eu/00/</Prefix></CommonPrefixes>
+<CommonPrefixes><Prefix>icon-eu/06/</Prefix></CommonPrefixes>
+</ListBucketResult>`,
+		"page2": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<IsTruncated>false</IsTruncated>
+<Contents><Key>icon-eu/README.txt</Key><LastModified>2025-03-12T08:05:00.000Z</LastModified><Size>42</Size></Contents>
+<Contents><Key>icon-eu/</Key><LastModified>2025-03-12T08:05:00.000Z</LastModified><Size>0</Size></Contents>
+</ListBucketResult>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/bucket/" || q.Get("list-type") != "2" || q.Get("delimiter") != "/" || q.Get("prefix") != "icon-eu/" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		page, ok := pages[q.Get("continuation-token")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	c := &Client{S3Endpoint: server.URL}
+	got, err := c.List("s3://bucket/icon-eu")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := []Entry{
+		{Name: "00", URL: "s3://bucket/icon-eu/00/", Dir: true, Size: -1},
+		{Name: "06", URL: "s3://bucket/icon-eu/06/", Dir: true, Size: -1},
+		{Name: "README.txt", URL: "s3://bucket/icon-eu/README.txt", ModTime: time.Date(2025, 3, 12, 8, 5, 0, 0, time.UTC), Size: 42},
+	}
+	assertEntries(t, got, want)
+}
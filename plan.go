@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// PlannedFile is a single GRIB file selected for download
+type PlannedFile struct {
+	Param     string     // Parameter name
+	File      string     // Remote file name
+	Info      index.File // Listing entry with the fields parsed from the file name
+	URL       string     // Remote file URL
+	LocalPath string     // Path of the uncompressed output file
+	Name      string     // Output file name before -sanitize was applied
+	Outputs   []string   // Level files the download was split into with -split-levels
+
+	// Filled in by prefetchPlan when -prefetch is given
+	RemoteSize   int64     // Size of the remote file in bytes, -1 if unknown
+	LastModified time.Time // Modification time of the remote file, zero if unknown
+}
+
+// failedDownloads counts the parameters and files that could not be
+// downloaded in this invocation
+var failedDownloads atomic.Int64
+
+// downloadedFiles counts the files downloaded in this invocation
+var downloadedFiles atomic.Int64
+
+// planDownloads lists the GRIB files of the given parameters in parallel and
+// returns the files to download
+func planDownloads(params []Parameter, runTime string) []*PlannedFile {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		plan      []*PlannedFile
+		semaphore = make(chan struct{}, *maxConcurrent)
+	)
+
+	for _, param := range params {
+		wg.Add(1)
+		go func(param Parameter) {
+			defer wg.Done()
+			semaphore <- struct{}{}        // Acquire semaphore
+			defer func() { <-semaphore }() // Release semaphore
+
+			files, err := planGribFiles(param, runTime)
+			if err != nil {
+				log.Printf("Error downloading parameter %s: %v", param.Name, err)
+				failedDownloads.Add(1)
+				return
+			}
+
+			mu.Lock()
+			plan = append(plan, files...)
+			mu.Unlock()
+		}(param)
+	}
+
+	wg.Wait()
+	return plan
+}
+
+// planGribFiles lists the GRIB files of a parameter and returns the ones matching the filters
+func planGribFiles(param Parameter, runTime string) ([]*PlannedFile, error) {
+	if *verbose {
+		log.Printf("Listing parameter: %s", param.Name)
+	}
+
+	files, err := getGribFiles(param)
+	if err != nil {
+		return nil, err
+	}
+
+	// Zero-byte placeholders are not downloaded
+	if files, err = handleEmptyListing(param.Name, files); err != nil {
+		return nil, err
+	}
+
+	// Report names that do not parse as expected before they are filtered out
+	checkFileNames(param.Name, runTime, files)
+
+	// Record the full listing for change detection before any filtering
+	catalog.recordFiles(param.Name, files)
+
+	files = filterByGrid(files)
+	files = filterByLevelType(files)
+	files = filterByLevels(param.Name, files)
+	leadtimes.listed(param.Name, files)
+	files = filterByValidTime(files)
+	files = filterBySteps(files)
+
+	if len(files) == 0 {
+		if len(levelTypeFilter) > 0 {
+			return nil, fmt.Errorf("no %s GRIB files found for parameter %s", strings.Join(levelTypeFilter, " or "), param.Name)
+		}
+		if _, ok := paramLevels[param.Name]; ok {
+			return nil, fmt.Errorf("no GRIB files on the requested levels found for parameter %s", param.Name)
+		}
+		if len(validTimeRanges) > 0 {
+			return nil, fmt.Errorf("no GRIB files valid at the requested times found for parameter %s", param.Name)
+		}
+		if len(stepRanges) > 0 || *maxHour >= 0 {
+			return nil, fmt.Errorf("no GRIB files with the requested steps found for parameter %s", param.Name)
+		}
+		return nil, fmt.Errorf("no GRIB files found for parameter %s", param.Name)
+	}
+
+	// Create run directory (one directory per model run), except for dry runs
+	runDir := filepath.Join(*outputDir, runDirName(runTime))
+	if !*planOnly {
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create run directory: %v", err)
+		}
+	}
+
+	var planned []*PlannedFile
+	for _, file := range files {
+		// Create a filename with parameter name as prefix to avoid conflicts
+		// e.g., "t_2m_icon-eu_europe_regular-lat-lon_single-level_2023030612_000.grib2"
+		outputFilename := uncompressedName(fmt.Sprintf("%s_%s", param.Name, file.Name))
+		if *invariantOnly {
+			// Names in the invariant directory must not change between runs
+			outputFilename = invariantReferenceTime.ReplaceAllString(outputFilename, "$1")
+		}
+		sourceName := outputFilename
+		outputFilename = sanitizeFilename(outputFilename, *sanitizeScheme)
+
+		planned = append(planned, &PlannedFile{
+			Param:      param.Name,
+			File:       file.Name,
+			Info:       file,
+			URL:        file.URL,
+			LocalPath:  filepath.Join(runDir, outputFilename),
+			Name:       sourceName,
+			RemoteSize: -1,
+		})
+	}
+
+	return planned, nil
+}
+
+// downloadPlan downloads the planned files using at most -concurrent parallel
+// downloads, fewer once -max-rss was exceeded. Files are started in order of
+// lead time, so that the early forecast steps of all parameters are
+// available first.
+func downloadPlan(plan []*PlannedFile) {
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, memory.parallelism())
+		progress  = newProgress(plan)
+		tracker   = newDeadlineTracker(plan)
+		queued    = time.Now()
+	)
+
+	ordered := make([]*PlannedFile, len(plan))
+	copy(ordered, plan)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Info.Less(ordered[j].Info)
+	})
+
+	for _, f := range ordered {
+		// Files of a run that has left the server are not started
+		if runExpiry.isExpired() {
+			break
+		}
+		memory.throttle(semaphore)
+		wg.Add(1)
+		semaphore <- struct{}{} // Acquire semaphore before starting to keep the order
+		sharedSlots.acquire()
+		go func(f *PlannedFile) {
+			defer wg.Done()
+			defer func() { <-semaphore }() // Release semaphore
+			defer sharedSlots.release()
+
+			defer progress.fileDone(f)
+			queue := time.Since(queued)
+
+			// A HEAD request found a zero-byte placeholder
+			if f.RemoteSize == 0 {
+				handleEmptyDownload(f)
+				tracker.fileDone(f, false)
+				leadtimes.notYetAvailable(f)
+				return
+			}
+
+			if skip, reason := shouldSkip(f); skip {
+				if *verbose {
+					log.Printf("Skipping existing file: %s (%s)", f.LocalPath, reason)
+				}
+				tracker.fileDone(f, true)
+				leadtimes.fileDone(f, true)
+				recordObtained(f)
+				downloadJournal.finished(f, nil)
+				return
+			}
+
+			// Download and uncompress file with retries, or link it from
+			// the invariant cache or another job
+			var result downloadResult
+			var err error
+			if invariants != nil && f.Info.LevelType == "time-invariant" {
+				result, err = invariants.obtain(f)
+			} else if sharedDownloads != nil {
+				result, err = sharedDownloads.obtain(f)
+			} else {
+				result, err = downloadAndUncompressFile(f.URL, f.LocalPath, *maxRetries)
+			}
+			if err == nil && *splitLevels {
+				splitDownload(f, &result)
+			}
+			if errors.Is(err, errNoMessages) {
+				if *verbose {
+					log.Printf("Skipping %s: %v", f.URL, err)
+				}
+				tracker.fileDone(f, true)
+				leadtimes.fileDone(f, true)
+				downloadJournal.finished(f, nil)
+				return
+			}
+			tracker.fileDone(f, err == nil)
+			if errors.Is(err, errEmptyRemote) {
+				handleEmptyDownload(f)
+				leadtimes.notYetAvailable(f)
+				return
+			}
+			leadtimes.fileDone(f, err == nil)
+			if errors.Is(err, errRunExpired) {
+				return
+			}
+			if err != nil {
+				log.Printf("Error downloading %s: %v", f.URL, err)
+				failedDownloads.Add(1)
+				return
+			}
+			result.Timings.Queue = seconds(queue)
+			if !f.Info.ModTime.IsZero() {
+				result.Timings.SincePublished = seconds(time.Since(f.Info.ModTime))
+			}
+			addTimings(result.Timings)
+			downloadJournal.finished(f, runManifest.record(f, result))
+			recordObtained(f)
+			downloadedFiles.Add(1)
+			fileDownloaded(f)
+			if !result.Cached {
+				bandwidthUsage.add(selectedModel.Name, result.CompressedSize)
+			}
+
+			if *verbose {
+				log.Printf("Downloaded and uncompressed: %s", f.LocalPath)
+			}
+		}(f)
+	}
+
+	wg.Wait()
+	waitForHooks()
+
+	if err := runManifest.save(); err != nil {
+		log.Printf("Warning: failed to save manifest: %v", err)
+	}
+}
+
+// progress tracks the number of files and bytes processed
+type progress struct {
+	totalFiles int64
+	totalBytes int64 // Sum of known remote sizes, 0 if sizes were not prefetched
+	doneFiles  atomic.Int64
+	doneBytes  atomic.Int64
+}
+
+// newProgress creates a progress tracker for a plan
+func newProgress(plan []*PlannedFile) *progress {
+	p := &progress{totalFiles: int64(len(plan))}
+	for _, f := range plan {
+		if f.RemoteSize > 0 {
+			p.totalBytes += f.RemoteSize
+		}
+	}
+	return p
+}
+
+// fileDone records a processed file and logs the progress in verbose mode
+func (p *progress) fileDone(f *PlannedFile) {
+	files := p.doneFiles.Add(1)
+	if f.RemoteSize > 0 {
+		p.doneBytes.Add(f.RemoteSize)
+	}
+
+	if !*verbose {
+		return
+	}
+	if p.totalBytes > 0 {
+		bytes := p.doneBytes.Load()
+		log.Printf("Progress: %d/%d files, %s/%s (%.0f%%)", files, p.totalFiles,
+			formatBytes(bytes), formatBytes(p.totalBytes), 100*float64(bytes)/float64(p.totalBytes))
+	} else {
+		log.Printf("Progress: %d/%d files", files, p.totalFiles)
+	}
+}
+
+// formatBytes formats a byte count using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// prefetchPlan runs parallel HEAD requests for all planned files and records
+// their sizes and modification times
+func prefetchPlan(plan []*PlannedFile) {
+	log.Printf("Prefetching sizes of %d files", len(plan))
+
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, *maxConcurrent)
+		client    = &http.Client{Timeout: 30 * time.Second, Transport: httpTransport()}
+	)
+
+	for _, f := range plan {
+		wg.Add(1)
+		go func(f *PlannedFile) {
+			defer wg.Done()
+			semaphore <- struct{}{}        // Acquire semaphore
+			defer func() { <-semaphore }() // Release semaphore
+
+			if err := headFile(client, f); err != nil && *verbose {
+				log.Printf("Warning: HEAD request for %s failed: %v", f.URL, err)
+			}
+		}(f)
+	}
+
+	wg.Wait()
+
+	var total int64
+	unknown := 0
+	for _, f := range plan {
+		if f.RemoteSize < 0 {
+			unknown++
+			continue
+		}
+		total += f.RemoteSize
+	}
+	log.Printf("Planned download size: %s in %d files", formatBytes(total), len(plan))
+	if unknown > 0 {
+		log.Printf("Warning: size of %d files could not be determined", unknown)
+	}
+}
+
+// headFile fetches the size and modification time of a planned file
+func headFile(client *http.Client, f *PlannedFile) error {
+	resp, err := client.Head(indexClient.HTTPURL(f.URL))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %s", resp.Status)
+	}
+
+	f.RemoteSize = resp.ContentLength
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if t, err := http.ParseTime(lm); err == nil {
+			f.LastModified = t
+		}
+	}
+	return nil
+}
+
+// checkDiskSpace fails if the output directory cannot hold the compressed size
+// of the plan, and warns if the uncompressed files are unlikely to fit
+func checkDiskSpace(plan []*PlannedFile) error {
+	free, err := freeDiskSpace(*outputDir)
+	if err != nil {
+		if *verbose {
+			log.Printf("Warning: cannot determine free disk space: %v", err)
+		}
+		return nil
+	}
+
+	var needed int64
+	for _, f := range plan {
+		if f.RemoteSize > 0 {
+			needed += f.RemoteSize
+		}
+	}
+
+	if uint64(needed) > free {
+		return fmt.Errorf("not enough disk space in %s: %s needed, %s available",
+			*outputDir, formatBytes(needed), formatBytes(int64(free)))
+	}
+
+	// bzip2 typically shrinks GRIB2 data to a third or less
+	if uint64(needed)*3 > free {
+		log.Printf("Warning: uncompressed files may not fit in %s: %s compressed, %s available",
+			*outputDir, formatBytes(needed), formatBytes(int64(free)))
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// probeCheck is a single step of the connectivity self-test
+type probeCheck struct {
+	name string
+	run  func(state *probeState) (string, error)
+}
+
+// probeState carries results between probe steps
+type probeState struct {
+	host       string
+	scheme     string
+	runs       []ModelRun
+	sampleURL  string
+	sampleFile string
+}
+
+// runProbe checks DNS, TLS, listing, a small download, decompression and
+// write permissions for the selected model and prints a diagnosis. It returns
+// the number of failed checks.
+func runProbe() int {
+	u, err := url.Parse(indexClient.HTTPURL(selectedModel.BaseURL))
+	if err != nil {
+		fmt.Printf("FAIL  base URL %s: %v\n", selectedModel.BaseURL, err)
+		return 1
+	}
+	state := &probeState{host: u.Hostname(), scheme: u.Scheme}
+
+	checks := []probeCheck{
+		{"write permissions", probeWritePermissions},
+		{"DNS", probeDNS},
+		{"TLS", probeTLS},
+		{"listing", probeListing},
+		{"download", probeDownload},
+		{"decompression", probeDecompression},
+	}
+
+	fmt.Printf("Probing %s for model %s\n", selectedModel.BaseURL, selectedModel.Name)
+	failed := 0
+	skipRest := false
+	for _, check := range checks {
+		if skipRest {
+			fmt.Printf("SKIP  %-18s (depends on a failed check)\n", check.name)
+			continue
+		}
+
+		start := time.Now()
+		detail, err := check.run(state)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			fmt.Printf("FAIL  %-18s %v\n", check.name, err)
+			failed++
+			// Later network checks cannot succeed once connectivity has failed
+			skipRest = check.name != "write permissions"
+			continue
+		}
+		fmt.Printf("OK    %-18s %s (%s)\n", check.name, detail, elapsed)
+	}
+
+	if state.sampleFile != "" {
+		os.Remove(state.sampleFile)
+	}
+
+	if failed == 0 {
+		fmt.Println("Diagnosis: all checks passed")
+	} else {
+		fmt.Printf("Diagnosis: %d check(s) failed\n", failed)
+	}
+	return failed
+}
+
+// probeWritePermissions creates and removes a file in the output directory
+func probeWritePermissions(state *probeState) (string, error) {
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		return "", fmt.Errorf("cannot create %s: %v", *outputDir, err)
+	}
+	f, err := os.CreateTemp(*outputDir, ".probe-*")
+	if err != nil {
+		return "", fmt.Errorf("cannot write to %s: %v", *outputDir, err)
+	}
+	name := f.Name()
+	f.Close()
+	if err := os.Remove(name); err != nil {
+		return "", fmt.Errorf("cannot remove files in %s: %v", *outputDir, err)
+	}
+
+	detail := *outputDir + " is writable"
+	if free, err := freeDiskSpace(*outputDir); err == nil {
+		detail += fmt.Sprintf(", %s free", formatBytes(int64(free)))
+	}
+	return detail, nil
+}
+
+// probeDNS resolves the host of the base URL
+func probeDNS(state *probeState) (string, error) {
+	addrs, err := net.LookupHost(state.host)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s resolves to %s", state.host, strings.Join(addrs, ", ")), nil
+}
+
+// probeTLS performs a TLS handshake with the host of the base URL
+func probeTLS(state *probeState) (string, error) {
+	if state.scheme != "https" {
+		return "not used for " + state.scheme, nil
+	}
+
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(state.host, "443"), &tls.Config{ServerName: state.host})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	cs := conn.ConnectionState()
+	detail := tls.VersionName(cs.Version)
+	if len(cs.PeerCertificates) > 0 {
+		cert := cs.PeerCertificates[0]
+		detail += fmt.Sprintf(", certificate valid until %s", cert.NotAfter.Format("2006-01-02"))
+	}
+	return detail, nil
+}
+
+// probeListing lists the model runs
+func probeListing(state *probeState) (string, error) {
+	runs, err := getAvailableModelRuns()
+	if err != nil {
+		return "", err
+	}
+	if len(runs) == 0 {
+		return "", fmt.Errorf("no model runs found in %s", selectedModel.BaseURL)
+	}
+	sortRunsNewestFirst(runs)
+	state.runs = runs
+	return fmt.Sprintf("%d runs, latest %s", len(runs), runs[0].Time), nil
+}
+
+// probeDownload downloads a single GRIB file of the latest run
+func probeDownload(state *probeState) (string, error) {
+	params, err := getAvailableParameters(state.runs[0])
+	if err != nil {
+		return "", err
+	}
+
+	for _, param := range params {
+		files, err := getGribFiles(param)
+		if err != nil || len(files) == 0 {
+			continue
+		}
+
+		f, err := os.CreateTemp(*outputDir, ".probe-*.bz2")
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+		state.sampleFile = f.Name()
+		state.sampleURL = files[0].URL
+
+		start := time.Now()
+		if err := downloadFile(state.sampleURL, state.sampleFile); err != nil {
+			return "", fmt.Errorf("%s: %v", state.sampleURL, err)
+		}
+		info, err := os.Stat(state.sampleFile)
+		if err != nil {
+			return "", err
+		}
+		rate := float64(info.Size()) / time.Since(start).Seconds()
+		return fmt.Sprintf("%s (%s, %s/s)", files[0].Name, formatBytes(info.Size()), formatBytes(int64(rate))), nil
+	}
+
+	return "", fmt.Errorf("no GRIB files found in run %s", state.runs[0].Time)
+}
+
+// probeDecompression decompresses the downloaded sample file, reads a run
+// archive, or checks the GRIB, NetCDF or KMZ header of files published uncompressed
+func probeDecompression(state *probeState) (string, error) {
+	f, err := os.Open(state.sampleFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(state.sampleURL, ".kmz") {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(f, header); err != nil || string(header) != "PK\x03\x04" {
+			return "", fmt.Errorf("%s: not a KMZ file", filepath.Base(state.sampleURL))
+		}
+		return "KMZ file", nil
+	}
+	if strings.HasSuffix(state.sampleURL, ".nc") {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(f, header); err != nil ||
+			(string(header[:3]) != "CDF" && string(header) != "\x89HDF") {
+			return "", fmt.Errorf("%s: not a NetCDF file", filepath.Base(state.sampleURL))
+		}
+		return "NetCDF file", nil
+	}
+	if strings.HasSuffix(state.sampleURL, ".tar") {
+		archive := tar.NewReader(f)
+		members := 0
+		for {
+			if _, err := archive.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return "", fmt.Errorf("%s: %v", filepath.Base(state.sampleURL), err)
+			}
+			members++
+		}
+		return fmt.Sprintf("tar archive with %d files", members), nil
+	}
+	if uncompressedName(state.sampleURL) == state.sampleURL {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(f, header); err != nil || string(header) != "GRIB" {
+			return "", fmt.Errorf("%s: not a GRIB file", filepath.Base(state.sampleURL))
+		}
+		return "uncompressed GRIB file", nil
+	}
+
+	reader, err := newDecompressor(state.sampleURL, f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", filepath.Base(state.sampleURL), err)
+	}
+	n, err := io.Copy(io.Discard, reader)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", filepath.Base(state.sampleURL), err)
+	}
+	return fmt.Sprintf("%s uncompressed", formatBytes(n)), nil
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// processAlive cannot check other processes on this platform, so locks are
+// assumed to be held until removed manually
+func processAlive(pid int) bool {
+	return true
+}
+
+// shareJobSlots cannot pass file descriptors to child processes on this
+// platform, so each child process keeps its own download limit
+func shareJobSlots(cmd *exec.Cmd, r, w *os.File) bool {
+	return false
+}
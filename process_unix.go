@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// shareJobSlots passes the shared download slots of runChildren to a
+// child process as its file descriptors 3 and 4
+func shareJobSlots(cmd *exec.Cmd, r, w *os.File) bool {
+	cmd.ExtraFiles = []*os.File{r, w}
+	cmd.Env = append(cmd.Environ(), jobSlotsEnv+"=3,4")
+	return true
+}
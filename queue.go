@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// queueFileName keeps the planned downloads of a run in the run directory
+// while they are being downloaded, so that an interrupted invocation can be
+// resumed without listing and checking the files again
+const queueFileName = ".queue.json"
+
+// queueJournalFileName lists the files of the queue finished so far
+const queueJournalFileName = ".queue.done"
+
+// DownloadQueue is the plan of a run being downloaded, in download order
+type DownloadQueue struct {
+	ReferenceTime time.Time      `json:"reference_time"`
+	Request       RunRequest     `json:"request"`
+	Args          []string       `json:"args"`    // Command line of the invocation that planned the downloads
+	Created       time.Time      `json:"created"` // Time the downloads were planned
+	Files         []*PlannedFile `json:"files"`
+}
+
+// queueRecord is a line of the queue journal
+type queueRecord struct {
+	Path  string         `json:"path"`            // Local path of the finished file
+	Entry *ManifestEntry `json:"entry,omitempty"` // Manifest entry of a downloaded file
+}
+
+// queueJournal appends the files finished to the journal of the queue
+type queueJournal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// downloadJournal is the journal of the queue being downloaded, or nil
+var downloadJournal *queueJournal
+
+// saveQueue writes the plan of a run as its download queue and starts an
+// empty journal for it
+func saveQueue(runDir string, run ModelRun, request RunRequest, plan []*PlannedFile) {
+	ordered := slices.Clone(plan)
+	slices.SortStableFunc(ordered, func(a, b *PlannedFile) int {
+		switch {
+		case a.Info.Less(b.Info):
+			return -1
+		case b.Info.Less(a.Info):
+			return 1
+		}
+		return 0
+	})
+	queue := DownloadQueue{
+		ReferenceTime: run.ReferenceTime,
+		Request:       request,
+		Args:          os.Args[1:],
+		Created:       time.Now().UTC(),
+		Files:         ordered,
+	}
+	data, err := json.Marshal(queue)
+	if err != nil {
+		log.Printf("Warning: could not save download queue: %v", err)
+		return
+	}
+
+	path := filepath.Join(runDir, queueFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Warning: could not save download queue: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("Warning: could not save download queue: %v", err)
+		return
+	}
+	if err := startJournal(runDir, os.O_TRUNC); err != nil {
+		log.Printf("Warning: could not save download queue: %v", err)
+		os.Remove(path)
+	}
+}
+
+// startJournal opens the journal of the queue for appending
+func startJournal(runDir string, flag int) error {
+	f, err := os.OpenFile(filepath.Join(runDir, queueJournalFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND|flag, 0644)
+	if err != nil {
+		return err
+	}
+	downloadJournal = &queueJournal{file: f}
+	return nil
+}
+
+// resumeQueue returns the download queue left behind by an interrupted
+// invocation with the same command line for the same run, and the files of
+// it finished already by their local paths. It returns nil if there is no
+// such queue.
+func resumeQueue(runDir string, run ModelRun, request RunRequest) ([]*PlannedFile, map[string]*queueRecord) {
+	data, err := os.ReadFile(filepath.Join(runDir, queueFileName))
+	if err != nil {
+		return nil, nil
+	}
+	var queue DownloadQueue
+	if err := json.Unmarshal(data, &queue); err != nil {
+		log.Printf("Warning: ignoring unreadable download queue: %v", err)
+		return nil, nil
+	}
+	if !queue.ReferenceTime.Equal(run.ReferenceTime) || !reflect.DeepEqual(queue.Request, request) || !slices.Equal(queue.Args, os.Args[1:]) {
+		if *verbose {
+			log.Printf("Ignoring the download queue of %s left by another invocation", formatUTC(queue.ReferenceTime))
+		}
+		return nil, nil
+	}
+
+	finished := make(map[string]*queueRecord)
+	if f, err := os.Open(filepath.Join(runDir, queueJournalFileName)); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var record queueRecord
+			// A line cut off by the interruption is ignored
+			if json.Unmarshal(scanner.Bytes(), &record) == nil {
+				finished[record.Path] = &record
+			}
+		}
+		f.Close()
+	}
+	if err := startJournal(runDir, 0); err != nil {
+		log.Printf("Warning: cannot resume download queue: %v", err)
+		return nil, nil
+	}
+
+	log.Printf("Resuming the download queue planned at %s: %d of %d files remaining",
+		formatUTC(queue.Created), len(queue.Files)-len(finished), len(queue.Files))
+	return queue.Files, finished
+}
+
+// finished records a file of the queue that was downloaded or kept, with
+// its manifest entry if it was downloaded
+func (j *queueJournal) finished(f *PlannedFile, entry *ManifestEntry) {
+	if j == nil {
+		return
+	}
+	data, err := json.Marshal(queueRecord{Path: f.LocalPath, Entry: entry})
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Warning: could not update download queue: %v", err)
+	}
+}
+
+// removeQueue removes the download queue of a run once its downloads are
+// done
+func removeQueue(runDir string) {
+	if downloadJournal != nil {
+		downloadJournal.file.Close()
+		downloadJournal = nil
+	}
+	os.Remove(filepath.Join(runDir, queueFileName))
+	os.Remove(filepath.Join(runDir, queueJournalFileName))
+}
+
+// resumeFinished accounts for the files of a resumed queue finished by the
+// interrupted invocation, restoring their manifest entries, and returns the
+// files that remain to be downloaded, in order. The sizes and modification
+// times found by -prefetch for the remaining files may have changed since,
+// e.g. for a zero-byte placeholder that was filled, so they are forgotten.
+func resumeFinished(plan []*PlannedFile, finished map[string]*queueRecord) []*PlannedFile {
+	var remaining []*PlannedFile
+	for _, f := range plan {
+		record := finished[f.LocalPath]
+		if record == nil {
+			f.RemoteSize = -1
+			f.LastModified = time.Time{}
+			remaining = append(remaining, f)
+			continue
+		}
+		if record.Entry != nil {
+			for _, name := range record.Entry.Levels {
+				f.Outputs = append(f.Outputs, filepath.Join(filepath.Dir(f.LocalPath), name))
+			}
+			slices.Sort(f.Outputs)
+		}
+		runManifest.restore(f, record.Entry)
+		recordObtained(f)
+		leadtimes.resumed(f)
+	}
+	return remaining
+}
+
+// replayListing passes the files of a resumed queue to the lead time
+// tracker as if they had been listed
+func replayListing(plan []*PlannedFile) {
+	files := make(map[string][]index.File)
+	for _, f := range plan {
+		files[f.Param] = append(files[f.Param], f.Info)
+	}
+	for param, listed := range files {
+		leadtimes.listed(param, listed)
+	}
+}
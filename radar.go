@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// radarProduct is a radar composite product published as a parameter
+type radarProduct struct {
+	Name string // Parameter name, e.g. "rw"
+	Path string // Directory below the base URL, e.g. "radolan/rw/"
+}
+
+// radarProducts are the composite products of the DWD weather/radar tree
+var radarProducts = []radarProduct{
+	{Name: "rw", Path: "radolan/rw/"},   // RADOLAN hourly precipitation, gzipped RADOLAN binary
+	{Name: "sf", Path: "radolan/sf/"},   // RADOLAN 24 h precipitation, gzipped RADOLAN binary
+	{Name: "rv", Path: "composite/rv/"}, // Precipitation nowcast to 2 h, bzip2 compressed tar of RADOLAN binaries
+}
+
+// radarFilePattern matches composite files, e.g.
+// "raa01-rw_10000-2503120650-dwd---bin.gz" or "DE1200_RV2503120650.tar.bz2",
+// capturing the product time. The LATEST files are copies and do not match.
+var radarFilePattern = regexp.MustCompile(`^(?:raa01-[a-z]+_10000-|DE1200_[A-Z]+)(\d{10})(?:-dwd---bin)?(?:\.tar)?(?:\.gz|\.bz2)?$`)
+
+// radarSource reads the radar composites of the DWD open data server. The
+// products are observations rather than forecasts, so runs are the hours of
+// the product times: a run directory holds all composites of that hour,
+// e.g. the twelve 5-minute nowcasts of rv. The directories keep about two
+// days of composites, of which only the newest of each hour are of interest.
+type radarSource struct {
+	httpFetcher        // Also lists the directory indexes with its client
+	baseURL     string // URL of the radar tree, e.g. ".../weather/radar/"
+}
+
+// newRadarSource creates a source for the composites under baseURL
+func newRadarSource(client *index.Client, baseURL string) *radarSource {
+	return &radarSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+	}
+}
+
+// ListRuns returns the hours of the composites of the first product
+func (s *radarSource) ListRuns() ([]ModelRun, error) {
+	files, err := s.listFiles(radarProducts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	newest := make(map[time.Time]time.Time)
+	for _, f := range files {
+		hour := f.ReferenceTime.Truncate(time.Hour)
+		if newest[hour].Before(f.ModTime) {
+			newest[hour] = f.ModTime
+		}
+	}
+
+	var runs []ModelRun
+	for hour, timestamp := range newest {
+		if timestamp.IsZero() {
+			timestamp = hour
+		}
+		runs = append(runs, ModelRun{
+			Time:          hour.Format("15"),
+			URL:           s.baseURL,
+			Timestamp:     timestamp,
+			ReferenceTime: hour,
+		})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ReferenceTime.Before(runs[j].ReferenceTime) })
+	return runs, nil
+}
+
+// ListParameters returns the products
+func (s *radarSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	var params []Parameter
+	for _, p := range radarProducts {
+		params = append(params, Parameter{Name: p.Name, URL: s.baseURL + p.Path, Run: run.Time})
+	}
+	return params, nil
+}
+
+// ListFiles returns the composites of a product from the newest day of the
+// parameter's run hour
+func (s *radarSource) ListFiles(param Parameter) ([]index.File, error) {
+	var product radarProduct
+	for _, p := range radarProducts {
+		if p.Name == param.Name {
+			product = p
+		}
+	}
+	if product.Path == "" {
+		return nil, fmt.Errorf("unknown radar product %s", param.Name)
+	}
+
+	files, err := s.listFiles(product)
+	if err != nil {
+		return nil, err
+	}
+
+	var newest time.Time
+	for _, f := range files {
+		hour := f.ReferenceTime.Truncate(time.Hour)
+		if hour.Format("15") == param.Run && hour.After(newest) {
+			newest = hour
+		}
+	}
+
+	var selected []index.File
+	for _, f := range files {
+		if f.ReferenceTime.Truncate(time.Hour).Equal(newest) {
+			selected = append(selected, f)
+		}
+	}
+	return selected, nil
+}
+
+// listFiles returns the composites in the directory of a product
+func (s *radarSource) listFiles(product radarProduct) ([]index.File, error) {
+	entries, err := s.client.List(s.baseURL + product.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []index.File
+	for _, e := range entries {
+		match := radarFilePattern.FindStringSubmatch(e.Name)
+		if e.Dir || match == nil {
+			continue
+		}
+		ref, err := time.ParseInLocation("0601021504", match[1], time.UTC)
+		if err != nil {
+			continue
+		}
+		files = append(files, index.File{
+			Name:          e.Name,
+			URL:           e.URL,
+			ModTime:       e.ModTime,
+			Size:          e.Size,
+			ReferenceTime: ref,
+			Leadtime:      -1,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "runtime"
+
+// residentSetSize approximates the resident set size of the process by the
+// memory obtained from the operating system by the Go runtime
+func residentSetSize() (int64, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.Sys), nil
+}
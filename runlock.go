@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runLockFileName is the lock file created in a run directory while it is being written
+const runLockFileName = ".lock"
+
+// runLockInfo is stored in the lock file to identify its owner
+type runLockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+}
+
+// RunLock is an exclusive lock on a single run directory, so that different
+// invocations can work on different runs of the same output tree at once
+type RunLock struct {
+	path string
+}
+
+// acquireRunLock locks a run directory, removing stale locks left behind by
+// processes on this host that no longer exist
+func acquireRunLock(runDir string) (*RunLock, error) {
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %v", err)
+	}
+
+	return createLockFile(filepath.Join(runDir, runLockFileName), "run directory "+runDir)
+}
+
+// createLockFile creates a lock file exclusively, removing a stale one left
+// behind by a process on this host that no longer exists. what names the
+// locked resource in errors.
+func createLockFile(path, what string) (*RunLock, error) {
+	hostname, _ := os.Hostname()
+	info := runLockInfo{PID: os.Getpid(), Hostname: hostname, Started: time.Now().UTC()}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file %s: %v", path, err)
+			}
+			return &RunLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file %s: %v", path, err)
+		}
+
+		owner, err := readRunLock(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s is locked (%v)", what, err)
+		}
+		if owner.Hostname != hostname || processAlive(owner.PID) {
+			return nil, fmt.Errorf("%s is locked by process %d on %s since %s",
+				what, owner.PID, owner.Hostname, owner.Started.Format(time.RFC3339))
+		}
+
+		log.Printf("Removing stale lock of process %d on %s", owner.PID, what)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale lock %s: %v", path, err)
+		}
+	}
+
+	return nil, fmt.Errorf("could not lock %s", what)
+}
+
+// readRunLock reads the owner information of a lock file
+func readRunLock(path string) (runLockInfo, error) {
+	var info runLockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("unreadable lock file %s: %v", path, err)
+	}
+	return info, nil
+}
+
+// release removes the lock file
+func (l *RunLock) release() {
+	if l == nil {
+		return
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to remove lock file %s: %v", l.path, err)
+	}
+}
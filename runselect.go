@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runSelection is a run selected with -run, by hour or by reference time
+type runSelection struct {
+	Hour          string    // Run hour, e.g. "06"
+	ReferenceTime time.Time // Full run time, zero if only the hour was given
+}
+
+// parseRunSelection parses -run, a run hour "HH" or a full run time
+// "YYYYMMDDHH" in UTC
+func parseRunSelection(spec string) (runSelection, error) {
+	switch len(spec) {
+	case 2:
+		if h, err := strconv.Atoi(spec); err == nil && h >= 0 && h <= 23 {
+			return runSelection{Hour: spec}, nil
+		}
+	case 10:
+		if ref, err := time.ParseInLocation("2006010215", spec, time.UTC); err == nil {
+			return runSelection{Hour: spec[8:], ReferenceTime: ref}, nil
+		}
+	}
+	return runSelection{}, fmt.Errorf("%q is neither a run hour HH nor a run time YYYYMMDDHH", spec)
+}
+
+// parseRunSelections parses a comma-separated list of -run values, e.g.
+// "00,12"
+func parseRunSelections(spec string) ([]runSelection, error) {
+	var selections []runSelection
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		selection, err := parseRunSelection(part)
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("no run given")
+	}
+	return selections, nil
+}
+
+// matches reports whether a listed run is the selected one. A full run time
+// has to match the reference time of the run, which is derived from the
+// listing, so that an 18 UTC run of the day before is not taken for
+// today's shortly after midnight.
+func (s runSelection) matches(run ModelRun) bool {
+	if run.Time != s.Hour {
+		return false
+	}
+	return s.ReferenceTime.IsZero() || run.ReferenceTime.Equal(s.ReferenceTime)
+}
+
+// describeRuns lists runs for an error message, with their reference times
+// when a full run time was asked for
+func (s runSelection) describeRuns(runs []ModelRun) []string {
+	if s.ReferenceTime.IsZero() {
+		return getRunTimes(runs)
+	}
+	var described []string
+	for _, run := range runs {
+		described = append(described, fmt.Sprintf("%s (%s)", run.Time, formatUTC(run.ReferenceTime)))
+	}
+	return described
+}
+
+// newestRuns returns the newest n of the runs, sorted newest first. Runs
+// are kept in directories named after the run hour, so of runs of the same
+// hour only the newest is taken.
+func newestRuns(runs []ModelRun, n int) []ModelRun {
+	var newest []ModelRun
+	taken := make(map[string]bool)
+	for _, run := range runs {
+		if len(newest) == n {
+			break
+		}
+		if taken[run.Time] {
+			log.Printf("Warning: skipping run %s (%s), the newer run of that hour uses its directory",
+				run.Time, formatUTC(run.ReferenceTime))
+			continue
+		}
+		taken[run.Time] = true
+		newest = append(newest, run)
+	}
+	if len(newest) < n {
+		log.Printf("Warning: only %d of the %d runs requested with -last-runs are available", len(newest), n)
+	}
+	return newest
+}
+
+// selectedRuns returns the runs selected with a -run list, or an error
+// naming a run that is not available. Runs sharing a run directory cannot
+// be downloaded together.
+func selectedRuns(runs []ModelRun, selections []runSelection, spec string) ([]ModelRun, error) {
+	var selected []ModelRun
+	taken := make(map[string]bool)
+	for _, selection := range selections {
+		found := false
+		for _, run := range runs {
+			if !selection.matches(run) {
+				continue
+			}
+			if taken[run.Time] {
+				return nil, fmt.Errorf("runs %s share the run directory %s", spec, run.Time)
+			}
+			taken[run.Time] = true
+			selected = append(selected, run)
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("model run %s not found. Available runs: %v", formatRunSelection(selection), selection.describeRuns(runs))
+		}
+	}
+	return selected, nil
+}
+
+// runProcesses returns the child processes downloading runs
+func runProcesses(runs []ModelRun) []childProcess {
+	var children []childProcess
+	for _, run := range runs {
+		children = append(children, runChild(run))
+	}
+	return children
+}
+
+// runChild returns the child process downloading a run, selected by its run
+// time if known
+func runChild(run ModelRun) childProcess {
+	spec := run.Time
+	if !run.ReferenceTime.IsZero() {
+		spec = run.ReferenceTime.Format("2006010215")
+	}
+	return childProcess{
+		name: spec,
+		args: []string{"-last-runs=0", "-latest=false", "-run", spec},
+	}
+}
+
+// formatRunSelection formats a run selection as given with -run
+func formatRunSelection(s runSelection) string {
+	if s.ReferenceTime.IsZero() {
+		return s.Hour
+	}
+	return s.ReferenceTime.Format("2006010215")
+}
+
+// latestCompleteRun returns the newest of the runs, sorted newest first,
+// whose wanted parameters all have the final forecast step expected from
+// the model schedule, limited by -steps and -maxhour. The newest run is
+// often still being uploaded, so this avoids partial downloads. Models
+// without a schedule cannot be checked and get their newest run.
+func latestCompleteRun(runs []ModelRun, requested []string) (ModelRun, error) {
+	for _, run := range runs {
+		expected, ok := selectedModel.expectedSteps(run.Time)
+		if !ok || len(expected) == 0 {
+			log.Printf("Warning: no publication schedule for run %s of model %s, taking the latest run", run.Time, selectedModel.Name)
+			return runs[0], nil
+		}
+
+		available, err := getAvailableParameters(run)
+		if err != nil {
+			return ModelRun{}, fmt.Errorf("failed to get available parameters of run %s: %v", run.Time, err)
+		}
+		missing, final, err := paramMissingSteps(wantedParams(requested, available), run.Time, true)
+		if err != nil {
+			return ModelRun{}, err
+		}
+		if missing == "" {
+			return run, nil
+		}
+		log.Printf("Run %s (%s) is incomplete: parameter %s has no step %s yet",
+			run.Time, formatUTC(run.ReferenceTime), missing, formatStep(final[0]))
+	}
+	return ModelRun{}, fmt.Errorf("none of the %d available runs is complete", len(runs))
+}
+
+// wantedParams returns the available parameters that -params and
+// -exclude-params select, all if none were requested, without the warnings
+// of selectParams
+func wantedParams(requested []string, available []Parameter) []Parameter {
+	var wanted []Parameter
+	for _, p := range available {
+		if isExcludedParam(p.Name) {
+			continue
+		}
+		if len(requested) == 0 {
+			wanted = append(wanted, p)
+			continue
+		}
+		for _, name := range requested {
+			if strings.EqualFold(p.Name, name) {
+				wanted = append(wanted, p)
+				break
+			}
+		}
+	}
+	return wanted
+}
+
+// paramMissingSteps returns the first parameter with forecast steps whose
+// selected files lack some of the steps expected for the run hour, or only
+// the final one with finalOnly, with those steps, or "" if all are complete.
+// Parameters without forecast steps, such as time-invariant fields, are not
+// checked.
+func paramMissingSteps(params []Parameter, runHour string, finalOnly bool) (string, []time.Duration, error) {
+	for _, param := range params {
+		expected, ok := selectedModel.paramExpectedSteps(runHour, param.Name)
+		if !ok || len(expected) == 0 {
+			continue
+		}
+		if finalOnly {
+			expected = expected[len(expected)-1:]
+		}
+		files, err := getGribFiles(param)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list parameter %s: %v", param.Name, err)
+		}
+		var published []time.Duration
+		for _, f := range filterByLevels(param.Name, filterByLevelType(filterByGrid(files))) {
+			if f.HasLeadtime() && !containsStep(published, f.Leadtime) {
+				published = append(published, f.Leadtime)
+			}
+		}
+		if len(published) == 0 {
+			continue
+		}
+		sort.Slice(published, func(i, j int) bool { return published[i] < published[j] })
+		if missing := missingSteps(expected, published); len(missing) > 0 {
+			return param.Name, missing, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// incompleteRunReason checks a run for the gaps -fallback-previous avoids:
+// requested parameters it does not have yet and, if the model has a
+// schedule, expected steps missing from the wanted parameters. It returns
+// "" for a complete run.
+func incompleteRunReason(run ModelRun, requested []string) (string, error) {
+	available, err := getAvailableParameters(run)
+	if err != nil {
+		return "", fmt.Errorf("failed to get available parameters of run %s: %v", run.Time, err)
+	}
+	wanted := wantedParams(requested, available)
+	for _, name := range requested {
+		found := false
+		for _, p := range wanted {
+			found = found || strings.EqualFold(p.Name, name)
+		}
+		if !found && !isExcludedParam(name) {
+			return fmt.Sprintf("parameter %s is missing", name), nil
+		}
+	}
+
+	param, missing, err := paramMissingSteps(wanted, run.Time, false)
+	if err != nil || param == "" {
+		return "", err
+	}
+	return fmt.Sprintf("parameter %s is missing steps %s", param, formatStepList(missing)), nil
+}
+
+// previousRunIfIncomplete returns the previous run instead of the newest
+// one for -fallback-previous if the newest run has gaps and the previous
+// run has none. Otherwise the newest run is kept with its gaps.
+func previousRunIfIncomplete(runs []ModelRun, requested []string) (ModelRun, error) {
+	reason, err := incompleteRunReason(runs[0], requested)
+	if err != nil || reason == "" {
+		return runs[0], err
+	}
+	if len(runs) < 2 {
+		log.Printf("Warning: run %s is incomplete (%s) and there is no previous run to fall back to", runs[0].Time, reason)
+		return runs[0], nil
+	}
+	previous, err := incompleteRunReason(runs[1], requested)
+	if err != nil {
+		return ModelRun{}, err
+	}
+	if previous != "" {
+		log.Printf("Warning: run %s is incomplete (%s), and so is the previous run %s (%s), keeping run %s",
+			runs[0].Time, reason, runs[1].Time, previous, runs[0].Time)
+		return runs[0], nil
+	}
+	log.Printf("Run %s is incomplete (%s), falling back to the previous run %s", runs[0].Time, reason, runs[1].Time)
+	return runs[1], nil
+}
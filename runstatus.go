@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// runStatusFileName returns the name of the document describing the newest
+// complete run of a model, stored in the output directory
+func runStatusFileName(model string) string {
+	return "latest-" + model + ".json"
+}
+
+// RunStatus describes the newest complete run of a model for downstream
+// schedulers, which can poll it cheaply instead of inspecting the run
+// directories
+type RunStatus struct {
+	Model         string    `json:"model"`
+	Run           string    `json:"run"`            // Run hour, also the name of the run directory
+	ReferenceTime time.Time `json:"reference_time"` // Nominal run time in UTC
+	Completed     time.Time `json:"completed"`      // Time the run was found complete
+	Files         int       `json:"files"`          // Number of files downloaded or kept
+	Parameters    []string  `json:"parameters"`     // Parameters of the run that were downloaded
+	Downloader    BuildInfo `json:"downloader"`
+}
+
+// saveRunStatus records a complete run in the status document of the model,
+// unless the document already describes a newer run
+func saveRunStatus(run ModelRun) {
+	path := filepath.Join(*outputDir, runStatusFileName(selectedModel.Name))
+
+	if data, err := os.ReadFile(path); err == nil {
+		var previous RunStatus
+		if err := json.Unmarshal(data, &previous); err == nil && previous.ReferenceTime.After(run.ReferenceTime) {
+			log.Printf("Warning: %s describes the newer run of %s, not updating it",
+				runStatusFileName(selectedModel.Name), formatUTC(previous.ReferenceTime))
+			return
+		}
+	}
+
+	data, err := json.MarshalIndent(newRunStatus(run), "", "  ")
+	if err != nil {
+		log.Printf("Warning: could not save run status: %v", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Warning: could not save run status: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("Warning: could not save run status: %v", err)
+	}
+}
+
+// newRunStatus describes a run completed by this invocation
+func newRunStatus(run ModelRun) RunStatus {
+	status := RunStatus{
+		Model:         selectedModel.Name,
+		Run:           run.Time,
+		ReferenceTime: run.ReferenceTime,
+		Completed:     time.Now().UTC(),
+		Downloader:    currentBuild(),
+	}
+	obtainedFiles.mu.Lock()
+	for param, count := range obtainedFiles.counts {
+		status.Files += count
+		status.Parameters = append(status.Parameters, param)
+	}
+	obtainedFiles.mu.Unlock()
+	sort.Strings(status.Parameters)
+	return status
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sanitisation schemes for output file names, for destinations with naming
+// restrictions. Adding the suffix ":escape" percent-encodes disallowed
+// characters instead of replacing them with "_", so that the original name
+// can be recovered from the file name alone.
+const (
+	sanitizeNone     = "none"     // Keep the names as published
+	sanitizeWindows  = "windows"  // Characters, reserved names and trailing dots or spaces Windows shares reject
+	sanitizeS3       = "s3"       // Only the characters S3 documents as safe in object keys
+	sanitizePortable = "portable" // Only the POSIX portable file name characters A-Z a-z 0-9 . _ -
+
+	sanitizeEscapeSuffix = ":escape"
+)
+
+// windowsReservedNames are device names Windows does not allow as file
+// names, with or without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// validateSanitizeScheme checks the value of -sanitize
+func validateSanitizeScheme(scheme string) error {
+	switch strings.TrimSuffix(scheme, sanitizeEscapeSuffix) {
+	case sanitizeNone, sanitizeWindows, sanitizeS3, sanitizePortable:
+		if scheme == sanitizeNone+sanitizeEscapeSuffix {
+			return fmt.Errorf("scheme %s cannot be combined with %s", sanitizeNone, sanitizeEscapeSuffix)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown scheme '%s'. Valid values are: %s, %s, %s, %s, optionally followed by %s",
+		scheme, sanitizeNone, sanitizeWindows, sanitizeS3, sanitizePortable, sanitizeEscapeSuffix)
+}
+
+// sanitizeFilename applies a sanitisation scheme to an output file name
+func sanitizeFilename(name, scheme string) string {
+	base, escape := strings.CutSuffix(scheme, sanitizeEscapeSuffix)
+	if base == sanitizeNone {
+		return name
+	}
+
+	// Escaping must also encode the escape character itself to stay reversible
+	replace := func(c byte) string {
+		if escape {
+			return fmt.Sprintf("%%%02X", c)
+		}
+		return "_"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if filenameCharAllowed(c, base) && !(escape && c == '%') {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(replace(c))
+		}
+	}
+	sanitized := b.String()
+
+	if base == sanitizeWindows {
+		// Trailing dots and spaces are silently dropped by Windows
+		trimmed := strings.TrimRight(sanitized, ". ")
+		for i := len(trimmed); i < len(sanitized); i++ {
+			trimmed += replace(sanitized[i])
+		}
+		sanitized = trimmed
+
+		stem, _, _ := strings.Cut(sanitized, ".")
+		if windowsReservedNames[strings.ToUpper(stem)] {
+			if escape {
+				sanitized = replace(sanitized[0]) + sanitized[1:]
+			} else {
+				sanitized = "_" + sanitized
+			}
+		}
+	}
+	return sanitized
+}
+
+// filenameCharAllowed reports whether a byte of a file name may be kept
+// unchanged under a scheme
+func filenameCharAllowed(c byte, scheme string) bool {
+	alnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	switch scheme {
+	case sanitizeWindows:
+		return c >= 0x20 && c < 0x7f && !strings.ContainsRune(`<>:"/\|?*`, rune(c)) || c >= 0x80
+	case sanitizeS3:
+		return alnum || strings.ContainsRune("!-_.*'()", rune(c))
+	case sanitizePortable:
+		return alnum || strings.ContainsRune("._-", rune(c))
+	}
+	return true
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StepRange is a range of forecast steps with a fixed stride
+type StepRange struct {
+	From, To, Stride time.Duration
+}
+
+// hourSteps returns a range of whole-hour forecast steps
+func hourSteps(from, to, stride int) StepRange {
+	return StepRange{time.Duration(from) * time.Hour, time.Duration(to) * time.Hour, time.Duration(stride) * time.Hour}
+}
+
+// ScheduleEntry lists the forecast steps published for a set of run hours
+type ScheduleEntry struct {
+	RunHours []string
+	Steps    []StepRange
+	Params   []string // Parameters the entry is limited to, all others if empty
+}
+
+// steps returns all forecast steps of the entry
+func (e ScheduleEntry) steps() []time.Duration {
+	var steps []time.Duration
+	for _, r := range e.Steps {
+		for s := r.From; s <= r.To; s += r.Stride {
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
+
+// icon-eu publishes hourly steps to 78 h and 3-hourly steps to 120 h for the
+// main runs, and hourly steps to 30 h for the intermediate runs
+var iconEUSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "06", "12", "18"},
+		Steps:    []StepRange{hourSteps(0, 78, 1), hourSteps(81, 120, 3)},
+	},
+	{
+		RunHours: []string{"03", "09", "15", "21"},
+		Steps:    []StepRange{hourSteps(0, 30, 1)},
+	},
+}
+
+// icon publishes hourly steps to 78 h and 3-hourly steps to 180 h for the
+// 00 and 12 UTC runs, and to 120 h for the 06 and 18 UTC runs
+var iconGlobalSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "12"},
+		Steps:    []StepRange{hourSteps(0, 78, 1), hourSteps(81, 180, 3)},
+	},
+	{
+		RunHours: []string{"06", "18"},
+		Steps:    []StepRange{hourSteps(0, 78, 1), hourSteps(81, 120, 3)},
+	},
+}
+
+// icon-d2 runs every three hours with hourly steps to 48 h, except for the
+// 03 UTC run which ends at 45 h
+var iconD2Schedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "06", "09", "12", "15", "18", "21"},
+		Steps:    []StepRange{hourSteps(0, 48, 1)},
+	},
+	{
+		RunHours: []string{"03"},
+		Steps:    []StepRange{hourSteps(0, 45, 1)},
+	},
+}
+
+// gfs publishes hourly steps to 120 h and 3-hourly steps to 384 h for all
+// four runs
+var gfsSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "06", "12", "18"},
+		Steps:    []StepRange{hourSteps(0, 120, 1), hourSteps(123, 384, 3)},
+	},
+}
+
+// ifs publishes 3-hourly steps to 144 h and 6-hourly steps to 360 h for the
+// 00 and 12 UTC runs, and 3-hourly steps to 90 h for the 06 and 18 UTC runs
+var ifsSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "12"},
+		Steps:    []StepRange{hourSteps(0, 144, 3), hourSteps(150, 360, 6)},
+	},
+	{
+		RunHours: []string{"06", "18"},
+		Steps:    []StepRange{hourSteps(0, 90, 3)},
+	},
+}
+
+// nam publishes hourly steps to 36 h and 3-hourly steps to 84 h of the 12 km
+// products for all four runs. The nests are hourly to 60 h, except for the
+// fire weather nest, which ends at 36 h.
+var namSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "06", "12", "18"},
+		Steps:    []StepRange{hourSteps(0, 60, 1)},
+		Params:   []string{"conusnest.hiresf", "alaskanest.hiresf", "hawaiinest.hiresf", "priconest.hiresf"},
+	},
+	{
+		RunHours: []string{"00", "06", "12", "18"},
+		Steps:    []StepRange{hourSteps(0, 36, 1)},
+		Params:   []string{"firewxnest.hiresf"},
+	},
+	{
+		RunHours: []string{"00", "06", "12", "18"},
+		Steps:    []StepRange{hourSteps(0, 36, 1), hourSteps(39, 84, 3)},
+	},
+}
+
+// gdps publishes 3-hourly steps to 240 h for the 00 and 12 UTC runs
+var gdpsSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "12"},
+		Steps:    []StepRange{hourSteps(0, 240, 3)},
+	},
+}
+
+// hrdps publishes hourly steps to 48 h for all four runs
+var hrdpsSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "06", "12", "18"},
+		Steps:    []StepRange{hourSteps(0, 48, 1)},
+	},
+}
+
+// hrrr runs every hour with hourly steps to 18 h, extended to 48 h for the
+// 00, 06, 12 and 18 UTC runs
+var hrrrSchedule = []ScheduleEntry{
+	{
+		RunHours: []string{"00", "06", "12", "18"},
+		Steps:    []StepRange{hourSteps(0, 48, 1)},
+	},
+	{
+		RunHours: []string{"01", "02", "03", "04", "05", "07", "08", "09", "10", "11",
+			"13", "14", "15", "16", "17", "19", "20", "21", "22", "23"},
+		Steps: []StepRange{hourSteps(0, 18, 1)},
+	},
+}
+
+// expectedSteps returns the forecast steps the model publishes for a run hour,
+// limited to the steps selected by -steps and -maxhour. The second return value is false
+// if the model has no embedded schedule.
+func (m Model) expectedSteps(runHour string) ([]time.Duration, bool) {
+	return m.paramExpectedSteps(runHour, "")
+}
+
+// paramExpectedSteps returns the forecast steps the model publishes of a
+// parameter for a run hour, limited like expectedSteps
+func (m Model) paramExpectedSteps(runHour, param string) ([]time.Duration, bool) {
+	steps, ok := m.paramScheduledSteps(runHour, param)
+	if !ok {
+		return nil, false
+	}
+	return requestedSteps(steps), true
+}
+
+// scheduledSteps returns all forecast steps the model publishes for a run
+// hour. The second return value is false if the model has no embedded schedule.
+func (m Model) scheduledSteps(runHour string) ([]time.Duration, bool) {
+	return m.paramScheduledSteps(runHour, "")
+}
+
+// paramScheduledSteps returns all forecast steps the model publishes of a
+// parameter for a run hour, from the entry limited to the parameter if there
+// is one, or else from the entry for all parameters
+func (m Model) paramScheduledSteps(runHour, param string) ([]time.Duration, bool) {
+	var general *ScheduleEntry
+	for i, entry := range m.Schedule {
+		if !slices.Contains(entry.RunHours, runHour) {
+			continue
+		}
+		if len(entry.Params) == 0 {
+			if general == nil {
+				general = &m.Schedule[i]
+			}
+		} else if param != "" && slices.Contains(entry.Params, param) {
+			return entry.steps(), true
+		}
+	}
+	if general == nil {
+		return nil, false
+	}
+	return general.steps(), true
+}
+
+// missingSteps returns the expected steps absent from a sorted list of
+// published steps. Steps before the first published one are ignored because
+// accumulated and extreme value fields start at step 1.
+func missingSteps(expected, published []time.Duration) []time.Duration {
+	if len(published) == 0 {
+		return expected
+	}
+
+	var missing []time.Duration
+	for _, step := range expected {
+		if step < published[0] {
+			continue
+		}
+		if !containsStep(published, step) {
+			missing = append(missing, step)
+		}
+	}
+	return missing
+}
+
+// incompleteParameters checks the listed steps of each parameter against the
+// model schedule, logs the gaps and returns the parameters that are incomplete
+func incompleteParameters(params []Parameter, runHour string) []Parameter {
+	if _, ok := selectedModel.expectedSteps(runHour); !ok {
+		return nil
+	}
+
+	var incomplete []Parameter
+	for _, param := range params {
+		expected, _ := selectedModel.paramExpectedSteps(runHour, param.Name)
+		catalog.mu.Lock()
+		published, listed := catalog.Steps[param.Name]
+		catalog.mu.Unlock()
+
+		if hasNotYetAvailable(param.Name) {
+			log.Printf("Warning: parameter %s has zero-byte files that are not yet available", param.Name)
+			incomplete = append(incomplete, param)
+			continue
+		}
+
+		// Parameters without forecast steps, e.g. time-invariant fields, are not scheduled
+		if !listed || len(published) == 0 {
+			continue
+		}
+
+		missing := missingSteps(expected, published)
+		if len(missing) == 0 {
+			continue
+		}
+
+		log.Printf("Warning: parameter %s is missing %d of %d scheduled steps for run %s: %s",
+			param.Name, len(missing), len(expected), runHour, formatStepList(missing))
+		incomplete = append(incomplete, param)
+	}
+	return incomplete
+}
+
+// waitForScheduledSteps polls the parameters with missing scheduled steps
+// until they are complete or the -wait time has elapsed, downloading new
+// files as they appear. localPaths holds the local paths already in use.
+// The parameters still incomplete in the end are returned.
+func waitForScheduledSteps(params []Parameter, runHour string, plan []*PlannedFile, localPaths map[string]string) []Parameter {
+	if _, ok := selectedModel.expectedSteps(runHour); !ok {
+		log.Printf("Warning: no publication schedule for model %s, not waiting for missing steps", selectedModel.Name)
+		return nil
+	}
+
+	planned := make(map[string]bool)
+	for _, f := range plan {
+		planned[f.URL] = true
+	}
+
+	deadline := time.Now().Add(*waitFor)
+	incomplete := incompleteParameters(params, runHour)
+
+	for len(incomplete) > 0 {
+		if time.Now().Add(*waitInterval).After(deadline) {
+			log.Printf("Warning: gave up waiting for %d incomplete parameters after %s", len(incomplete), *waitFor)
+			return incomplete
+		}
+
+		log.Printf("Waiting %s for %d incomplete parameters", *waitInterval, len(incomplete))
+		time.Sleep(*waitInterval)
+
+		var newFiles []*PlannedFile
+		for _, f := range planDownloads(incomplete, runHour) {
+			if !planned[f.URL] || takeNotYetAvailable(f.URL) {
+				planned[f.URL] = true
+				newFiles = append(newFiles, f)
+			}
+		}
+
+		if err := resolveCollisions(newFiles, localPaths); err != nil {
+			log.Printf("Error: %v", err)
+			return incomplete
+		}
+
+		if len(newFiles) > 0 {
+			log.Printf("Found %d newly published files", len(newFiles))
+			if *prefetch {
+				prefetchPlan(newFiles)
+			}
+			newFiles = applyBudgets(newFiles)
+			downloadPlan(newFiles)
+			if runExpiry.isExpired() {
+				return incomplete
+			}
+		}
+
+		incomplete = incompleteParameters(incomplete, runHour)
+	}
+	return nil
+}
+
+// formatStepList formats a list of steps compactly, joining hourly runs of
+// steps, e.g. "79h-84h, 87h, 90h, 90h15m"
+func formatStepList(steps []time.Duration) string {
+	sort.Slice(steps, func(i, j int) bool { return steps[i] < steps[j] })
+
+	var parts []string
+	for i := 0; i < len(steps); {
+		j := i
+		for j+1 < len(steps) && steps[j+1] == steps[j]+time.Hour {
+			j++
+		}
+		if j > i {
+			parts = append(parts, formatStep(steps[i])+"-"+formatStep(steps[j]))
+		} else {
+			parts = append(parts, formatStep(steps[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// Source lists and fetches the files of a data provider. The planner and
+// downloader only use this interface, so other providers can be added by
+// implementing it.
+type Source interface {
+	// ListRuns returns the available model runs
+	ListRuns() ([]ModelRun, error)
+
+	// ListParameters returns the parameters published for a run
+	ListParameters(run ModelRun) ([]Parameter, error)
+
+	// ListFiles returns the data files of a parameter
+	ListFiles(param Parameter) ([]index.File, error)
+
+	// Fetch opens a data file by URL for reading
+	Fetch(url string) (io.ReadCloser, error)
+}
+
+// source is the provider of the selected model
+var source Source
+
+// newSource creates the source for a model according to its provider
+func newSource(m Model, client *index.Client) (Source, error) {
+	switch m.Provider {
+	case "", "dwd":
+		return newDWDSource(client, m.BaseURL), nil
+	case "nomads":
+		return newGFSSource(client, m.BaseURL), nil
+	case "ecmwf":
+		return newECMWFSource(client, m.BaseURL), nil
+	case "hrrr":
+		return newDayFilesSource(client, m.BaseURL, hrrrLayout), nil
+	case "nam":
+		return newDayFilesSource(client, m.BaseURL, namLayout), nil
+	case "msc":
+		return newMSCSource(client, m.BaseURL), nil
+	case "mosmix":
+		return newMOSMIXSource(client, m.BaseURL), nil
+	case "radar":
+		return newRadarSource(client, m.BaseURL), nil
+	case "thredds":
+		return newThreddsSource(client, m.BaseURL)
+	case "knmi":
+		if *apiKey == "" {
+			return nil, fmt.Errorf("model %s requires an API key, set -api-key or KNMI_API_KEY", m.Name)
+		}
+		return newKNMISource(client, m.BaseURL, *apiKey), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q of model %s", m.Provider, m.Name)
+	}
+}
+
+// httpFetcher fetches files over HTTP(S) or from S3
+type httpFetcher struct {
+	client *index.Client // Lists directories and resolves s3:// URLs
+	http   *http.Client  // Used to fetch the files
+}
+
+// newHTTPFetcher creates a fetcher with a timeout suitable for large files
+func newHTTPFetcher(client *index.Client) httpFetcher {
+	return httpFetcher{
+		client: client,
+		http: &http.Client{
+			Transport: httpTransport(),
+			Timeout:   10 * time.Minute, // GRIB files can be large
+		},
+	}
+}
+
+// Fetch starts downloading a file, given as an HTTP(S) or s3:// URL
+func (f httpFetcher) Fetch(url string) (io.ReadCloser, error) {
+	resp, err := f.http.Get(f.client.HTTPURL(url))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{Status: resp.Status, Code: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
+// dailyLayout describes a tree of daily directories holding run hour
+// directories, as used by NOAA and ECMWF
+type dailyLayout struct {
+	Day    *regexp.Regexp // Matches daily directory names, capturing the date as YYYYMMDD
+	Hour   *regexp.Regexp // Matches run directory names, capturing the hour as HH
+	Suffix string         // Path from a run directory to the directory of its files
+	Days   int            // Number of latest daily directories to list
+}
+
+// latestDays returns the n latest daily directories under baseURL whose
+// names match pattern, newest first
+func latestDays(client *index.Client, baseURL string, pattern *regexp.Regexp, n int) ([]index.Entry, error) {
+	entries, err := client.List(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []index.Entry
+	for _, e := range entries {
+		if e.Dir && pattern.MatchString(e.Name) {
+			days = append(days, e)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Name > days[j].Name })
+	if len(days) > n {
+		days = days[:n]
+	}
+	return days, nil
+}
+
+// listDailyRuns returns the runs in the latest daily directories under baseURL
+func listDailyRuns(client *index.Client, baseURL string, layout dailyLayout) ([]ModelRun, error) {
+	days, err := latestDays(client, baseURL, layout.Day, layout.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []ModelRun
+	for _, day := range days {
+		date := layout.Day.FindStringSubmatch(day.Name)[1]
+		hours, err := client.List(day.URL)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hours {
+			match := layout.Hour.FindStringSubmatch(h.Name)
+			if !h.Dir || match == nil {
+				continue
+			}
+			ref, err := time.ParseInLocation("20060102 15", date+" "+match[1], time.UTC)
+			if err != nil {
+				continue
+			}
+			// Listings without directory times, such as S3, use the run time
+			timestamp := h.ModTime.UTC()
+			if timestamp.IsZero() {
+				timestamp = ref
+			}
+			runs = append(runs, ModelRun{
+				Time:          match[1],
+				URL:           h.URL + layout.Suffix,
+				Timestamp:     timestamp,
+				ReferenceTime: ref,
+			})
+		}
+	}
+	return runs, nil
+}
+
+// dwdSource reads the directory tree of the DWD open data server or a mirror
+// of it: run hour directories, one directory per parameter and bzip2
+// compressed GRIB files
+type dwdSource struct {
+	httpFetcher        // Also lists the directory indexes with its client
+	baseURL     string // URL of the directory containing the run directories
+}
+
+// newDWDSource creates a source for the model run directories under baseURL
+func newDWDSource(client *index.Client, baseURL string) *dwdSource {
+	return &dwdSource{
+		httpFetcher: newHTTPFetcher(client),
+		baseURL:     baseURL,
+	}
+}
+
+// ListRuns returns the run hour directories below the base URL
+func (s *dwdSource) ListRuns() ([]ModelRun, error) {
+	listed, err := s.client.Runs(s.baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []ModelRun
+	for _, run := range listed {
+		runs = append(runs, ModelRun{
+			Time:          run.Hour,
+			URL:           run.URL,
+			Timestamp:     run.Timestamp,
+			ReferenceTime: run.ReferenceTime,
+		})
+	}
+	return runs, nil
+}
+
+// ListParameters returns the parameter directories of a run
+func (s *dwdSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	listed, err := s.client.Parameters(run.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var params []Parameter
+	for _, p := range listed {
+		params = append(params, Parameter{Name: p.Name, URL: p.URL})
+	}
+	return params, nil
+}
+
+// ListFiles returns the compressed GRIB files of a parameter directory
+func (s *dwdSource) ListFiles(param Parameter) ([]index.File, error) {
+	return s.client.Files(param.URL, ".grib2.bz2")
+}
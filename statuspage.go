@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusErrorLines is the number of recent warnings and failures shown on
+// the status page
+const statusErrorLines = 20
+
+// recentErrors keeps the last warnings and failures logged by the watcher
+// and its downloads
+type recentErrors struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+// watchErrors collects the recent errors of -watch for the status page, or
+// is nil without -status-addr
+var watchErrors *recentErrors
+
+// Write scans log output for warnings and failures
+func (r *recentErrors) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partial = append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(r.partial[:i])
+		r.partial = r.partial[i+1:]
+		if isErrorLine(line) {
+			r.lines = append(r.lines, line)
+			if len(r.lines) > statusErrorLines {
+				r.lines = r.lines[len(r.lines)-statusErrorLines:]
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// recent returns the errors kept, newest first
+func (r *recentErrors) recent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := make([]string, 0, len(r.lines))
+	for i := len(r.lines) - 1; i >= 0; i-- {
+		lines = append(lines, r.lines[i])
+	}
+	return lines
+}
+
+// isErrorLine reports whether a log line is a warning or a failure
+func isErrorLine(line string) bool {
+	for _, marker := range []string{"Warning:", " failed", "Failed", "Cannot ", "Invalid "} {
+		if strings.Contains(line, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRun is a run on the status page
+type statusRun struct {
+	Key           string
+	Run           string
+	ReferenceTime time.Time
+	FirstSeen     time.Time
+	Attempts      int
+	LastStatus    int
+	State         string        // complete, superseded, failed or pending
+	Completed     time.Time     // Time the run was found complete, zero if not
+	Delay         time.Duration // Completion, or first listing if not complete, after the reference time
+	Params        []statusParam
+}
+
+// statusParam is a parameter of a run on the status page
+type statusParam struct {
+	Name     string
+	Files    int           // Files downloaded for the run
+	Expected int           // Files expected from the schedule, 0 if not known
+	Missing  bool          // Whether fewer files than scheduled were obtained
+	Last     time.Duration // Time of the last download after the reference time
+}
+
+// statusPage is the data of the status page
+type statusPage struct {
+	Model   string
+	Updated time.Time
+	Latest  *RunStatus
+	Runs    []statusRun
+	Jobs    []AdhocJob
+	Errors  []string
+}
+
+// statusTemplate renders the status page, refreshed every minute
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"utc":      formatUTC,
+	"duration": formatStatusDelay,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>{{.Model}} downloads</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.complete { color: #080; } .failed, .missing { color: #b00; } .pending { color: #a60; } .superseded { color: #888; }
+pre { background: #f4f4f4; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>{{.Model}} downloads</h1>
+<p>Updated {{utc .Updated}}.
+{{- with .Latest}} Latest complete run: {{.Run}} ({{utc .ReferenceTime}}), {{.Files}} files, completed {{utc .Completed}}.{{end}}</p>
+{{range .Runs}}
+<h2>Run {{.Run}}, {{utc .ReferenceTime}}: <span class="{{.State}}">{{.State}}</span></h2>
+<p>First listed {{utc .FirstSeen}}, attempts: {{.Attempts}}, last exit status: {{.LastStatus}}.
+{{- if not .Completed.IsZero}} Complete at {{utc .Completed}}, {{duration .Delay}} after the run time.{{else}} Listed {{duration .Delay}} after the run time.{{end}}</p>
+{{- if .Params}}
+<table>
+<tr><th>Parameter</th><th>Files</th><th>Expected</th><th>Last file after run time</th></tr>
+{{- range .Params}}
+<tr{{if .Missing}} class="missing"{{end}}><td>{{.Name}}</td><td>{{.Files}}</td><td>{{if .Expected}}{{.Expected}}{{else}}-{{end}}</td><td>{{duration .Last}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{else}}
+<p>No runs seen yet.</p>
+{{end}}
+<h2>Ad-hoc jobs</h2>
+{{if .Jobs}}<table>
+<tr><th>Job</th><th>Run</th><th>Parameters</th><th>State</th><th>Queued</th><th>Started</th><th>Finished</th><th>Exit status</th></tr>
+{{- range .Jobs}}
+<tr><td>{{.ID}}</td><td>{{.Run}}</td><td>{{if .Params}}{{.Params}}{{else}}-{{end}}</td><td>{{.State}}</td><td>{{utc .Queued}}</td><td>{{with .Started}}{{utc .}}{{else}}-{{end}}</td><td>{{with .Finished}}{{utc .}}{{else}}-{{end}}</td><td>{{if .Finished}}{{.Status}}{{else}}-{{end}}</td></tr>
+{{- end}}
+</table>{{else}}<p>None.</p>{{end}}
+<h2>Recent errors</h2>
+{{if .Errors}}<pre>{{range .Errors}}{{.}}
+{{end}}</pre>{{else}}<p>None.</p>{{end}}
+</body>
+</html>
+`))
+
+// formatStatusDelay formats a delay to the minute, or "-" if unknown
+func formatStatusDelay(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return d.Round(time.Minute).String()
+}
+
+// startStatusPage serves the status page of -watch on -status-addr and
+// starts collecting the warnings and failures logged. The page is built on
+// every request from the -watch state, the manifests and DONE markers of the
+// run directories and latest-<model>.json.
+func startStatusPage(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	watchErrors = &recentErrors{}
+	log.SetOutput(io.MultiWriter(os.Stderr, watchErrors))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		if err := statusTemplate.Execute(&buf, buildStatusPage()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Printf("Warning: status page stopped: %v", err)
+		}
+	}()
+	log.Printf("Serving the status page on http://%s/", listener.Addr())
+	return nil
+}
+
+// buildStatusPage collects the status of the runs seen by -watch, newest
+// first
+func buildStatusPage() statusPage {
+	page := statusPage{
+		Model:   selectedModel.Name,
+		Updated: time.Now().UTC(),
+		Jobs:    adhocJobs.list(),
+		Errors:  watchErrors.recent(),
+	}
+	if data, err := os.ReadFile(filepath.Join(*outputDir, runStatusFileName(selectedModel.Name))); err == nil {
+		var latest RunStatus
+		if json.Unmarshal(data, &latest) == nil {
+			page.Latest = &latest
+		}
+	}
+
+	// The state is read from its file, which -watch replaces atomically
+	state := loadWatchState()
+	for key, w := range state.Runs {
+		page.Runs = append(page.Runs, newStatusRun(key, w))
+	}
+	sort.Slice(page.Runs, func(i, j int) bool { return page.Runs[i].Key > page.Runs[j].Key })
+	return page
+}
+
+// newStatusRun describes a run seen by -watch with the files of its run
+// directory
+func newStatusRun(key string, w *WatchedRun) statusRun {
+	run := statusRun{
+		Key:        key,
+		Run:        w.Run,
+		FirstSeen:  w.FirstSeen,
+		Attempts:   w.Attempts,
+		LastStatus: w.LastStatus,
+	}
+	run.ReferenceTime, _ = time.Parse("2006010215", key)
+	switch {
+	case w.Completed != nil:
+		run.State = "complete"
+		run.Completed = *w.Completed
+	case w.Superseded != nil:
+		run.State = "superseded"
+	case w.LastStatus != 0:
+		run.State = "failed"
+	default:
+		run.State = "pending"
+	}
+	if !run.ReferenceTime.IsZero() {
+		if run.Completed.IsZero() {
+			run.Delay = run.FirstSeen.Sub(run.ReferenceTime)
+		} else {
+			run.Delay = run.Completed.Sub(run.ReferenceTime)
+		}
+	}
+
+	manifest, err := loadManifest(filepath.Join(*outputDir, runDirName(w.Run)))
+	if err != nil {
+		return run
+	}
+	params := make(map[string]*statusParam)
+	param := func(name string) *statusParam {
+		if params[name] == nil {
+			params[name] = &statusParam{Name: name}
+		}
+		return params[name]
+	}
+	for _, entry := range manifest.Files {
+		// The directory of the run hour may still hold files of the run
+		// of the day before, which were downloaded before this run started
+		if entry.Param == "" || entry.Downloaded.Before(run.ReferenceTime) {
+			continue
+		}
+		p := param(entry.Param)
+		p.Files++
+		p.Last = max(p.Last, entry.Downloaded.Sub(run.ReferenceTime))
+	}
+	for name, completeness := range manifest.Incomplete {
+		p := param(name)
+		p.Expected = completeness.Expected
+		p.Missing = true
+	}
+	for _, p := range params {
+		run.Params = append(run.Params, *p)
+	}
+	sort.Slice(run.Params, func(i, j int) bool { return run.Params[i].Name < run.Params[j].Name })
+	return run
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// stepRanges holds the parsed -steps flag
+var stepRanges []StepRange
+
+// parseStep parses a forecast step given in hours, e.g. "12", or as a
+// duration for sub-hourly steps, e.g. "2h15m" or "45m"
+func parseStep(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if hours, err := strconv.Atoi(s); err == nil {
+		return time.Duration(hours) * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatStep formats a forecast step as hours, e.g. "78h", or with minutes
+// for sub-hourly steps, e.g. "2h15m"
+func formatStep(step time.Duration) string {
+	hours, minutes := step/time.Hour, (step%time.Hour)/time.Minute
+	if minutes == 0 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%dm", hours, minutes)
+}
+
+// parseStepRanges parses a comma-separated list of forecast steps and step
+// ranges with an optional stride, e.g. "0-48:3", "0-78,81-120:3" or "6,12".
+// Plain numbers are hours; durations select sub-hourly steps, e.g. "0-6:15m".
+func parseStepRanges(spec string) ([]StepRange, error) {
+	var ranges []StepRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds, strideStr, hasStride := strings.Cut(part, ":")
+		fromStr, toStr, isRange := strings.Cut(bounds, "-")
+		if !isRange {
+			toStr = fromStr
+		}
+
+		r := StepRange{Stride: time.Hour}
+		var err error
+		if r.From, err = parseStep(fromStr); err != nil || r.From < 0 {
+			return nil, fmt.Errorf("invalid step range %q, expected e.g. 0-48 or 0-120:3", part)
+		}
+		if r.To, err = parseStep(toStr); err != nil || r.To < r.From {
+			return nil, fmt.Errorf("invalid step range %q, expected e.g. 0-48 or 0-120:3", part)
+		}
+		if hasStride {
+			if r.Stride, err = parseStep(strideStr); err != nil || r.Stride < time.Minute {
+				return nil, fmt.Errorf("invalid stride in step range %q", part)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// contains reports whether a step is part of the range
+func (r StepRange) contains(step time.Duration) bool {
+	return step >= r.From && step <= r.To && (step-r.From)%r.Stride == 0
+}
+
+// stepRequested reports whether a forecast step is selected by -steps and -maxhour
+func stepRequested(step time.Duration) bool {
+	if *maxHour >= 0 && step > time.Duration(*maxHour)*time.Hour {
+		return false
+	}
+	if len(stepRanges) == 0 {
+		return true
+	}
+	for _, r := range stepRanges {
+		if r.contains(step) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedSteps returns the steps selected by -steps and -maxhour
+func requestedSteps(steps []time.Duration) []time.Duration {
+	if len(stepRanges) == 0 && *maxHour < 0 {
+		return steps
+	}
+	var selected []time.Duration
+	for _, step := range steps {
+		if stepRequested(step) {
+			selected = append(selected, step)
+		}
+	}
+	return selected
+}
+
+// filterBySteps returns the files whose forecast step is selected by -steps
+// and -maxhour. Files without a forecast step in their name are kept.
+func filterBySteps(files []index.File) []index.File {
+	if len(stepRanges) == 0 && *maxHour < 0 {
+		return files
+	}
+
+	var filtered []index.File
+	for _, file := range files {
+		if !file.HasLeadtime() || stepRequested(file.Leadtime) {
+			filtered = append(filtered, file)
+		}
+	}
+
+	if *verbose {
+		log.Printf("Filtered %d files down to %d by forecast step", len(files), len(filtered))
+	}
+	return filtered
+}
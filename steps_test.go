@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStepRanges(t *testing.T) {
+	h, m := time.Hour, time.Minute
+	tests := []struct {
+		spec string
+		want []StepRange
+		ok   bool
+	}{
+		{"", nil, true},
+		{"6", []StepRange{{From: 6 * h, To: 6 * h, Stride: h}}, true},
+		{"0-48", []StepRange{{From: 0, To: 48 * h, Stride: h}}, true},
+		{"0-48:3", []StepRange{{From: 0, To: 48 * h, Stride: 3 * h}}, true},
+		{"0-78,81-120:3", []StepRange{{From: 0, To: 78 * h, Stride: h}, {From: 81 * h, To: 120 * h, Stride: 3 * h}}, true},
+		{" 6 , 12 ,", []StepRange{{From: 6 * h, To: 6 * h, Stride: h}, {From: 12 * h, To: 12 * h, Stride: h}}, true},
+		{"0-6:15m", []StepRange{{From: 0, To: 6 * h, Stride: 15 * m}}, true},
+		{"45m", []StepRange{{From: 45 * m, To: 45 * m, Stride: h}}, true},
+		{"1h30m-2h15m:15m", []StepRange{{From: 90 * m, To: 135 * m, Stride: 15 * m}}, true},
+		{"6-6", []StepRange{{From: 6 * h, To: 6 * h, Stride: h}}, true},
+		{"12-6", nil, false},
+		{"-6", nil, false},
+		{"a-6", nil, false},
+		{"0-b", nil, false},
+		{"0-48:", nil, false},
+		{"0-48:0", nil, false},
+		{"0-48:30s", nil, false},
+		{"0-48:x", nil, false},
+		{"0-6,x", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseStepRanges(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseStepRanges(%q) error = %v, want ok %v", tt.spec, err, tt.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStepRanges(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestStepRangeContains(t *testing.T) {
+	tests := []struct {
+		spec string
+		step time.Duration
+		want bool
+	}{
+		{"0-48:3", 0, true},
+		{"0-48:3", 3 * time.Hour, true},
+		{"0-48:3", 4 * time.Hour, false},
+		{"0-48:3", 48 * time.Hour, true},
+		{"0-48:3", 51 * time.Hour, false},
+		{"81-120:3", 84 * time.Hour, true},
+		{"81-120:3", 85 * time.Hour, false},
+		{"0-6:15m", 45 * time.Minute, true},
+		{"0-6:15m", 50 * time.Minute, false},
+		{"6", 6 * time.Hour, true},
+		{"6", 7 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		ranges, err := parseStepRanges(tt.spec)
+		if err != nil {
+			t.Fatalf("parseStepRanges(%q): %v", tt.spec, err)
+		}
+		if got := ranges[0].contains(tt.step); got != tt.want {
+			t.Errorf("%q contains %s = %v, want %v", tt.spec, formatStep(tt.step), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// threddsFilePattern matches run files, e.g. "meps_det_2_5km_20250312T06Z.nc",
+// capturing the product, run date and hour
+var threddsFilePattern = regexp.MustCompile(`^(.+)_(\d{8})T(\d\d)Z\.nc$`)
+
+// threddsDays is the number of daily catalogs listed for runs
+const threddsDays = 2
+
+// threddsCatalog is a THREDDS catalog.xml document
+type threddsCatalog struct {
+	Datasets []threddsDataset `xml:"dataset"`
+}
+
+// threddsDataset is a dataset of a THREDDS catalog, either a file with a
+// URL path or a collection of nested datasets
+type threddsDataset struct {
+	Name     string           `xml:"name,attr"`
+	URLPath  string           `xml:"urlPath,attr"`
+	Size     threddsSize      `xml:"dataSize"`
+	Dates    []threddsDate    `xml:"date"`
+	Datasets []threddsDataset `xml:"dataset"`
+}
+
+// threddsSize is the rounded size of a dataset in the given units
+type threddsSize struct {
+	Units string `xml:"units,attr"`
+	Value string `xml:",chardata"`
+}
+
+// threddsDate is a date of a dataset, e.g. of type "modified"
+type threddsDate struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// threddsSource reads the daily THREDDS catalogs of MET Norway, e.g.
+// catalog/meps25eps/YYYY/MM/DD/catalog.xml, whose NetCDF files each hold
+// all lead times of a run. Products such as meps_det_2_5km take the place of
+// DWD parameter directories, and files are fetched through the fileServer
+// service.
+type threddsSource struct {
+	httpFetcher        // Fetches the catalogs and files
+	catalogURL  string // Catalog URL of the dataset, e.g. ".../thredds/catalog/meps25eps/"
+	fileURL     string // fileServer URL of the THREDDS server, e.g. ".../thredds/fileServer/"
+}
+
+// newThreddsSource creates a source for the daily catalogs under a dataset
+// catalog URL
+func newThreddsSource(client *index.Client, catalogURL string) (*threddsSource, error) {
+	root, _, found := strings.Cut(catalogURL, "/catalog/")
+	if !found {
+		return nil, fmt.Errorf("base URL %s is not a THREDDS catalog URL", catalogURL)
+	}
+	return &threddsSource{
+		httpFetcher: newHTTPFetcher(client),
+		catalogURL:  catalogURL,
+		fileURL:     root + "/fileServer/",
+	}, nil
+}
+
+// ListRuns returns the runs in the catalogs of today and the previous days
+func (s *threddsSource) ListRuns() ([]ModelRun, error) {
+	var runs []ModelRun
+	today := time.Now().UTC()
+	for i := threddsDays - 1; i >= 0; i-- {
+		dayURL := s.catalogURL + today.AddDate(0, 0, -i).Format("2006/01/02/")
+		files, err := s.catalogFiles(dayURL)
+		if err != nil {
+			return nil, err
+		}
+
+		newest := make(map[time.Time]time.Time)
+		for _, f := range files {
+			if newest[f.ReferenceTime].Before(f.ModTime) {
+				newest[f.ReferenceTime] = f.ModTime
+			}
+		}
+		for ref, timestamp := range newest {
+			if timestamp.IsZero() {
+				timestamp = ref
+			}
+			runs = append(runs, ModelRun{
+				Time:          ref.Format("15"),
+				URL:           dayURL,
+				Timestamp:     timestamp,
+				ReferenceTime: ref,
+			})
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ReferenceTime.Before(runs[j].ReferenceTime) })
+	return runs, nil
+}
+
+// ListParameters returns the products published for a run
+func (s *threddsSource) ListParameters(run ModelRun) ([]Parameter, error) {
+	files, err := s.catalogFiles(run.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var params []Parameter
+	for _, f := range files {
+		product := threddsFilePattern.FindStringSubmatch(f.Name)[1]
+		if !f.ReferenceTime.Equal(run.ReferenceTime) || seen[product] {
+			continue
+		}
+		seen[product] = true
+		params = append(params, Parameter{Name: product, URL: run.URL, Run: run.Time})
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params, nil
+}
+
+// ListFiles returns the file of a product of the parameter's run
+func (s *threddsSource) ListFiles(param Parameter) ([]index.File, error) {
+	files, err := s.catalogFiles(param.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []index.File
+	for _, f := range files {
+		match := threddsFilePattern.FindStringSubmatch(f.Name)
+		if match[1] == param.Name && match[3] == param.Run {
+			selected = append(selected, f)
+		}
+	}
+	return selected, nil
+}
+
+// catalogFiles returns the run files of a daily catalog. Days without a
+// catalog yet have no files.
+func (s *threddsSource) catalogFiles(dayURL string) ([]index.File, error) {
+	resp, err := s.http.Get(s.client.HTTPURL(dayURL + "catalog.xml"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("catalog request failed with status: %s", resp.Status)
+	}
+
+	var catalog threddsCatalog
+	if err := xml.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog %s: %v", dayURL, err)
+	}
+
+	var files []index.File
+	var walk func(datasets []threddsDataset)
+	walk = func(datasets []threddsDataset) {
+		for _, d := range datasets {
+			walk(d.Datasets)
+			match := threddsFilePattern.FindStringSubmatch(d.Name)
+			if d.URLPath == "" || match == nil {
+				continue
+			}
+			ref, err := time.ParseInLocation("20060102 15", match[2]+" "+match[3], time.UTC)
+			if err != nil {
+				continue
+			}
+			files = append(files, index.File{
+				Name:          d.Name,
+				URL:           s.fileURL + d.URLPath,
+				ModTime:       d.modified(),
+				Size:          d.Size.bytes(),
+				ReferenceTime: ref,
+				Leadtime:      -1,
+			})
+		}
+	}
+	walk(catalog.Datasets)
+	return files, nil
+}
+
+// modified returns the modification time of a dataset, or the zero time
+func (d threddsDataset) modified() time.Time {
+	for _, date := range d.Dates {
+		if date.Type == "modified" {
+			t, _ := time.Parse(time.RFC3339, strings.TrimSpace(date.Value))
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// bytes returns the approximate size in bytes, or -1 if unknown
+func (s threddsSize) bytes() int64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(s.Value), 64)
+	if err != nil {
+		return -1
+	}
+	multiplier := map[string]float64{
+		"bytes": 1, "Kbytes": 1 << 10, "Mbytes": 1 << 20, "Gbytes": 1 << 30, "Tbytes": 1 << 40,
+	}[s.Units]
+	if multiplier == 0 {
+		return -1
+	}
+	return int64(value * multiplier)
+}
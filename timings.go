@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// FileTimings splits the time taken to produce a file into phases, to show
+// where a download pipeline that cannot keep up with publication loses time.
+// Downloading and decompression overlap in a single pass, so the time spent
+// waiting for the network and the time spent in the decompressor are
+// measured separately within it.
+type FileTimings struct {
+	Queue          float64 `json:"queue_seconds"`                     // Waiting for a free download slot
+	Retries        float64 `json:"retries_seconds,omitempty"`         // Failed attempts and backoff before the successful one
+	Download       float64 `json:"download_seconds"`                  // Request and waiting for data from the network
+	Decompression  float64 `json:"decompression_seconds"`             // Uncompressing the downloaded data
+	Write          float64 `json:"write_seconds"`                     // Hashing and writing the output file
+	SincePublished float64 `json:"since_published_seconds,omitempty"` // From the remote modification time to completion
+}
+
+// seconds converts a duration to seconds rounded to milliseconds
+func seconds(d time.Duration) float64 {
+	return math.Round(d.Seconds()*1000) / 1000
+}
+
+// timedReader adds the time spent in Read calls to a total
+type timedReader struct {
+	r     io.Reader
+	total *time.Duration
+}
+
+func (t timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	*t.total += time.Since(start)
+	return n, err
+}
+
+// timedWriter adds the time spent in Write calls to a total
+type timedWriter struct {
+	w     io.Writer
+	total *time.Duration
+}
+
+func (t timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	*t.total += time.Since(start)
+	return n, err
+}
+
+// timingTotals sums the phases of all files downloaded in this invocation
+var timingTotals = struct {
+	mu    sync.Mutex
+	files int
+	sum   FileTimings
+}{}
+
+// addTimings adds the phases of a downloaded file to the totals
+func addTimings(t FileTimings) {
+	timingTotals.mu.Lock()
+	defer timingTotals.mu.Unlock()
+	timingTotals.files++
+	timingTotals.sum.Queue += t.Queue
+	timingTotals.sum.Retries += t.Retries
+	timingTotals.sum.Download += t.Download
+	timingTotals.sum.Decompression += t.Decompression
+	timingTotals.sum.Write += t.Write
+	if t.SincePublished > timingTotals.sum.SincePublished {
+		timingTotals.sum.SincePublished = t.SincePublished
+	}
+}
+
+// logTimings logs the summed phases of the downloaded files
+func logTimings() {
+	timingTotals.mu.Lock()
+	defer timingTotals.mu.Unlock()
+	if timingTotals.files == 0 {
+		return
+	}
+	sum := timingTotals.sum
+	total := sum.Queue + sum.Retries + sum.Download + sum.Decompression + sum.Write
+	share := func(v float64) string {
+		if total == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.0f%%", 100*v/total)
+	}
+	log.Printf("Time per phase over %d files: queue %s (%s), retries %s (%s), download %s (%s), decompression %s (%s), write %s (%s)",
+		timingTotals.files,
+		formatSeconds(sum.Queue), share(sum.Queue),
+		formatSeconds(sum.Retries), share(sum.Retries),
+		formatSeconds(sum.Download), share(sum.Download),
+		formatSeconds(sum.Decompression), share(sum.Decompression),
+		formatSeconds(sum.Write), share(sum.Write))
+	if sum.SincePublished > 0 {
+		log.Printf("Longest time from publication to completion: %s", formatSeconds(sum.SincePublished))
+	}
+}
+
+// formatSeconds formats a number of seconds as a duration
+func formatSeconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
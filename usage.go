@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// usageFileName stores the bandwidth accounting in the output directory
+const usageFileName = ".usage.json"
+
+// usageLockTimeout is how long saving the accounting waits for other
+// processes writing it
+const usageLockTimeout = 30 * time.Second
+
+// Usage accumulates downloaded bytes per UTC day and model
+type Usage struct {
+	Days map[string]map[string]int64 `json:"days"` // "2006-01-02" -> model -> bytes
+
+	path    string
+	pending map[string]map[string]int64 // Bytes added since the last save
+	mu      sync.Mutex
+}
+
+// bandwidthUsage is the accounting of the output directory, nil if unavailable
+var bandwidthUsage *Usage
+
+// loadUsage reads the bandwidth accounting of an output directory
+func loadUsage(dir string) (*Usage, error) {
+	u := &Usage{
+		Days:    make(map[string]map[string]int64),
+		path:    filepath.Join(dir, usageFileName),
+		pending: make(map[string]map[string]int64),
+	}
+
+	data, err := os.ReadFile(u.path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", u.path, err)
+	}
+	if u.Days == nil {
+		u.Days = make(map[string]map[string]int64)
+	}
+	return u, nil
+}
+
+// add records downloaded bytes for a model on the current day
+func (u *Usage) add(model string, bytes int64) {
+	if u == nil || bytes <= 0 {
+		return
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	addUsage(u.Days, day, model, bytes)
+	addUsage(u.pending, day, model, bytes)
+}
+
+// addUsage adds bytes for a model and day to a table of the accounting
+func addUsage(days map[string]map[string]int64, day, model string, bytes int64) {
+	if days[day] == nil {
+		days[day] = make(map[string]int64)
+	}
+	days[day][model] += bytes
+}
+
+// monthTotal returns the bytes downloaded in the given month ("2006-01")
+func (u *Usage) monthTotal(month string) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var total int64
+	for day, perModel := range u.Days {
+		if strings.HasPrefix(day, month) {
+			for _, bytes := range perModel {
+				total += bytes
+			}
+		}
+	}
+	return total
+}
+
+// save adds the bytes downloaded since the last save to the accounting file
+// and writes it atomically. The file is read again under a lock, so that
+// parallel processes sharing the output directory, such as jobs and runs
+// downloaded side by side, add up instead of overwriting each other.
+func (u *Usage) save() error {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.pending) == 0 {
+		return nil
+	}
+
+	lock, err := waitForLockFile(u.path+".lock", "bandwidth accounting "+u.path, usageLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	current, err := loadUsage(filepath.Dir(u.path))
+	if err != nil {
+		return err
+	}
+	for day, perModel := range u.pending {
+		for model, bytes := range perModel {
+			addUsage(current.Days, day, model, bytes)
+		}
+	}
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, u.path); err != nil {
+		return err
+	}
+	u.Days = current.Days
+	u.pending = make(map[string]map[string]int64)
+	return nil
+}
+
+// monthlyCapBytes is the -monthly-cap parsed by main, 0 without a cap
+var monthlyCapBytes int64
+
+// checkMonthlyCap warns when the downloads of the current month exceed the -monthly-cap
+func checkMonthlyCap(u *Usage) {
+	limit := monthlyCapBytes
+	if u == nil || limit == 0 {
+		return
+	}
+
+	month := time.Now().UTC().Format("2006-01")
+	used := u.monthTotal(month)
+	switch {
+	case used >= limit:
+		log.Printf("Warning: monthly transfer cap exceeded: %s downloaded in %s, cap is %s",
+			formatBytes(used), month, formatBytes(limit))
+	case used >= limit/10*9:
+		log.Printf("Warning: 90%% of monthly transfer cap used: %s downloaded in %s, cap is %s",
+			formatBytes(used), month, formatBytes(limit))
+	}
+}
+
+// printUsageReport prints the downloaded bytes per day and model, with monthly totals
+func printUsageReport(u *Usage) {
+	var days []string
+	for day := range u.Days {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tMODEL\tDOWNLOADED")
+
+	month := ""
+	var monthTotal int64
+	flushMonth := func() {
+		if month != "" {
+			fmt.Fprintf(w, "%s\ttotal\t%s\n", month, formatBytes(monthTotal))
+		}
+	}
+	for _, day := range days {
+		if day[:7] != month {
+			flushMonth()
+			month, monthTotal = day[:7], 0
+		}
+
+		var models []string
+		for model := range u.Days[day] {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		for _, model := range models {
+			bytes := u.Days[day][model]
+			monthTotal += bytes
+			fmt.Fprintf(w, "%s\t%s\t%s\n", day, model, formatBytes(bytes))
+		}
+	}
+	flushMonth()
+	w.Flush()
+}
+
+// parseByteSize parses sizes such as "500G", "1.5TB" or "1024"
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
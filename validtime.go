@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// validTimeLayouts are the accepted formats of -valid-times, always interpreted in UTC
+var validTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15",
+	"2006010215",
+}
+
+// validTimeRange is an inclusive range of validity times
+type validTimeRange struct {
+	From, To time.Time
+}
+
+// validTimeRanges holds the parsed -valid-times flag
+var validTimeRanges []validTimeRange
+
+// parseValidTimes parses a comma-separated list of validity times or
+// from/to ranges, e.g. "2025-03-12T06:00/2025-03-12T18:00,2025-03-13T00:00"
+func parseValidTimes(spec string) ([]validTimeRange, error) {
+	var ranges []validTimeRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fromStr, toStr, isRange := strings.Cut(part, "/")
+		from, err := parseValidTime(fromStr)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			if to, err = parseValidTime(toStr); err != nil {
+				return nil, err
+			}
+			if to.Before(from) {
+				return nil, fmt.Errorf("invalid validity time range %s: end is before start", part)
+			}
+		}
+		ranges = append(ranges, validTimeRange{From: from, To: to})
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no validity times in %q", spec)
+	}
+	return ranges, nil
+}
+
+// parseValidTime parses a single validity time in UTC
+func parseValidTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range validTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid validity time %q, expected e.g. 2025-03-12T06:00", s)
+}
+
+// filterByValidTime returns the files whose validity time (reference time
+// plus forecast step) falls in one of the -valid-times ranges. Files without
+// a forecast step, such as time-invariant fields, are kept.
+func filterByValidTime(files []index.File) []index.File {
+	if len(validTimeRanges) == 0 {
+		return files
+	}
+
+	var filtered []index.File
+	for _, file := range files {
+		valid, ok := file.ValidTime()
+		if !ok {
+			filtered = append(filtered, file)
+			continue
+		}
+
+		for _, r := range validTimeRanges {
+			if !valid.Before(r.From) && !valid.After(r.To) {
+				filtered = append(filtered, file)
+				break
+			}
+		}
+	}
+
+	if *verbose {
+		log.Printf("Filtered %d files down to %d by validity time", len(files), len(filtered))
+	}
+	return filtered
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// verifyAgainstPartner compares the manifests of the local run directories
+// with those of a partner's archive built by this tool under partnerURL, and
+// spot checks the SHA-256 of some files on both sides. Nothing is written.
+// It returns the number of problems found.
+func verifyAgainstPartner(partnerURL string, samples int) int {
+	if !strings.HasSuffix(partnerURL, "/") {
+		partnerURL += "/"
+	}
+	client := &http.Client{Timeout: 10 * time.Minute}
+
+	runs, err := localRuns()
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return 1
+	}
+	if len(runs) == 0 {
+		log.Printf("Error: no run directories with a %s in %s", manifestFileName, *outputDir)
+		return 1
+	}
+
+	problems, compared, checked := 0, 0, 0
+	for _, run := range runs {
+		local, err := loadManifest(filepath.Join(*outputDir, run))
+		if err != nil {
+			log.Printf("Error: %v", err)
+			problems++
+			continue
+		}
+
+		var partner Manifest
+		if err := getJSON(client, partnerURL+run+"/"+manifestFileName, &partner); err != nil {
+			log.Printf("Mismatch: run %s: cannot read partner manifest: %v", run, err)
+			problems++
+			continue
+		}
+		if partner.Model != local.Model {
+			log.Printf("Mismatch: run %s: partner has model %s, local copy has %s", run, partner.Model, local.Model)
+			problems++
+			continue
+		}
+
+		var common []string
+		for _, name := range unionNames(local.Files, partner.Files) {
+			l, p := local.Files[name], partner.Files[name]
+			switch {
+			case p == nil:
+				log.Printf("Mismatch: run %s: %s is missing at the partner", run, name)
+				problems++
+			case l == nil:
+				log.Printf("Mismatch: run %s: %s is missing locally", run, name)
+				problems++
+			case l.Size != p.Size || l.SHA256 != p.SHA256:
+				log.Printf("Mismatch: run %s: %s differs: local %d bytes %s, partner %d bytes %s",
+					run, name, l.Size, shortHash(l.SHA256), p.Size, shortHash(p.SHA256))
+				problems++
+			default:
+				common = append(common, name)
+			}
+		}
+		compared += len(common)
+
+		// Spot check that the files on both sides match their manifests
+		rand.Shuffle(len(common), func(i, j int) { common[i], common[j] = common[j], common[i] })
+		for _, name := range common[:min(samples, len(common))] {
+			want := local.Files[name].SHA256
+			checked++
+			if sum, err := fileSHA256(filepath.Join(*outputDir, run, name)); err != nil || sum != want {
+				log.Printf("Mismatch: run %s: local %s does not match the manifest (%s)", run, name, describeChecksum(sum, err))
+				problems++
+			}
+			if sum, err := remoteSHA256(client, partnerURL+run+"/"+name); err != nil || sum != want {
+				log.Printf("Mismatch: run %s: partner %s does not match the manifest (%s)", run, name, describeChecksum(sum, err))
+				problems++
+			}
+		}
+	}
+
+	log.Printf("Verified %d runs against %s: %d files compared, %d spot checks, %d problems",
+		len(runs), partnerURL, compared, checked, problems)
+	return problems
+}
+
+// localRuns returns the run directories of the output directory that have a
+// manifest, or only the -run directory if given
+func localRuns() ([]string, error) {
+	if *modelRun != "" {
+		specs, err := parseRunSelections(*modelRun)
+		if err != nil {
+			return nil, err
+		}
+		var runs []string
+		for _, spec := range specs {
+			runs = append(runs, spec.Hour)
+		}
+		return runs, nil
+	}
+	entries, err := os.ReadDir(*outputDir)
+	if err != nil {
+		return nil, err
+	}
+	var runs []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(*outputDir, e.Name(), manifestFileName)); e.IsDir() && err == nil {
+			runs = append(runs, e.Name())
+		}
+	}
+	return runs, nil
+}
+
+// unionNames returns the sorted file names of two manifests
+func unionNames(a, b map[string]*ManifestEntry) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, files := range []map[string]*ManifestEntry{a, b} {
+		for name := range files {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getJSON fetches and decodes a JSON document
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(indexClient.HTTPURL(url))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// remoteSHA256 downloads a file and returns its hex encoded SHA-256
+func remoteSHA256(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(indexClient.HTTPURL(url))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status: %s", resp.Status)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// shortHash abbreviates a checksum for log messages
+func shortHash(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+// describeChecksum describes the outcome of a checksum computation
+func describeChecksum(sum string, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "SHA-256 " + shortHash(sum)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, overridden by -ldflags during release builds, e.g.
+// -X 'main.version=v1.2.0' -X 'main.commit=abc1234' -X 'main.buildDate=2025-03-12T08:00:00Z'
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// modelCatalogVersion identifies the built-in model list and publication
+// schedules. Increment it whenever the catalog data changes: the models,
+// their publication schedules or their parameter sets.
+// Publish remote catalogs with higher versions only.
+const modelCatalogVersion = "19"
+
+// BuildInfo identifies the downloader build that produced a run directory
+type BuildInfo struct {
+	Version        string `json:"version"`
+	Commit         string `json:"commit,omitempty"`
+	BuildDate      string `json:"build_date,omitempty"`
+	ModelCatalog   string `json:"model_catalog"`
+	GoVersion      string `json:"go_version"`
+	OSArchitecture string `json:"os_arch"`
+}
+
+// currentBuild returns the build metadata, completed from the Go build info
+// when the binary was not built with -ldflags
+func currentBuild() BuildInfo {
+	b := BuildInfo{
+		Version:        version,
+		Commit:         commit,
+		BuildDate:      buildDate,
+		ModelCatalog:   activeCatalogVersion,
+		GoVersion:      runtime.Version(),
+		OSArchitecture: runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if b.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		b.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && b.Commit == "":
+			b.Commit = s.Value
+		case s.Key == "vcs.time" && b.BuildDate == "":
+			b.BuildDate = s.Value
+		case s.Key == "vcs.modified" && s.Value == "true" && commit == "" && b.Commit != "":
+			b.Commit += "-dirty"
+		}
+	}
+	return b
+}
+
+// printVersion prints the build metadata for -version
+func printVersion() {
+	b := currentBuild()
+	fmt.Printf("ICON GRIB Downloader version %s\n", b.Version)
+	if b.Commit != "" {
+		fmt.Printf("  commit:        %s\n", b.Commit)
+	}
+	if b.BuildDate != "" {
+		fmt.Printf("  date:          %s\n", b.BuildDate)
+	}
+	fmt.Printf("  model catalog: %s\n", b.ModelCatalog)
+	fmt.Printf("  go:            %s %s\n", b.GoVersion, b.OSArchitecture)
+}
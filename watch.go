@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// watchStateFileName returns the name of the -watch state file of a model,
+// kept in the output directory
+func watchStateFileName(model string) string {
+	return ".watch-" + model + ".json"
+}
+
+// -watch-backlog policies for several runs waiting to be downloaded
+const (
+	backlogParallel       = "parallel"        // Download the runs side by side
+	backlogNewestFirst    = "newest-first"    // Download the runs one after another, newest first
+	backlogSkipSuperseded = "skip-superseded" // Download the newest run only, giving up older ones
+)
+
+// validateWatchBacklog checks the -watch-backlog policy
+func validateWatchBacklog(policy string) error {
+	switch policy {
+	case backlogParallel, backlogNewestFirst, backlogSkipSuperseded:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q, valid values are %s, %s and %s", policy, backlogParallel, backlogNewestFirst, backlogSkipSuperseded)
+}
+
+// WatchedRun is the state of a run seen by -watch
+type WatchedRun struct {
+	Run         string     `json:"run"`                  // Run hour, also the name of the run directory
+	FirstSeen   time.Time  `json:"first_seen"`           // Time the run was first listed
+	Attempts    int        `json:"attempts"`             // Number of downloads started for the run
+	LastAttempt time.Time  `json:"last_attempt"`         // Start of the last download
+	LastStatus  int        `json:"last_status"`          // Exit status of the last download
+	Completed   *time.Time `json:"completed,omitempty"`  // Time the run was found complete
+	Superseded  *time.Time `json:"superseded,omitempty"` // Time the run was given up for a newer one
+}
+
+// watchState holds the runs seen by -watch by run time, e.g. "2025031206"
+type watchState struct {
+	Runs map[string]*WatchedRun `json:"runs"`
+
+	path string
+}
+
+// loadWatchState reads the -watch state of the selected model, starting
+// afresh if there is none or it cannot be read
+func loadWatchState() *watchState {
+	s := &watchState{
+		Runs: make(map[string]*WatchedRun),
+		path: filepath.Join(*outputDir, watchStateFileName(selectedModel.Name)),
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return s
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		log.Printf("Warning: ignoring unreadable %s: %v", s.path, err)
+		s.Runs = make(map[string]*WatchedRun)
+	}
+	if s.Runs == nil {
+		s.Runs = make(map[string]*WatchedRun)
+	}
+	return s
+}
+
+// save writes the -watch state atomically
+func (s *watchState) save() {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Printf("Warning: could not save watch state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		log.Printf("Warning: could not save watch state: %v", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Warning: could not save watch state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		log.Printf("Warning: could not save watch state: %v", err)
+	}
+}
+
+// watchSchedules holds the parsed -watch-schedule flag
+var watchSchedules []cronSchedule
+
+// watchRuns keeps downloading the newest run, or the newest -last-runs
+// runs, as they appear until interrupted. Every -watch-interval the runs are
+// listed, and each run that is not complete yet is downloaded by a process
+// of its own, just like with -run, so that a run still being uploaded is
+// picked up again at the next poll. With -watch-schedule, polling only
+// happens in windows starting at the scheduled times. A signal stops
+// watching once the current downloads are finished.
+func watchRuns() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	state := loadWatchState()
+	if *statusAddr != "" {
+		if err := startStatusPage(*statusAddr); err != nil {
+			log.Printf("Cannot serve the status page: %v", err)
+			return 1
+		}
+	}
+	if *controlAddr != "" {
+		// Ad-hoc jobs take over the download slots of the runs, so the
+		// runs share slots even outside a multi-job configuration
+		if sharedSlots == nil {
+			slots, err := newJobSlots(*maxConcurrent)
+			if err != nil {
+				log.Printf("Cannot serve the control API: %v", err)
+				return 1
+			}
+			sharedSlots = slots
+		}
+		if err := startControlAPI(*controlAddr, *controlToken); err != nil {
+			log.Printf("Cannot serve the control API: %v", err)
+			return 1
+		}
+		startAdhocLane()
+	}
+	if len(watchSchedules) > 0 {
+		log.Printf("Watching for new %s runs every %s in windows of up to %s", selectedModel.Name, *watchInterval, *watchWindow)
+		for watchWindowOnce(ctx, state) {
+		}
+	} else {
+		log.Printf("Watching for new %s runs every %s", selectedModel.Name, *watchInterval)
+		for {
+			pollWatchedRuns(state)
+			if !sleepContext(ctx, *watchInterval) {
+				break
+			}
+		}
+	}
+	adhocJobs.stop()
+	log.Printf("Stopped watching for new runs")
+	return 0
+}
+
+// watchWindowOnce waits for the next scheduled window and polls until the
+// run due at its start is complete or the -watch-window has passed. It
+// returns false once interrupted.
+func watchWindowOnce(ctx context.Context, state *watchState) bool {
+	start := nextWatchWindow(time.Now())
+	if start.IsZero() {
+		log.Printf("Warning: -watch-schedule never matches, stopped watching")
+		return false
+	}
+	log.Printf("Next check for new runs at %s", formatUTC(start))
+	if !sleepContext(ctx, time.Until(start)) {
+		return false
+	}
+
+	due := dueRunTime(start)
+	end := start.Add(*watchWindow)
+	for {
+		newest, complete := pollWatchedRuns(state)
+		if complete && !newest.Before(due) {
+			log.Printf("Run of %s is complete, waiting for the next window", formatUTC(newest))
+			return true
+		}
+		if time.Now().Add(*watchInterval).After(end) {
+			log.Printf("Warning: window of %s ended before the run due at %s was complete", formatUTC(start), formatUTC(due))
+			return true
+		}
+		if !sleepContext(ctx, *watchInterval) {
+			return false
+		}
+	}
+}
+
+// nextWatchWindow returns the next time matching -watch-schedule after t
+func nextWatchWindow(t time.Time) time.Time {
+	var next time.Time
+	for _, s := range watchSchedules {
+		if n := s.next(t); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// dueRunTime returns the reference time of the newest run that the model
+// schedule starts at or before t, e.g. 00 UTC for a window at 02:30 UTC, or
+// the zero time for models without a schedule, which wait for any complete
+// run
+func dueRunTime(t time.Time) time.Time {
+	hour := t.UTC().Truncate(time.Hour)
+	for i := 0; i < 24; i++ {
+		run := hour.Add(-time.Duration(i) * time.Hour)
+		if _, ok := selectedModel.scheduledSteps(run.Format("15")); ok {
+			return run
+		}
+	}
+	return time.Time{}
+}
+
+// sleepContext sleeps for d, returning false if interrupted
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// pollWatchedRuns lists the runs and downloads the newest ones that are not
+// complete yet, updating the state. Several runs waiting are downloaded
+// according to -watch-backlog. It returns the reference time of the newest
+// run and whether the newest runs are complete.
+func pollWatchedRuns(state *watchState) (time.Time, bool) {
+	runs, err := source.ListRuns()
+	if err != nil {
+		log.Printf("Warning: failed to list model runs: %v", err)
+		return time.Time{}, false
+	}
+	if len(runs) == 0 {
+		return time.Time{}, false
+	}
+	sortRunsNewestFirst(runs)
+
+	// Forget the runs no longer listed
+	listed := make(map[string]bool)
+	for _, run := range runs {
+		listed[watchKey(run)] = true
+	}
+	for key := range state.Runs {
+		if !listed[key] {
+			delete(state.Runs, key)
+		}
+	}
+
+	var pending []ModelRun
+	var children []childProcess
+	var newer *ModelRun
+	now := time.Now().UTC()
+	for _, run := range newestRuns(runs, max(1, *lastRuns)) {
+		key := watchKey(run)
+		w := state.Runs[key]
+		if w == nil {
+			log.Printf("New run %s (reference time: %s)", run.Time, formatUTC(run.ReferenceTime))
+			w = &WatchedRun{Run: run.Time, FirstSeen: now}
+			state.Runs[key] = w
+		}
+		if w.Completed != nil || w.Superseded != nil {
+			newer = &run
+			continue
+		}
+		if *watchBacklog == backlogSkipSuperseded && newer != nil {
+			log.Printf("Giving up run %s (reference time: %s), superseded by run %s", run.Time, formatUTC(run.ReferenceTime), newer.Time)
+			superseded := now
+			w.Superseded = &superseded
+			continue
+		}
+		newer = &run
+		w.Attempts++
+		w.LastAttempt = now
+		pending = append(pending, run)
+		child := runChild(run)
+		child.args = append(child.args, "-watch=false", "-watch-schedule=", "-status-addr=", "-control-addr=", "-control-token=")
+		child.env = adhocJobs.runEnv()
+		children = append(children, child)
+	}
+	if len(children) == 0 {
+		if *verbose {
+			log.Printf("No new runs")
+		}
+		state.save()
+		return runs[0].ReferenceTime, true
+	}
+	state.save()
+
+	statuses := make([]int, len(children))
+	if *watchBacklog == backlogNewestFirst && len(children) > 1 {
+		log.Printf("%d runs waiting, downloading them newest first", len(children))
+		for i, child := range children {
+			statuses[i] = runChildren("run", []childProcess{child})
+		}
+	} else {
+		status := runChildren("run", children)
+		for i := range statuses {
+			statuses[i] = status
+		}
+	}
+
+	complete := true
+	for i, run := range pending {
+		w := state.Runs[watchKey(run)]
+		w.LastStatus = statuses[i]
+		marker := loadDoneMarker(filepath.Join(*outputDir, runDirName(run.Time)))
+		if marker == nil || !marker.ReferenceTime.Equal(run.ReferenceTime) {
+			complete = false
+			continue
+		}
+		completed := marker.Completed
+		w.Completed = &completed
+		log.Printf("Run %s is complete after %d attempts", run.Time, w.Attempts)
+	}
+	state.save()
+	return runs[0].ReferenceTime, complete
+}
+
+// watchKey identifies a run in the -watch state by its run time, the same
+// name runChild gives its process
+func watchKey(run ModelRun) string {
+	return runChild(run).name
+}
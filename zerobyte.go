@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"icon-grib-downloader/pkg/index"
+)
+
+// Treatments of zero-byte placeholder files published before the real data
+const (
+	zeroByteWait   = "wait"   // Treat as not yet available; re-polled with -wait
+	zeroByteIgnore = "ignore" // Skip silently and treat the step as published
+	zeroByteError  = "error"  // Fail the parameter
+)
+
+// validateZeroBytePolicy checks the value of -zero-byte
+func validateZeroBytePolicy(policy string) error {
+	switch policy {
+	case zeroByteWait, zeroByteIgnore, zeroByteError:
+		return nil
+	}
+	return fmt.Errorf("invalid zero-byte treatment '%s'. Valid values are: %s, %s, %s",
+		policy, zeroByteWait, zeroByteIgnore, zeroByteError)
+}
+
+// errEmptyRemote is returned for downloads that yield no data
+var errEmptyRemote = errors.New("remote file is empty")
+
+// notYetAvailable holds the URLs of planned files found to be zero-byte
+// placeholders at download time, mapped to their parameter
+var notYetAvailable = struct {
+	mu   sync.Mutex
+	urls map[string]string
+}{urls: make(map[string]string)}
+
+// splitEmptyFiles separates files listed with a size of zero bytes
+func splitEmptyFiles(files []index.File) (nonEmpty, empty []index.File) {
+	for _, f := range files {
+		if f.Size == 0 {
+			empty = append(empty, f)
+		} else {
+			nonEmpty = append(nonEmpty, f)
+		}
+	}
+	return nonEmpty, empty
+}
+
+// handleEmptyListing applies the -zero-byte treatment to the zero-byte files
+// of a parameter listing and returns the files to consider published
+func handleEmptyListing(param string, files []index.File) ([]index.File, error) {
+	nonEmpty, empty := splitEmptyFiles(files)
+	if len(empty) == 0 {
+		return files, nil
+	}
+
+	switch *zeroBytePolicy {
+	case zeroByteError:
+		return nil, fmt.Errorf("%d zero-byte files listed for parameter %s, e.g. %s", len(empty), param, empty[0].Name)
+	case zeroByteIgnore:
+		if *verbose {
+			log.Printf("Ignoring %d zero-byte files of parameter %s", len(empty), param)
+		}
+		return files, nil
+	}
+
+	log.Printf("Warning: %d files of parameter %s are zero-byte placeholders, treating them as not yet available", len(empty), param)
+	return nonEmpty, nil
+}
+
+// handleEmptyDownload applies the -zero-byte treatment to a planned file
+// whose download or HEAD request yielded no data
+func handleEmptyDownload(f *PlannedFile) {
+	switch *zeroBytePolicy {
+	case zeroByteError:
+		log.Printf("Error downloading %s: %v", f.URL, errEmptyRemote)
+		failedDownloads.Add(1)
+	case zeroByteIgnore:
+		if *verbose {
+			log.Printf("Ignoring zero-byte file %s", f.URL)
+		}
+	default:
+		log.Printf("Warning: %s is a zero-byte placeholder, treating it as not yet available", f.URL)
+		notYetAvailable.mu.Lock()
+		notYetAvailable.urls[f.URL] = f.Param
+		notYetAvailable.mu.Unlock()
+	}
+}
+
+// pendingPlaceholders returns the number of zero-byte placeholders that are
+// still not available
+func pendingPlaceholders() int {
+	notYetAvailable.mu.Lock()
+	defer notYetAvailable.mu.Unlock()
+	return len(notYetAvailable.urls)
+}
+
+// takeNotYetAvailable reports whether a URL was a zero-byte placeholder and
+// forgets it, so that it is planned again
+func takeNotYetAvailable(url string) bool {
+	notYetAvailable.mu.Lock()
+	defer notYetAvailable.mu.Unlock()
+	_, ok := notYetAvailable.urls[url]
+	delete(notYetAvailable.urls, url)
+	return ok
+}
+
+// hasNotYetAvailable reports whether a parameter has zero-byte placeholder files
+func hasNotYetAvailable(param string) bool {
+	notYetAvailable.mu.Lock()
+	defer notYetAvailable.mu.Unlock()
+	for _, p := range notYetAvailable.urls {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}